@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/diagnose"
+	cli "github.com/urfave/cli/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxLogPods bounds the number of agent pods whose logs are copied into a bundle
+	maxLogPods  = 20
+	podLogLines = 2000
+)
+
+// workload summarises a DaemonSet or Deployment for the support bundle.
+type workload struct {
+	Name               string   `json:"name"`
+	Generation         int64    `json:"generation"`
+	ObservedGeneration int64    `json:"observed_generation"`
+	Images             []string `json:"images"`
+	Status             any      `json:"status"`
+}
+
+type podSummary struct {
+	Name     string `json:"name"`
+	Node     string `json:"node"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// diagnoseCommand writes a support bundle for the Kubernetes agent, run inside the updater pod
+// with `kubectl exec deploy/km-fleet-manager -- km-kube-updater diagnose -o /tmp/bundle.tar.gz`.
+func diagnoseCommand(cfg *config.K8sAgentConfig, flags []cli.Flag) *cli.Command {
+	return &cli.Command{
+		Name:  "diagnose",
+		Usage: "Write a support bundle with versions, redacted ConfigMaps, workload status, logs and connectivity checks",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Path of the bundle, defaults to km-kube-updater-diagnose-<host>-<time>.tar.gz in the current directory",
+			},
+		}, flags...),
+		Action: func(c *cli.Context) error {
+			out := c.String("output")
+			if out == "" {
+				out = diagnose.DefaultPath("km-kube-updater")
+			}
+			b, err := diagnose.Create(out)
+			if err != nil {
+				return err
+			}
+			collectDiagnostics(c.Context, b, cfg)
+			if err := b.Close(); err != nil {
+				return fmt.Errorf("failed to write bundle: %w", err)
+			}
+			for _, e := range b.Errors() {
+				fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", e)
+			}
+			fmt.Fprintf(c.App.Writer, "support bundle written to %s\n", out)
+			return nil
+		},
+	}
+}
+
+func collectDiagnostics(ctx context.Context, b *diagnose.Bundle, cfg *config.K8sAgentConfig) {
+	b.AddJSON("version.json", diagnose.BuildInfo(version))
+
+	// live state of the running updater, served next to its health endpoints
+	base := localURL(cfg.HealthAddr)
+	for name, path := range map[string]string{
+		"status/healthz.txt":         "/healthz",
+		"status/readyz.txt":          "/readyz",
+		"status/updater-metrics.txt": "/metrics",
+		"status/loglevel.json":       "/loglevel",
+	} {
+		if data, err := diagnose.Fetch(ctx, base+path); err != nil {
+			b.Fail(name, err)
+		} else {
+			b.Add(name, data)
+		}
+	}
+	// detections are only served on the loopback control API
+	if cfg.ControlAddr != "" {
+		if data, err := diagnose.Fetch(ctx, localURL(cfg.ControlAddr)+"/debug/detections"); err != nil {
+			b.Fail("status/detections.json", err)
+		} else {
+			b.Add("status/detections.json", data)
+		}
+	}
+
+	if err := config.LoadSecrets(cfg.APIKey, cfg.APIKeyFile, cfg.SecretsDir); err != nil {
+		b.Fail("connectivity.json", err)
+	} else if transport, err := cfg.Network.NewTransport(); err != nil {
+		b.Fail("connectivity.json", err)
+	} else {
+		client := &http.Client{Transport: transport}
+		b.AddJSON("connectivity.json", []diagnose.Check{
+			diagnose.CheckEndpoint(ctx, client, "exporter", cfg.ExporterEndpoint),
+			diagnose.CheckEndpoint(ctx, client, "config_check", config.GetAgentConfigUpdaterURL(cfg.ExporterEndpoint)),
+		})
+	}
+
+	client, err := config.NewKubeClient(cfg.Kubeconfig)
+	if err != nil {
+		b.Fail("kubernetes", err)
+		return
+	}
+	collectKubernetes(ctx, b, client, cfg)
+}
+
+func collectKubernetes(ctx context.Context, b *diagnose.Bundle, client kubernetes.Interface, cfg *config.K8sAgentConfig) {
+	ns := cfg.KubeNamespace
+	for _, name := range []string{cfg.ConfigmapDaemonsetName, cfg.ConfigmapDeploymentName} {
+		if name == "" {
+			continue
+		}
+		cm, err := client.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			b.Fail("configmaps/"+name, err)
+			continue
+		}
+		for key, data := range cm.Data {
+			file := fmt.Sprintf("configmaps/%s/%s", name, key)
+			redacted, err := diagnose.RedactYAML([]byte(data))
+			if err != nil {
+				b.Fail(file, err)
+				continue
+			}
+			b.Add(file, redacted)
+		}
+	}
+
+	var selectors []*metav1.LabelSelector
+	if cfg.DaemonSetName != "" {
+		if ds, err := client.AppsV1().DaemonSets(ns).Get(ctx, cfg.DaemonSetName, metav1.GetOptions{}); err != nil {
+			b.Fail("workloads/daemonset.json", err)
+		} else {
+			b.AddJSON("workloads/daemonset.json", workload{
+				Name: ds.Name, Generation: ds.Generation, ObservedGeneration: ds.Status.ObservedGeneration,
+				Images: images(ds.Spec.Template.Spec), Status: ds.Status,
+			})
+			selectors = append(selectors, ds.Spec.Selector)
+		}
+	}
+	if cfg.DeploymentName != "" {
+		if d, err := client.AppsV1().Deployments(ns).Get(ctx, cfg.DeploymentName, metav1.GetOptions{}); err != nil {
+			b.Fail("workloads/deployment.json", err)
+		} else {
+			b.AddJSON("workloads/deployment.json", workload{
+				Name: d.Name, Generation: d.Generation, ObservedGeneration: d.Status.ObservedGeneration,
+				Images: images(d.Spec.Template.Spec), Status: d.Status,
+			})
+			selectors = append(selectors, d.Spec.Selector)
+		}
+	}
+
+	var pods []corev1.Pod
+	for _, sel := range selectors {
+		s, err := metav1.LabelSelectorAsSelector(sel)
+		if err != nil {
+			continue
+		}
+		list, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: s.String()})
+		if err != nil {
+			b.Fail("workloads/pods.json", err)
+			continue
+		}
+		pods = append(pods, list.Items...)
+	}
+	summaries := make([]podSummary, 0, len(pods))
+	for _, pod := range pods {
+		summaries = append(summaries, summarizePod(pod))
+	}
+	b.AddJSON("workloads/pods.json", summaries)
+
+	for i, pod := range pods {
+		if i >= maxLogPods {
+			break
+		}
+		collectPodLogs(ctx, b, client, pod)
+	}
+}
+
+func collectPodLogs(ctx context.Context, b *diagnose.Bundle, client kubernetes.Interface, pod corev1.Pod) {
+	tail := int64(podLogLines)
+	for _, c := range pod.Spec.Containers {
+		name := fmt.Sprintf("logs/%s/%s.log", pod.Name, c.Name)
+		data, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: c.Name,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		if err != nil {
+			b.Fail(name, err)
+			continue
+		}
+		b.Add(name, data)
+	}
+}
+
+func summarizePod(pod corev1.Pod) podSummary {
+	s := podSummary{Name: pod.Name, Node: pod.Spec.NodeName, Phase: string(pod.Status.Phase), Ready: true}
+	for _, cs := range pod.Status.ContainerStatuses {
+		s.Restarts += cs.RestartCount
+		s.Ready = s.Ready && cs.Ready
+		if cs.State.Waiting != nil && s.Reason == "" {
+			s.Reason = cs.State.Waiting.Reason
+		}
+		if cs.LastTerminationState.Terminated != nil && s.Reason == "" {
+			s.Reason = cs.LastTerminationState.Terminated.Reason
+		}
+	}
+	return s
+}
+
+func images(spec corev1.PodSpec) []string {
+	var out []string
+	for _, c := range spec.Containers {
+		out = append(out, c.Image)
+	}
+	return out
+}
+
+// localURL turns a listen address like :8080 into a loopback URL.
+func localURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr
+}
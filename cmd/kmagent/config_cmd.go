@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/agent"
+	"github.com/kloudmate/km-agent/internal/history"
+	"github.com/kloudmate/km-agent/internal/pin"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/urfave/cli/v2"
+)
+
+// configCommand groups the collector config history subcommands.
+func configCommand(p *Program) *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Inspect, roll back and pin the collector configuration",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List recorded configuration versions",
+				Action: func(c *cli.Context) error {
+					store, err := p.historyStore()
+					if err != nil {
+						return err
+					}
+					entries, err := store.List()
+					if err != nil {
+						return err
+					}
+					w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(w, "VERSION\tTIMESTAMP\tSOURCE\tOUTCOME\tHASH\tDETAIL")
+					for _, e := range entries {
+						fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.12s\t%s\n", e.Version, e.Timestamp.Format("2006-01-02 15:04:05"), e.Source, e.Outcome, e.Hash, e.Detail)
+					}
+					if pause, err := store.Paused(); err == nil && pause != nil {
+						fmt.Fprintf(w, "\nremote updates paused since %s: %s\n", pause.Since.Format("2006-01-02 15:04:05"), pause.Reason)
+					}
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					return p.printPin(c)
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "Show the changes between two versions, or between a version and the current config",
+				ArgsUsage: "<from> [to]",
+				Action: func(c *cli.Context) error {
+					store, err := p.historyStore()
+					if err != nil {
+						return err
+					}
+					from, err := versionArg(c, 0)
+					if err != nil {
+						return err
+					}
+					_, a, err := store.Get(from)
+					if err != nil {
+						return err
+					}
+					toName := p.cfg.OtelConfigPath
+					var b []byte
+					if c.NArg() > 1 {
+						to, err := versionArg(c, 1)
+						if err != nil {
+							return err
+						}
+						if _, b, err = store.Get(to); err != nil {
+							return err
+						}
+						toName = fmt.Sprintf("version %d", to)
+					} else if b, err = os.ReadFile(p.cfg.OtelConfigPath); err != nil {
+						return fmt.Errorf("failed to read current config: %w", err)
+					}
+					return difflib.WriteUnifiedDiff(c.App.Writer, difflib.UnifiedDiff{
+						A:        difflib.SplitLines(string(a)),
+						B:        difflib.SplitLines(string(b)),
+						FromFile: fmt.Sprintf("version %d", from),
+						ToFile:   toName,
+						Context:  3,
+					})
+				},
+			},
+			{
+				Name:      "rollback",
+				Usage:     "Restore a recorded version and pause remote updates until resumed",
+				ArgsUsage: "<version>",
+				Action: func(c *cli.Context) error {
+					if _, err := p.historyStore(); err != nil {
+						return err
+					}
+					version, err := versionArg(c, 0)
+					if err != nil {
+						return err
+					}
+					entry, err := agent.Rollback(p.cfg, version)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(c.App.Writer, "restored version %d as version %d, remote updates are paused until `kmagent config resume`\n", version, entry.Version)
+					p.restartForConfig(c)
+					return nil
+				},
+			},
+			{
+				Name:  "pin",
+				Usage: "Keep the current config, remote updates are reported but not applied",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{Name: "for", Usage: "Unpin automatically after this duration, e.g. 2h"},
+					&cli.StringFlag{Name: "reason", Usage: "Why the config is pinned, shown in the KloudMate UI"},
+				},
+				Action: func(c *cli.Context) error {
+					if _, err := p.historyStore(); err != nil {
+						return err
+					}
+					st := pin.State{Source: pin.SourceCLI, Reason: c.String("reason")}
+					if d := c.Duration("for"); d > 0 {
+						until := time.Now().Add(d).UTC()
+						st.Until = &until
+					}
+					if err := pin.Write(p.cfg.ConfigPinFile, st); err != nil {
+						return err
+					}
+					return p.printPin(c)
+				},
+			},
+			{
+				Name:  "resume",
+				Usage: "Resume applying remote configuration updates",
+				Action: func(c *cli.Context) error {
+					store, err := p.historyStore()
+					if err != nil {
+						return err
+					}
+					if err := store.Resume(); err != nil {
+						return err
+					}
+					// a rollback also pins the config, resuming undoes the whole rollback
+					if st, err := pin.Read(p.cfg.ConfigPinFile); err == nil && st != nil && st.Source == pin.SourceRollback {
+						if err := pin.Clear(p.cfg.ConfigPinFile); err != nil {
+							return err
+						}
+					}
+					fmt.Fprintln(c.App.Writer, "remote configuration updates resumed")
+					return nil
+				},
+			},
+			{
+				Name:  "unpin",
+				Usage: "Remove a pin set with `kmagent config pin`",
+				Action: func(c *cli.Context) error {
+					if _, err := p.historyStore(); err != nil {
+						return err
+					}
+					if err := pin.Clear(p.cfg.ConfigPinFile); err != nil {
+						return err
+					}
+					if p.cfg.PinConfig {
+						fmt.Fprintln(c.App.Writer, "pin file removed, but pin-config is still set in the agent config")
+						return nil
+					}
+					fmt.Fprintln(c.App.Writer, "remote configuration updates resumed")
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// historyStore loads the agent configuration and opens its config history.
+func (p *Program) historyStore() (*history.Store, error) {
+	if err := p.cfg.LoadConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	return history.New(p.cfg.ConfigHistoryDir, p.cfg.ConfigHistorySize), nil
+}
+
+// printPin shows whether remote config updates are currently pinned.
+func (p *Program) printPin(c *cli.Context) error {
+	st, err := pin.Resolve(p.cfg.ConfigPinFile, p.cfg.PinConfig, p.cfg.PinConfigUntil)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		fmt.Fprintln(c.App.Writer, "remote configuration updates are enabled")
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "config pinned by %s since %s", st.Source, st.Since.Format(time.RFC3339))
+	if st.Until != nil {
+		fmt.Fprintf(c.App.Writer, " until %s", st.Until.Format(time.RFC3339))
+	}
+	if st.Reason != "" {
+		fmt.Fprintf(c.App.Writer, ": %s", st.Reason)
+	}
+	fmt.Fprintln(c.App.Writer)
+	return nil
+}
+
+// restartForConfig restarts the installed service so the collector picks up the restored config.
+func (p *Program) restartForConfig(c *cli.Context) {
+	svc, err := makeService(p)
+	if err == nil {
+		err = svc.Restart()
+	}
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "could not restart the agent service (%v), restart it to apply the config\n", err)
+		return
+	}
+	fmt.Fprintln(c.App.Writer, "agent service restarted")
+}
+
+func versionArg(c *cli.Context, i int) (int, error) {
+	v, err := strconv.Atoi(c.Args().Get(i))
+	if err != nil {
+		return 0, fmt.Errorf("invalid config version %q", c.Args().Get(i))
+	}
+	return v, nil
+}
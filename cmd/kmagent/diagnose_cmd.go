@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/diagnose"
+	"github.com/kloudmate/km-agent/internal/history"
+	"github.com/kloudmate/km-agent/internal/inventory"
+	"github.com/kloudmate/km-agent/internal/pin"
+	"github.com/kloudmate/km-agent/internal/shared"
+	"github.com/urfave/cli/v2"
+)
+
+// maxLogBytes bounds how much of each log is copied into a bundle.
+const maxLogBytes = 5 << 20
+
+// diagnoseCommand writes a support bundle for the host or docker agent.
+func diagnoseCommand(p *Program) *cli.Command {
+	return &cli.Command{
+		Name:  "diagnose",
+		Usage: "Write a support bundle with versions, redacted configs, logs, status and connectivity checks",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Path of the bundle, defaults to kmagent-diagnose-<host>-<time>.tar.gz in the current directory",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			out := c.String("output")
+			if out == "" {
+				out = diagnose.DefaultPath("kmagent")
+			}
+			b, err := diagnose.Create(out)
+			if err != nil {
+				return err
+			}
+			p.collectDiagnostics(c.Context, b, c.String("agent-config"))
+			if err := b.Close(); err != nil {
+				return fmt.Errorf("failed to write bundle: %w", err)
+			}
+			for _, e := range b.Errors() {
+				fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", e)
+			}
+			fmt.Fprintf(c.App.Writer, "support bundle written to %s\n", out)
+			return nil
+		},
+	}
+}
+
+func (p *Program) collectDiagnostics(ctx context.Context, b *diagnose.Bundle, agentConfigPath string) {
+	b.AddJSON("version.json", diagnose.BuildInfo(p.version))
+	if components, err := diagnose.Components(shared.Components); err != nil {
+		b.Fail("components.json", err)
+	} else {
+		b.AddJSON("components.json", components)
+	}
+
+	// the remaining sections need the effective configuration
+	if err := p.cfg.LoadConfig(); err != nil {
+		b.Fail("config", err)
+		return
+	}
+	if agentConfigPath != "" {
+		addRedactedYAML(b, "config/agent-config.yaml", agentConfigPath)
+	}
+	addRedactedJSON(b, "config/agent-config-effective.json", p.cfg)
+	addRedactedYAML(b, "config/collector-config.yaml", p.cfg.OtelConfigPath)
+
+	// live state from the running agent's control API
+	if p.cfg.ControlAddr != "" {
+		base := "http://" + p.cfg.ControlAddr
+		for name, path := range map[string]string{"status/agent.json": "/status", "status/loglevel.json": "/loglevel"} {
+			if data, err := diagnose.Fetch(ctx, base+path); err != nil {
+				b.Fail(name, fmt.Errorf("agent control API unavailable, is the agent running? %w", err))
+			} else {
+				b.Add(name, data)
+			}
+		}
+	}
+	// the collector's own metrics, when service::telemetry::metrics is enabled
+	if data, err := diagnose.Fetch(ctx, "http://localhost:8888/metrics"); err == nil {
+		b.Add("status/collector-metrics.txt", data)
+	}
+
+	if entries, err := history.New(p.cfg.ConfigHistoryDir, p.cfg.ConfigHistorySize).List(); err != nil {
+		b.Fail("status/config-history.json", err)
+	} else {
+		b.AddJSON("status/config-history.json", entries)
+	}
+	if st, err := pin.Resolve(p.cfg.ConfigPinFile, p.cfg.PinConfig, p.cfg.PinConfigUntil); err != nil {
+		b.Fail("status/config-pin.json", err)
+	} else {
+		b.AddJSON("status/config-pin.json", st)
+	}
+	if data, err := os.ReadFile(filepath.Join(config.GetDefaultStateDir(), "upgrade.json")); err == nil {
+		b.Add("status/upgrade.json", data)
+	}
+	b.AddJSON("inventory.json", inventory.NewCollector(p.logger, 0).Collect(ctx))
+
+	p.collectLogs(ctx, b)
+
+	transport, err := p.cfg.Network.NewTransport()
+	if err != nil {
+		b.Fail("connectivity.json", err)
+		return
+	}
+	client := &http.Client{Transport: transport}
+	b.AddJSON("connectivity.json", []diagnose.Check{
+		diagnose.CheckEndpoint(ctx, client, "exporter", p.cfg.ExporterEndpoint),
+		diagnose.CheckEndpoint(ctx, client, "config_check", p.cfg.ConfigUpdateURL),
+	})
+}
+
+// collectLogs copies the tail of the log file and, on systemd hosts, of the service journal.
+func (p *Program) collectLogs(ctx context.Context, b *diagnose.Bundle) {
+	if _, err := os.Stat(p.cfg.Logging.File); err == nil {
+		b.AddFileTail("logs/kmagent.log", p.cfg.Logging.File, maxLogBytes)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "journalctl", "-u", "kmagent", "-n", "5000", "--no-pager", "-o", "short-iso").Output()
+	if err != nil {
+		b.Fail("logs/journal.log", err)
+		return
+	}
+	if len(out) > maxLogBytes {
+		out = out[len(out)-maxLogBytes:]
+	}
+	b.Add("logs/journal.log", out)
+}
+
+func addRedactedYAML(b *diagnose.Bundle, name, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	redacted, err := diagnose.RedactYAML(data)
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	b.Add(name, redacted)
+}
+
+func addRedactedJSON(b *diagnose.Bundle, name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		b.Fail(name, err)
+		return
+	}
+	b.AddJSON(name, diagnose.Redact(doc))
+}
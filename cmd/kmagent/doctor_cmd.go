@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kloudmate/km-agent/internal/doctor"
+	"github.com/urfave/cli/v2"
+)
+
+// doctorCommand runs connectivity and permission preflight checks, exiting non-zero when one fails.
+func doctorCommand(p *Program) *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check connectivity to KloudMate and access to log files, journald and Docker",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the results as JSON",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := p.cfg.LoadConfig(); err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			d, err := doctor.New(p.cfg)
+			if err != nil {
+				return err
+			}
+			results := d.Run(c.Context)
+			if c.Bool("json") {
+				enc := json.NewEncoder(c.App.Writer)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(results); err != nil {
+					return err
+				}
+			} else {
+				doctor.Print(c.App.Writer, results)
+			}
+			if doctor.Failed(results) {
+				return cli.Exit("", 1)
+			}
+			return nil
+		},
+	}
+}
@@ -30,6 +30,7 @@ func main() {
 
 	if err = agent.StartAgent(appCtx); err != nil {
 		agent.Logger.Errorf("agent could not be started with current config : %s", err.Error())
+		agent.FlushLogs()
 	}
 
 	agent.AwaitShutdown()
@@ -48,3 +48,52 @@ Create the name of the chart.
 {{- define "km-kube-agent.chart" -}}
 {{- printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" }}
 {{- end }}
+
+{{- /*
+API key environment. When apiKeySecret.name is set the key is mounted from the Secret
+and read from file, so rotating the Secret is picked up without restarting the pods.
+*/}}
+{{- define "km-kube-agent.apiKeyEnv" -}}
+{{- if .Values.apiKeySecret.name }}
+- name: KM_API_KEY_FILE
+  value: "/etc/kmagent/secrets/{{ .Values.apiKeySecret.key }}"
+{{- else }}
+- name: KM_API_KEY
+  value: {{ required "Api Key is required" .Values.API_KEY }}
+{{- end }}
+{{- end }}
+
+{{- define "km-kube-agent.apiKeyVolumeMount" -}}
+{{- if .Values.apiKeySecret.name }}
+- name: api-key-secret
+  mountPath: /etc/kmagent/secrets
+  readOnly: true
+{{- end }}
+{{- end }}
+
+{{- define "km-kube-agent.apiKeyVolume" -}}
+{{- if .Values.apiKeySecret.name }}
+- name: api-key-secret
+  secret:
+    secretName: {{ .Values.apiKeySecret.name }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Proxy and TLS settings for calls to KloudMate, used by the updater and by the agents when
+they ship their own logs.
+*/}}
+{{- define "km-kube-agent.networkEnv" -}}
+- name: KM_PROXY_URL
+  value: {{ .Values.network.proxyUrl | quote }}
+- name: KM_NO_PROXY
+  value: {{ .Values.network.noProxy | quote }}
+- name: KM_CA_FILE
+  value: {{ .Values.network.caFile | quote }}
+- name: KM_CLIENT_CERT_FILE
+  value: {{ .Values.network.clientCertFile | quote }}
+- name: KM_CLIENT_KEY_FILE
+  value: {{ .Values.network.clientKeyFile | quote }}
+- name: KM_TLS_MIN_VERSION
+  value: {{ .Values.network.minTlsVersion | quote }}
+{{- end }}
@@ -3,15 +3,26 @@ package agent
 import (
 	"context"
 	"fmt"
-	"os"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/kloudmate/km-agent/internal/buffer"
 	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/dockerdiscovery"
+	"github.com/kloudmate/km-agent/internal/history"
+	"github.com/kloudmate/km-agent/internal/inventory"
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/pin"
+	"github.com/kloudmate/km-agent/internal/policy"
+	"github.com/kloudmate/km-agent/internal/secrets"
+	"github.com/kloudmate/km-agent/internal/signature"
 	"github.com/kloudmate/km-agent/internal/updater"
+	"github.com/kloudmate/km-agent/internal/upgrade"
+	"go.opentelemetry.io/collector/confmap"
 	"go.opentelemetry.io/collector/otelcol"
 	"go.uber.org/zap"
 )
@@ -28,6 +39,14 @@ type Agent struct {
 	isRunning      atomic.Bool
 	collectorError string
 	version        string
+	upgrader       *upgrade.Upgrader
+	policy         *policy.Policy
+	history        *history.Store
+	inventory      *inventory.Collector
+	docker         *dockerdiscovery.Watcher
+	restartFunc    func() error
+	stateDir       string
+	lastCheckOK    atomic.Bool
 }
 
 type Option func(a *Agent)
@@ -38,20 +57,72 @@ func WithVersion(v string) Option {
 	}
 }
 
+// WithRestartFunc sets how the agent restarts itself through the service manager after a self-upgrade.
+func WithRestartFunc(fn func() error) Option {
+	return func(a *Agent) {
+		a.restartFunc = fn
+	}
+}
+
+// WithStateDir sets where upgrade state is kept, defaults to config.GetDefaultStateDir.
+func WithStateDir(dir string) Option {
+	return func(a *Agent) {
+		a.stateDir = dir
+	}
+}
+
 // New creates a new Agent instance
 func New(cfg *config.Config, logger *zap.SugaredLogger, opts ...Option) (*Agent, error) {
-	configUpdater := updater.NewConfigUpdater(cfg, logger)
+	configUpdater, err := updater.NewConfigUpdater(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	p, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
 	a := Agent{
 		cfg:            cfg,
 		logger:         logger,
 		updater:        configUpdater,
+		policy:         p,
+		history:        history.New(cfg.ConfigHistoryDir, cfg.ConfigHistorySize),
+		inventory:      inventory.NewCollector(logger, time.Duration(cfg.InventoryInterval)*time.Second),
 		shutdownSignal: make(chan struct{}),
+		stateDir:       config.GetDefaultStateDir(),
 	}
 
 	for _, o := range opts {
 		o(&a)
 	}
 
+	if cfg.DockerMode {
+		a.docker, err = dockerdiscovery.NewWatcher(cfg.DockerEndpoint, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// docker images are upgraded by pulling a new image, not by replacing the binary, and
+	// self-upgrade is opt-in through a pinned signing key
+	if !cfg.DockerMode && cfg.UpgradePublicKeyFile != "" {
+		transport, err := cfg.Network.NewTransport()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure upgrade transport: %w", err)
+		}
+		verifier, err := signature.LoadVerifier(cfg.UpgradePublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upgrade signing key: %w", err)
+		}
+		a.upgrader, err = upgrade.New(a.version, &http.Client{Transport: transport}, verifier, logger,
+			upgrade.WithStateDir(a.stateDir),
+			upgrade.WithRestartFunc(a.restartFunc),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &a, nil
 }
 
@@ -69,7 +140,48 @@ func (a *Agent) StartAgent(ctx context.Context) error {
 		}
 	}()
 
-	a.wg.Add(2)
+	if err := a.watchSecrets(ctx); err != nil {
+		return fmt.Errorf("failed to watch secrets: %w", err)
+	}
+	kmlogger.WatchLevelSignals(ctx, a.logger)
+	a.startControlServer(ctx)
+
+	pendingUpgrade := false
+	if a.upgrader != nil {
+		var err error
+		if pendingUpgrade, err = a.upgrader.Resume(); err != nil {
+			a.logger.Errorf("Failed to resume agent upgrade: %v", err)
+		}
+	}
+
+	if a.docker != nil {
+		// pick up labelled containers before the first collector start to avoid an immediate restart
+		syncCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := a.docker.Sync(syncCtx); err != nil {
+			a.logger.Warnw("initial docker discovery failed", "error", err)
+		}
+		cancel()
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.watchDocker(ctx)
+		}()
+	}
+
+	a.wg.Add(3)
+	go func() {
+		defer a.wg.Done()
+		invCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-a.shutdownSignal:
+				cancel()
+			case <-invCtx.Done():
+			}
+		}()
+		a.inventory.Run(invCtx)
+	}()
 	go func() {
 		defer a.wg.Done()
 		if err := a.manageCollectorLifecycle(ctx); err != nil {
@@ -80,6 +192,13 @@ func (a *Agent) StartAgent(ctx context.Context) error {
 		defer a.wg.Done()
 		a.runConfigUpdateChecker(ctx)
 	}()
+	if pendingUpgrade {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.verifyUpgrade(ctx)
+		}()
+	}
 	a.logger.Info("agent start sequence initiated")
 	setupComplete = true
 	return nil
@@ -111,6 +230,21 @@ func (a *Agent) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// watchSecrets keeps the secret store in sync with the API key file and secrets directory.
+// The collector resolves secrets through a watched confmap provider, so rotations are applied
+// by a config reload without restarting the agent.
+func (a *Agent) watchSecrets(ctx context.Context) error {
+	if a.cfg.SecretsDir != "" {
+		if err := secrets.Default().WatchDir(ctx, a.cfg.SecretsDir, a.logger); err != nil {
+			return err
+		}
+	}
+	if a.cfg.APIKeyFile != "" {
+		return secrets.Default().WatchFile(ctx, secrets.APIKey, a.cfg.APIKeyFile, a.logger)
+	}
+	return nil
+}
+
 func (a *Agent) manageCollectorLifecycle(ctx context.Context) error {
 	// Initial check to exit early and avoid unnecessary work.
 	if !a.isRunning.Load() {
@@ -119,9 +253,13 @@ func (a *Agent) manageCollectorLifecycle(ctx context.Context) error {
 	}
 
 	// Create the collector instance.
-	collector, err := NewCollector(a.cfg)
+	collector, err := NewCollector(a.cfg, a.converters()...)
 	if err != nil {
-		return fmt.Errorf("failed to create new collector instance: %w", err)
+		err = fmt.Errorf("failed to create new collector instance: %w", err)
+		a.collectorMu.Lock()
+		a.collectorError = err.Error()
+		a.collectorMu.Unlock()
+		return err
 	}
 
 	// This deferred function will run when manageCollectorLifecycle exits for any reason.
@@ -151,16 +289,65 @@ func (a *Agent) manageCollectorLifecycle(ctx context.Context) error {
 	a.logger.Info("collector instance created, starting run loop")
 	runErr := collector.Run(ctx)
 	if runErr != nil {
-		a.collectorError = runErr.Error()
 		a.logger.Errorw("collector run loop exited with error", "error", runErr)
 	} else {
-		a.collectorError = ""
 		a.logger.Info("collector run loop exited normally")
 	}
 
+	// a collector replaced by a restart exits after its successor started, leave its state alone
+	a.collectorMu.Lock()
+	if a.collector == collector {
+		a.collectorError = ""
+		if runErr != nil {
+			a.collectorError = runErr.Error()
+		}
+	}
+	a.collectorMu.Unlock()
+
 	return runErr
 }
 
+// converters returns the config converters contributed by the agent's discovery sources.
+func (a *Agent) converters() []confmap.ConverterFactory {
+	if a.docker == nil {
+		return nil
+	}
+	return []confmap.ConverterFactory{dockerdiscovery.NewConverterFactory(a.docker)}
+}
+
+// watchDocker restarts the collector whenever the set of labelled containers changes.
+func (a *Agent) watchDocker(ctx context.Context) {
+	go a.docker.Run(ctx)
+	for {
+		select {
+		case <-a.docker.Changes():
+			a.logger.Infow("docker containers changed, restarting collector", "targets", len(a.docker.Targets()))
+			a.restartCollector(ctx)
+		case <-a.shutdownSignal:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// restartCollector stops the running collector and starts a new one with a freshly resolved config.
+func (a *Agent) restartCollector(agentCtx context.Context) {
+	if !a.isRunning.Load() {
+		a.logger.Info("agent shutting down, skipping restart")
+		return
+	}
+
+	a.stopCollectorInstance()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.manageCollectorLifecycle(agentCtx); err == nil {
+			a.logger.Info("collector restarted successfully")
+		}
+	}()
+}
+
 func (a *Agent) stopCollectorInstance() {
 	a.collectorMu.Lock()
 	collector := a.collector
@@ -175,22 +362,38 @@ func (a *Agent) stopCollectorInstance() {
 }
 
 // UpdateConfig takes new config and create new otel config file and update existing config file.
+// The previous file and the new config are kept in the config history.
 func (a *Agent) UpdateConfig(_ context.Context, newConfig map[string]interface{}) error {
 	configYAML, err := yaml.Marshal(newConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal new config to YAML: %w", err)
 	}
-	tempFile := a.cfg.OtelConfigPath + ".new"
-	if err := os.WriteFile(tempFile, configYAML, 0644); err != nil {
-		return fmt.Errorf("failed to write new config to temporary file: %w", err)
+	if err := a.policy.Evaluate(newConfig); err != nil {
+		a.recordHistory(configYAML, history.OutcomeRejected, err)
+		return err
+	}
+	if err := a.history.RecordFile(a.cfg.OtelConfigPath, history.SourceLocal); err != nil {
+		a.logger.Warnw("failed to snapshot current collector configuration", "error", err)
 	}
-	if err := os.Rename(tempFile, a.cfg.OtelConfigPath); err != nil {
-		return fmt.Errorf("failed to replace config file: %w", err)
+	if err := history.WriteFile(a.cfg.OtelConfigPath, configYAML); err != nil {
+		a.recordHistory(configYAML, history.OutcomeFailed, err)
+		return err
 	}
+	a.recordHistory(configYAML, history.OutcomeApplied, nil)
 	a.logger.Infow("collector configuration updated", "configPath", a.cfg.OtelConfigPath)
 	return nil
 }
 
+func (a *Agent) recordHistory(configYAML []byte, outcome string, cause error) {
+	detail := ""
+	if cause != nil {
+		detail = cause.Error()
+	}
+	if _, err := a.history.Record(configYAML, history.SourceRemote, outcome, detail); err != nil {
+		a.logger.Warnw("failed to record collector configuration history", "error", err)
+	}
+}
+
 // runConfigUpdateChecker run ticker for performConfigCheck
 func (a *Agent) runConfigUpdateChecker(ctx context.Context) {
 	if a.cfg.ConfigUpdateURL == "" {
@@ -229,6 +432,89 @@ func (a *Agent) runConfigUpdateChecker(ctx context.Context) {
 	}
 }
 
+// upgradeHealthTimeout is how long a freshly upgraded agent has to become healthy before it is rolled back.
+const upgradeHealthTimeout = 3 * time.Minute
+
+// verifyUpgrade confirms a pending self-upgrade once the collector is running and, when remote
+// config is enabled, a config check succeeded. Otherwise the previous binary is restored.
+func (a *Agent) verifyUpgrade(ctx context.Context) {
+	deadline := time.NewTimer(upgradeHealthTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if a.healthy() {
+				if err := a.upgrader.Confirm(); err != nil {
+					a.logger.Errorf("Failed to confirm agent upgrade: %v", err)
+				}
+				return
+			}
+		case <-deadline.C:
+			a.collectorMu.Lock()
+			collectorError := a.collectorError
+			a.collectorMu.Unlock()
+			if err := a.upgrader.Rollback(fmt.Errorf("agent not healthy within %s: %s", upgradeHealthTimeout, collectorError)); err != nil {
+				a.logger.Errorf("Failed to roll back agent upgrade: %v", err)
+			}
+			return
+		case <-a.shutdownSignal:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Agent) healthy() bool {
+	a.collectorMu.Lock()
+	running := a.collector != nil && a.collector.GetState() == otelcol.StateRunning
+	a.collectorMu.Unlock()
+	if !running {
+		return false
+	}
+	return a.cfg.ConfigUpdateURL == "" || a.cfg.ConfigCheckInterval <= 0 || a.lastCheckOK.Load()
+}
+
+// applyUpgrade runs a server requested self-upgrade outside the config check timeout.
+func (a *Agent) applyUpgrade(agentCtx context.Context, ins upgrade.Instruction) {
+	if a.upgrader == nil {
+		a.logger.Warnw("ignoring agent upgrade request, self-upgrade needs upgrade-public-key and is not supported in docker mode", "version", ins.Version)
+		return
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ctx, cancel := context.WithTimeout(agentCtx, 10*time.Minute)
+		defer cancel()
+		if err := a.upgrader.Apply(ctx, ins); err != nil && err != upgrade.ErrInProgress {
+			a.logger.Errorf("Agent upgrade failed: %v", err)
+		}
+	}()
+}
+
+// bufferStatus reports the disk usage of the persistent buffer against its cap.
+func (a *Agent) bufferStatus() *updater.BufferStatus {
+	settings := bufferSettings(a.cfg)
+	status := &updater.BufferStatus{
+		Dir:        settings.Dir,
+		LimitBytes: settings.MaxSizeBytes,
+	}
+	usage, err := buffer.Usage(settings.Dir)
+	if err != nil {
+		a.logger.Warnw("failed to measure persistent buffer usage", "dir", settings.Dir, "error", err)
+		return status
+	}
+	status.UsageBytes = usage
+	if settings.MaxSizeBytes > 0 && usage >= settings.MaxSizeBytes {
+		a.logger.Warnw("persistent buffer is full, new telemetry will be dropped until the backlog is sent",
+			"usageBytes", usage, "limitBytes", settings.MaxSizeBytes)
+	}
+	return status
+}
+
 // performConfigCheck checks remote server for new config and restart collector if required
 func (a *Agent) performConfigCheck(agentCtx context.Context) error {
 	ctx, cancel := context.WithTimeout(agentCtx, 10*time.Second)
@@ -254,34 +540,53 @@ func (a *Agent) performConfigCheck(agentCtx context.Context) error {
 		params.AgentStatus = "Stopped"
 	}
 
+	if a.cfg.Buffering.Enabled {
+		params.Buffer = a.bufferStatus()
+	}
+	if a.upgrader != nil {
+		params.Upgrade = a.upgrader.Status()
+	}
+	params.Inventory = a.inventory.Get()
+	pinned, err := pin.Resolve(a.cfg.ConfigPinFile, a.cfg.PinConfig, a.cfg.PinConfigUntil)
+	if err != nil {
+		a.logger.Warnw("failed to read config pin, treating config as pinned", "error", err)
+		pinned = &pin.State{Source: pin.SourceFile, Reason: err.Error()}
+	}
+	params.Pin = pinned
+
 	a.logger.Debugf("Checking for updates with params: %+v", params)
 
-	restart, newConfig, err := a.updater.CheckForUpdates(ctx, params)
+	resp, err := a.updater.CheckForUpdates(ctx, params)
 	if err != nil {
 		return fmt.Errorf("updater.CheckForUpdates failed: %w", err)
 	}
-	if newConfig != nil && restart {
-		if err := a.UpdateConfig(ctx, newConfig); err != nil {
+	a.lastCheckOK.Store(true)
+	if changed, err := kmlogger.ApplyRemoteLevel(resp.LogLevel); err != nil {
+		a.logger.Warnw("ignoring log level from config check", "error", err)
+	} else if changed {
+		a.logger.Infow("log level changed by control plane", "level", resp.LogLevel)
+	}
+	if resp.AgentUpgrade != nil && resp.AgentUpgrade.Version != a.version {
+		a.applyUpgrade(agentCtx, *resp.AgentUpgrade)
+	}
+	if resp.Config != nil && resp.RestartRequired {
+		if paused, err := a.history.Paused(); err != nil || paused != nil {
+			a.logger.Infow("remote configuration updates are paused, keeping current configuration", "pause", paused, "error", err)
+			return nil
+		}
+		if pinned != nil {
+			a.logger.Infow("collector configuration is pinned, skipping remote update",
+				"source", pinned.Source, "reason", pinned.Reason, "until", pinned.Until)
+			return nil
+		}
+		if err := a.UpdateConfig(ctx, resp.Config); err != nil {
+			a.collectorMu.Lock()
 			a.collectorError = err.Error()
+			a.collectorMu.Unlock()
 			return fmt.Errorf("failed to update config file: %w", err)
 		}
 		a.logger.Info("configuration changed, restarting collector")
-		if !a.isRunning.Load() {
-			a.logger.Info("agent shutting down, skipping restart")
-			return nil
-		}
-
-		a.stopCollectorInstance()
-		a.wg.Add(1)
-		go func() {
-			defer a.wg.Done()
-			if err := a.manageCollectorLifecycle(agentCtx); err != nil {
-				a.collectorError = err.Error()
-			} else {
-				a.logger.Info("collector restarted successfully")
-				a.collectorError = ""
-			}
-		}()
+		a.restartCollector(agentCtx)
 	} else {
 		a.logger.Debug("no configuration change detected")
 	}
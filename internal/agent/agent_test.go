@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/history"
+	"github.com/kloudmate/km-agent/internal/kmtest"
+	"github.com/kloudmate/km-agent/internal/pin"
+	"github.com/kloudmate/km-agent/internal/updater"
+	"go.uber.org/zap/zaptest"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	testAPIKey  = "test-api-key"
+	waitTimeout = 30 * time.Second
+)
+
+type harness struct {
+	agent *Agent
+	cfg   *config.Config
+	cp    *kmtest.ControlPlane
+	sink  *kmtest.OTLPSink
+}
+
+// collectorConfig receives OTLP/HTTP on receiverAddr and exports traces to the sink.
+func collectorConfig(receiverAddr, sinkEndpoint string) map[string]any {
+	return map[string]any{
+		"receivers": map[string]any{
+			"otlp": map[string]any{"protocols": map[string]any{"http": map[string]any{"endpoint": receiverAddr}}},
+		},
+		"exporters": map[string]any{
+			"otlphttp": map[string]any{
+				"endpoint": sinkEndpoint,
+				"headers":  map[string]any{"Authorization": "${env:KM_API_KEY}"},
+			},
+		},
+		"service": map[string]any{
+			"telemetry": map[string]any{"metrics": map[string]any{"level": "none"}},
+			"pipelines": map[string]any{
+				"traces": map[string]any{"receivers": []any{"otlp"}, "exporters": []any{"otlphttp"}},
+			},
+		},
+	}
+}
+
+// startAgent boots an agent against a fake control plane with a collector listening on receiverAddr.
+func startAgent(t *testing.T, receiverAddr string) *harness {
+	t.Helper()
+	dir := t.TempDir()
+	h := &harness{cp: kmtest.NewControlPlane(t), sink: kmtest.NewOTLPSink(t)}
+	h.cp.RequireAPIKey(testAPIKey)
+
+	data, err := yaml.Marshal(collectorConfig(receiverAddr, h.sink.Endpoint()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.cfg = &config.Config{
+		OtelConfigPath:      filepath.Join(dir, "config.yaml"),
+		ExporterEndpoint:    h.sink.Endpoint(),
+		ConfigUpdateURL:     h.cp.URL(),
+		APIKey:              testAPIKey,
+		ConfigCheckInterval: 1,
+		ConfigHistoryDir:    filepath.Join(dir, "history"),
+		ConfigPinFile:       filepath.Join(dir, "config.pinned"),
+	}
+	if err := os.WriteFile(h.cfg.OtelConfigPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadSecrets(h.cfg.APIKey, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	h.agent, err = New(h.cfg, zaptest.NewLogger(t).Sugar(), WithVersion("test"), WithStateDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := h.agent.StartAgent(ctx); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, done := context.WithTimeout(context.Background(), waitTimeout)
+		defer done()
+		if err := h.agent.Shutdown(shutdownCtx); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		cancel()
+	})
+	return h
+}
+
+// waitForPipeline retries exporting through the collector at addr until the spans reach the sink.
+func (h *harness) waitForPipeline(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for kmtest.ExportSpans("http://"+addr, 1) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("collector not receiving on %s within %s", addr, waitTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	before := h.sink.Spans()
+	if err := kmtest.ExportSpans("http://"+addr, 3); err != nil {
+		t.Fatal(err)
+	}
+	h.sink.WaitFor(waitTimeout, "spans", func() bool { return h.sink.Spans() >= before+3 })
+}
+
+func statusIs(agent, collector string) func(kmtest.Request) bool {
+	return func(r kmtest.Request) bool {
+		return r.Status("agent_status") == agent && r.Status("collector_status") == collector
+	}
+}
+
+func TestAgentAppliesRemoteConfig(t *testing.T) {
+	first, second := kmtest.FreeAddr(t), kmtest.FreeAddr(t)
+	h := startAgent(t, first)
+	h.waitForPipeline(t, first)
+
+	req := h.cp.WaitForRequest(waitTimeout, statusIs("Running", "Running"))
+	if got := req.Header.Get("Authorization"); got != testAPIKey {
+		t.Errorf("config check sent Authorization %q, want %q", got, testAPIKey)
+	}
+	if got := req.Status("agent_version"); got != "test" {
+		t.Errorf("agent_version = %q, want test", got)
+	}
+	for _, header := range h.sink.Headers() {
+		if got := header.Get("Authorization"); got != testAPIKey {
+			t.Fatalf("exporter sent Authorization %q, want %q", got, testAPIKey)
+		}
+	}
+
+	h.cp.Enqueue(updater.ConfigUpdateResponse{RestartRequired: true, Config: collectorConfig(second, h.sink.Endpoint())})
+	h.waitForPipeline(t, second)
+
+	data, err := os.ReadFile(h.cfg.OtelConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), second) {
+		t.Errorf("config file does not contain the pushed receiver endpoint %s:\n%s", second, data)
+	}
+	entries, err := h.agent.history.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Source != history.SourceLocal || entries[1].Source != history.SourceRemote {
+		t.Errorf("unexpected history %+v, want the local config followed by the remote one", entries)
+	}
+	if s := h.agent.Status(); !s.AgentRunning || !s.CollectorRunning || !s.LastConfigCheckOK {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestAgentRollbackAfterBrokenConfig(t *testing.T) {
+	addr := kmtest.FreeAddr(t)
+	h := startAgent(t, addr)
+	h.waitForPipeline(t, addr)
+
+	// an unknown exporter passes the policy but fails when the collector starts
+	broken := collectorConfig(kmtest.FreeAddr(t), h.sink.Endpoint())
+	broken["exporters"] = map[string]any{"doesnotexist": map[string]any{}}
+	broken["service"].(map[string]any)["pipelines"] = map[string]any{
+		"traces": map[string]any{"receivers": []any{"otlp"}, "exporters": []any{"doesnotexist"}},
+	}
+	h.cp.Enqueue(updater.ConfigUpdateResponse{RestartRequired: true, Config: broken})
+
+	h.cp.WaitForRequest(waitTimeout, func(r kmtest.Request) bool {
+		return statusIs("Running", "Stopped")(r) && strings.Contains(r.Status("last_error_message"), "doesnotexist")
+	})
+
+	entries, err := h.agent.history.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].Source != history.SourceLocal {
+		t.Fatalf("local config missing from history %+v", entries)
+	}
+	if _, err := Rollback(h.cfg, entries[0].Version); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := pin.Read(h.cfg.ConfigPinFile); err != nil || st == nil || st.Source != pin.SourceRollback {
+		t.Fatalf("pin after rollback = %+v, %v", st, err)
+	}
+	// `kmagent config rollback` restarts the service, which starts the collector with the restored config
+	h.agent.restartCollector(context.Background())
+	h.waitForPipeline(t, addr)
+	h.cp.WaitForRequest(waitTimeout, statusIs("Running", "Running"))
+
+	// remote configs are reported but not applied while the rollback pin is in place
+	before, err := os.ReadFile(h.cfg.OtelConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.cp.Respond(updater.ConfigUpdateResponse{RestartRequired: true, Config: collectorConfig(kmtest.FreeAddr(t), h.sink.Endpoint())})
+	// the first new check is answered with the update, the second one follows its processing
+	pinned := func(r kmtest.Request) bool { return r.Body["config_pinned"] == true }
+	h.cp.WaitForNewRequest(waitTimeout, pinned)
+	h.cp.WaitForNewRequest(waitTimeout, pinned)
+	after, err := os.ReadFile(h.cfg.OtelConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("pinned config was replaced by a remote update")
+	}
+}
+
+func TestRollbackUnknownVersionRemovesPin(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		OtelConfigPath:   filepath.Join(dir, "config.yaml"),
+		ConfigHistoryDir: filepath.Join(dir, "history"),
+		ConfigPinFile:    filepath.Join(dir, "config.pinned"),
+	}
+	if err := os.WriteFile(cfg.OtelConfigPath, []byte("receivers: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rollback(cfg, 42); err == nil {
+		t.Fatal("Rollback() to an unknown version succeeded")
+	}
+	if st, err := pin.Read(cfg.ConfigPinFile); err != nil || st != nil {
+		t.Errorf("pin after failed rollback = %+v, %v, want none", st, err)
+	}
+}
@@ -1,12 +1,30 @@
 package agent
 
 import (
+	"github.com/kloudmate/km-agent/internal/buffer"
 	"github.com/kloudmate/km-agent/internal/config"
 	"github.com/kloudmate/km-agent/internal/shared"
+	"go.opentelemetry.io/collector/confmap"
 	"go.opentelemetry.io/collector/otelcol"
 )
 
-func NewCollector(c *config.Config) (*otelcol.Collector, error) {
-	collectorSettings := shared.CollectorInfoFactory(c.OtelConfigPath)
+// NewCollector creates a collector for the agent config, extra converters run after the built-in ones.
+func NewCollector(c *config.Config, extra ...confmap.ConverterFactory) (*otelcol.Collector, error) {
+	var converters []confmap.ConverterFactory
+	if c.Buffering.Enabled {
+		converters = append(converters, buffer.NewConverterFactory(bufferSettings(c)))
+	}
+	if c.Network.ProxyURL != "" {
+		converters = append(converters, c.Network.ProxyConverterFactory())
+	}
+	converters = append(converters, extra...)
+	collectorSettings := shared.CollectorInfoFactory(c.OtelConfigPath, converters...)
 	return otelcol.NewCollector(collectorSettings)
 }
+
+func bufferSettings(c *config.Config) buffer.Settings {
+	return buffer.Settings{
+		Dir:          c.Buffering.Dir,
+		MaxSizeBytes: int64(c.Buffering.MaxSizeMB) * 1024 * 1024,
+	}
+}
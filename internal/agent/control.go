@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/updater"
+	"github.com/kloudmate/km-agent/internal/upgrade"
+	"github.com/kloudmate/km-agent/internal/version"
+)
+
+// Status is the live state of the agent served on /status of the control API.
+type Status struct {
+	Version           string                `json:"version"`
+	CollectorVersion  string                `json:"collector_version"`
+	AgentRunning      bool                  `json:"agent_running"`
+	CollectorRunning  bool                  `json:"collector_running"`
+	CollectorError    string                `json:"collector_error,omitempty"`
+	LastConfigCheckOK bool                  `json:"last_config_check_ok"`
+	LogLevel          string                `json:"log_level"`
+	ConfigPath        string                `json:"config_path"`
+	Upgrade           *upgrade.Status       `json:"upgrade,omitempty"`
+	Buffer            *updater.BufferStatus `json:"buffer,omitempty"`
+	DockerContainers  []string              `json:"docker_containers,omitempty"`
+}
+
+// Status returns the live state of the agent.
+func (a *Agent) Status() Status {
+	s := Status{
+		Version:           a.version,
+		CollectorVersion:  version.GetCollectorVersion(),
+		AgentRunning:      a.isRunning.Load(),
+		LastConfigCheckOK: a.lastCheckOK.Load(),
+		LogLevel:          kmlogger.Level().String(),
+		ConfigPath:        a.cfg.OtelConfigPath,
+	}
+	a.collectorMu.Lock()
+	s.CollectorRunning = a.collector != nil
+	s.CollectorError = a.collectorError
+	a.collectorMu.Unlock()
+	if a.upgrader != nil {
+		s.Upgrade = a.upgrader.Status()
+	}
+	if a.cfg.Buffering.Enabled {
+		s.Buffer = a.bufferStatus()
+	}
+	if a.docker != nil {
+		for _, t := range a.docker.Targets() {
+			s.DockerContainers = append(s.DockerContainers, t.Name)
+		}
+	}
+	return s
+}
+
+// startControlServer serves the local control API on the configured loopback address:
+//
+//	GET  /loglevel                      current level
+//	PUT  /loglevel {"level":"debug"}    change the agent and collector log level
+//	GET  /status                        agent and collector status, see Status
+//
+// A failure to bind is logged and does not stop the agent.
+func (a *Agent) startControlServer(ctx context.Context) {
+	if a.cfg.ControlAddr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", a.cfg.ControlAddr)
+	if err != nil {
+		a.logger.Warnw("failed to start local control API", "addr", a.cfg.ControlAddr, "error", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/loglevel", kmlogger.LevelHandler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.Status())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Errorw("local control API stopped", "error", err)
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-a.shutdownSignal:
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	a.logger.Infow("local control API listening", "addr", ln.Addr().String())
+}
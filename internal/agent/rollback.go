@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/history"
+	"github.com/kloudmate/km-agent/internal/pin"
+)
+
+// Rollback restores a recorded collector config version, as `kmagent config rollback` does. The
+// config is pinned before it is restored so a config check of the running agent cannot overwrite
+// it, and the pin is removed again when the restore fails. The collector picks up the restored
+// config on its next start.
+func Rollback(cfg *config.Config, version int) (history.Entry, error) {
+	err := pin.Write(cfg.ConfigPinFile, pin.State{
+		Source: pin.SourceRollback,
+		Reason: fmt.Sprintf("rolled back to version %d", version),
+	})
+	if err != nil {
+		return history.Entry{}, fmt.Errorf("failed to pin config for rollback: %w", err)
+	}
+	entry, err := history.New(cfg.ConfigHistoryDir, cfg.ConfigHistorySize).Rollback(version, cfg.OtelConfigPath)
+	if err != nil {
+		if clearErr := pin.Clear(cfg.ConfigPinFile); clearErr != nil {
+			return history.Entry{}, fmt.Errorf("%w, and failed to remove the rollback pin: %v", err, clearErr)
+		}
+		return history.Entry{}, err
+	}
+	return entry, nil
+}
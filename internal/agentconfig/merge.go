@@ -0,0 +1,128 @@
+package agentconfig
+
+import (
+	"github.com/kloudmate/km-agent/internal/instrumentation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Settings are the monitored scope and feature settings of the updater.
+type Settings struct {
+	MonitoredNamespaces []string
+	NamespaceSelector   *metav1.LabelSelector
+	ExcludedNamespaces  []string
+	WorkloadSelector    *metav1.LabelSelector
+	LogsEnabled         bool
+	APMEnabled          bool
+}
+
+// Apply returns base with the fields set in the spec replaced, except excluded namespaces which
+// are added to base's so the resource cannot re-include a namespace excluded by env. A nil spec
+// returns base.
+func (s *Spec) Apply(base Settings) Settings {
+	if s == nil {
+		return base
+	}
+	if len(s.MonitoredNamespaces) > 0 {
+		base.MonitoredNamespaces = s.MonitoredNamespaces
+	}
+	if s.NamespaceSelector != nil {
+		base.NamespaceSelector = s.NamespaceSelector
+	}
+	if len(s.ExcludedNamespaces) > 0 {
+		base.ExcludedNamespaces = union(base.ExcludedNamespaces, s.ExcludedNamespaces)
+	}
+	if s.WorkloadSelector != nil {
+		base.WorkloadSelector = s.WorkloadSelector
+	}
+	if s.Logs != nil {
+		base.LogsEnabled = *s.Logs
+	}
+	if s.APM != nil {
+		base.APMEnabled = *s.APM
+	}
+	return base
+}
+
+// union returns the items of a followed by those of b missing from a.
+func union(a, b []string) []string {
+	out := append([]string(nil), a...)
+	seen := make(map[string]bool, len(a))
+	for _, item := range a {
+		seen[item] = true
+	}
+	for _, item := range b {
+		if !seen[item] {
+			seen[item] = true
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// APMDisabled reports whether the spec turns APM off.
+func (s *Spec) APMDisabled() bool {
+	return s != nil && s.APM != nil && !*s.APM
+}
+
+// AllowsInstrumentation reports whether the policies let the updater instrument a workload of
+// namespace written in language. Namespaces without a policy are allowed.
+func (s *Spec) AllowsInstrumentation(namespace, language string) bool {
+	if s == nil {
+		return true
+	}
+	if s.APMDisabled() {
+		return false
+	}
+	p := s.policy(namespace)
+	if p == nil {
+		return true
+	}
+	if p.Enabled != nil && !*p.Enabled {
+		return false
+	}
+	if len(p.Languages) == 0 {
+		return true
+	}
+	key := instrumentation.LanguageFor(language).Key
+	for _, l := range p.Languages {
+		if instrumentation.LanguageFor(l).Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Spec) policy(namespace string) *NamespacePolicy {
+	var wildcard *NamespacePolicy
+	for i := range s.Instrumentation {
+		switch s.Instrumentation[i].Namespace {
+		case namespace:
+			return &s.Instrumentation[i]
+		case "*":
+			wildcard = &s.Instrumentation[i]
+		}
+	}
+	return wildcard
+}
+
+// Merge returns base with override merged over it, neither argument is modified.
+func Merge(base, override map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		src, ok := v.(map[string]interface{})
+		if !ok {
+			out[k] = v
+			continue
+		}
+		dst, _ := out[k].(map[string]interface{})
+		out[k] = Merge(dst, src)
+	}
+	return out
+}
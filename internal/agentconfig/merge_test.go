@@ -0,0 +1,155 @@
+package agentconfig
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestSpecApply(t *testing.T) {
+	envSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"monitoring": "enabled"}}
+	crSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "checkout"}}
+	base := Settings{
+		MonitoredNamespaces: []string{"default"},
+		NamespaceSelector:   envSelector,
+		ExcludedNamespaces:  []string{"kube-system"},
+		LogsEnabled:         true,
+		APMEnabled:          true,
+	}
+	tests := []struct {
+		name string
+		spec *Spec
+		want Settings
+	}{
+		{name: "no resource", want: base},
+		{name: "empty spec", spec: &Spec{}, want: base},
+		{
+			name: "namespaces and selectors replace the env",
+			spec: &Spec{MonitoredNamespaces: []string{"payments"}, NamespaceSelector: crSelector, WorkloadSelector: crSelector},
+			want: Settings{
+				MonitoredNamespaces: []string{"payments"},
+				NamespaceSelector:   crSelector,
+				ExcludedNamespaces:  []string{"kube-system"},
+				WorkloadSelector:    crSelector,
+				LogsEnabled:         true,
+				APMEnabled:          true,
+			},
+		},
+		{
+			name: "excluded namespaces add to the env",
+			spec: &Spec{ExcludedNamespaces: []string{"tenant-b", "kube-system"}},
+			want: Settings{
+				MonitoredNamespaces: []string{"default"},
+				NamespaceSelector:   envSelector,
+				ExcludedNamespaces:  []string{"kube-system", "tenant-b"},
+				LogsEnabled:         true,
+				APMEnabled:          true,
+			},
+		},
+		{
+			name: "features turned off",
+			spec: &Spec{Logs: boolPtr(false), APM: boolPtr(false)},
+			want: Settings{
+				MonitoredNamespaces: []string{"default"},
+				NamespaceSelector:   envSelector,
+				ExcludedNamespaces:  []string{"kube-system"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.Apply(base); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if len(base.ExcludedNamespaces) != 1 {
+		t.Errorf("Apply() modified its base: %v", base.ExcludedNamespaces)
+	}
+}
+
+func TestSpecAllowsInstrumentation(t *testing.T) {
+	spec := &Spec{Instrumentation: []NamespacePolicy{
+		{Namespace: "payments", Enabled: boolPtr(false)},
+		{Namespace: "default", Languages: []string{"python", "node"}},
+		{Namespace: "*", Languages: []string{"java"}},
+	}}
+	tests := []struct {
+		name      string
+		spec      *Spec
+		namespace string
+		language  string
+		want      bool
+	}{
+		{name: "no resource", namespace: "payments", language: "Java", want: true},
+		{name: "no policies", spec: &Spec{}, namespace: "payments", language: "Java", want: true},
+		{name: "apm disabled", spec: &Spec{APM: boolPtr(false)}, namespace: "default", language: "Java"},
+		{name: "namespace disabled", spec: spec, namespace: "payments", language: "Java"},
+		{name: "listed language", spec: spec, namespace: "default", language: "Python", want: true},
+		{name: "language alias", spec: spec, namespace: "default", language: "nodejs", want: true},
+		{name: "unlisted language", spec: spec, namespace: "default", language: "Java"},
+		{name: "wildcard policy", spec: spec, namespace: "tenant-a", language: "Java", want: true},
+		{name: "wildcard denies", spec: spec, namespace: "tenant-a", language: "Go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.AllowsInstrumentation(tt.namespace, tt.language); got != tt.want {
+				t.Errorf("AllowsInstrumentation(%s, %s) = %v, want %v", tt.namespace, tt.language, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := map[string]interface{}{
+		"processors": map[string]interface{}{"batch": map[string]interface{}{"timeout": "5s"}},
+		"exporters":  map[string]interface{}{"otlphttp": map[string]interface{}{}},
+		"extensions": []interface{}{"health_check"},
+	}
+	tests := []struct {
+		name     string
+		override map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{name: "no override", want: base},
+		{
+			name:     "maps merge key by key",
+			override: map[string]interface{}{"processors": map[string]interface{}{"batch": map[string]interface{}{"send_batch_size": 2048}}},
+			want: map[string]interface{}{
+				"processors": map[string]interface{}{"batch": map[string]interface{}{"timeout": "5s", "send_batch_size": 2048}},
+				"exporters":  map[string]interface{}{"otlphttp": map[string]interface{}{}},
+				"extensions": []interface{}{"health_check"},
+			},
+		},
+		{
+			name:     "other values replace",
+			override: map[string]interface{}{"extensions": []interface{}{"pprof"}},
+			want: map[string]interface{}{
+				"processors": map[string]interface{}{"batch": map[string]interface{}{"timeout": "5s"}},
+				"exporters":  map[string]interface{}{"otlphttp": map[string]interface{}{}},
+				"extensions": []interface{}{"pprof"},
+			},
+		},
+		{
+			name:     "null removes",
+			override: map[string]interface{}{"exporters": nil},
+			want: map[string]interface{}{
+				"processors": map[string]interface{}{"batch": map[string]interface{}{"timeout": "5s"}},
+				"extensions": []interface{}{"health_check"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Merge(base, tt.override); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, found := base["processors"].(map[string]interface{})["batch"].(map[string]interface{})["send_batch_size"]; found {
+		t.Error("Merge() modified its base")
+	}
+}
@@ -0,0 +1,80 @@
+// Package agentconfig reads the KloudMateAgentConfig custom resource, which lets GitOps users
+// manage the Kubernetes agent declaratively on top of the env and control plane settings.
+package agentconfig
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "kloudmate.io"
+	Version  = "v1alpha1"
+	Kind     = "KloudMateAgentConfig"
+	Resource = "kloudmateagentconfigs"
+
+	PhaseApplied = "Applied"
+	PhaseFailed  = "Failed"
+)
+
+// GroupVersionResource identifies KloudMateAgentConfig for the dynamic client.
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// AgentConfig is a KloudMateAgentConfig resource.
+type AgentConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   Spec   `json:"spec,omitempty"`
+	Status Status `json:"status,omitempty"`
+}
+
+// Spec holds the settings managed through the resource. Unset fields keep the updater's own
+// settings from env vars and flags.
+type Spec struct {
+	// MonitoredNamespaces replaces KM_K8S_MONITORED_NAMESPACES
+	MonitoredNamespaces []string `json:"monitoredNamespaces,omitempty"`
+	// NamespaceSelector adds the namespaces with matching labels, e.g. monitoring=enabled
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ExcludedNamespaces are never reported or instrumented, in addition to KM_K8S_EXCLUDED_NAMESPACES
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// WorkloadSelector limits reporting and instrumentation to workloads with matching labels
+	WorkloadSelector *metav1.LabelSelector `json:"workloadSelector,omitempty"`
+	// Logs and APM override KM_LOGS_ENABLED and KM_APM_ENABLED, APM false also stops the
+	// updater from instrumenting workloads whatever the server says
+	Logs *bool `json:"logs,omitempty"`
+	APM  *bool `json:"apm,omitempty"`
+	// Instrumentation holds per-namespace policies for APM changes requested by the server
+	Instrumentation []NamespacePolicy `json:"instrumentation,omitempty"`
+	// CollectorOverrides are merged over the collector configs received from the server
+	CollectorOverrides CollectorOverrides `json:"collectorOverrides,omitempty"`
+}
+
+// NamespacePolicy restricts auto instrumentation in one namespace, * matches every namespace
+// without a policy of its own.
+type NamespacePolicy struct {
+	Namespace string `json:"namespace"`
+	// Enabled false keeps workloads of the namespace from being instrumented
+	Enabled *bool `json:"enabled,omitempty"`
+	// Languages limits instrumentation to these languages, e.g. java, empty allows all
+	Languages []string `json:"languages,omitempty"`
+}
+
+// CollectorOverrides are partial collector configs. Maps are merged key by key, other values
+// replace the server's and null removes a key.
+type CollectorOverrides struct {
+	DaemonSet  map[string]interface{} `json:"daemonset,omitempty"`
+	Deployment map[string]interface{} `json:"deployment,omitempty"`
+}
+
+// Status reports what the updater made of the resource.
+type Status struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Phase              string `json:"phase,omitempty"`
+	Message            string `json:"message,omitempty"`
+	// MonitoredNamespaces, LogsEnabled and APMEnabled are the settings in effect after merging
+	MonitoredNamespaces []string     `json:"monitoredNamespaces,omitempty"`
+	LogsEnabled         bool         `json:"logsEnabled"`
+	APMEnabled          bool         `json:"apmEnabled"`
+	LastSyncTime        *metav1.Time `json:"lastSyncTime,omitempty"`
+}
@@ -0,0 +1,166 @@
+package agentconfig
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Watcher keeps the latest version of one KloudMateAgentConfig.
+type Watcher struct {
+	client    dynamic.Interface
+	namespace string
+	name      string
+	logger    *zap.SugaredLogger
+
+	mu      sync.RWMutex
+	current *AgentConfig
+	changed chan struct{}
+}
+
+// NewWatcher returns a watcher for the resource name in namespace.
+func NewWatcher(client dynamic.Interface, namespace, name string, logger *zap.SugaredLogger) *Watcher {
+	return &Watcher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		logger:    logger,
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// Start watches the resource until ctx is done and returns once the current version is loaded.
+// A cluster without the CRD is not an error, the watcher then never reports a config.
+func (w *Watcher) Start(ctx context.Context) error {
+	_, err := w.client.Resource(GroupVersionResource).Namespace(w.namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if apierrors.IsNotFound(err) {
+		w.logger.Infof("%s CRD not installed, using env and control plane settings only", Kind)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list %s resources: %w", Kind, err)
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client, 10*time.Minute, w.namespace, func(o *metav1.ListOptions) {
+		o.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.name).String()
+	})
+	informer := factory.ForResource(GroupVersionResource).Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.set,
+		UpdateFunc: func(_, obj interface{}) { w.set(obj) },
+		DeleteFunc: func(interface{}) { w.store(nil) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", Kind, err)
+	}
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync %s %s/%s", Kind, w.namespace, w.name)
+	}
+	w.logger.Infof("watching %s %s/%s", Kind, w.namespace, w.name)
+	return nil
+}
+
+func (w *Watcher) set(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u.GetName() != w.name {
+		return
+	}
+	cfg, err := FromUnstructured(u)
+	if err != nil {
+		w.logger.Errorf("ignoring invalid %s %s/%s: %v", Kind, w.namespace, w.name, err)
+		return
+	}
+	w.store(cfg)
+}
+
+func (w *Watcher) store(cfg *AgentConfig) {
+	w.mu.Lock()
+	unchanged := cfg != nil && w.current != nil && cfg.Generation == w.current.Generation
+	w.current = cfg
+	w.mu.Unlock()
+	// status updates do not change the generation and must not trigger another check
+	if unchanged {
+		return
+	}
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Current returns the latest version of the resource, nil when it does not exist. It is shared
+// and must not be modified.
+func (w *Watcher) Current() *AgentConfig {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Changed receives after the spec was created, changed or deleted.
+func (w *Watcher) Changed() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.changed
+}
+
+// UpdateStatus writes status to the resource when it exists and the status differs.
+func (w *Watcher) UpdateStatus(ctx context.Context, status Status) error {
+	cfg := w.Current()
+	if cfg == nil || sameStatus(cfg.Status, status) {
+		return nil
+	}
+	now := metav1.Now()
+	status.LastSyncTime = &now
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	resource := w.client.Resource(GroupVersionResource).Namespace(w.namespace)
+	u, err := resource.Get(ctx, w.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", Kind, w.namespace, w.name, err)
+	}
+	u.Object["status"] = obj
+	if _, err := resource.UpdateStatus(ctx, u, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update %s %s/%s status: %w", Kind, w.namespace, w.name, err)
+	}
+	return nil
+}
+
+func sameStatus(a, b Status) bool {
+	if a.ObservedGeneration != b.ObservedGeneration || a.Phase != b.Phase || a.Message != b.Message ||
+		a.LogsEnabled != b.LogsEnabled || a.APMEnabled != b.APMEnabled || len(a.MonitoredNamespaces) != len(b.MonitoredNamespaces) {
+		return false
+	}
+	for i := range a.MonitoredNamespaces {
+		if a.MonitoredNamespaces[i] != b.MonitoredNamespaces[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FromUnstructured converts a resource read with the dynamic client.
+func FromUnstructured(u *unstructured.Unstructured) (*AgentConfig, error) {
+	var cfg AgentConfig
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,183 @@
+package buffer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.uber.org/zap"
+)
+
+// ExtensionID is the file_storage extension injected by the agent.
+const ExtensionID = "file_storage/kmbuffer"
+
+// Settings describes the persistent buffer managed by the agent.
+type Settings struct {
+	// Dir is where the exporter queues are persisted
+	Dir string
+	// MaxSizeBytes caps the queued data across all exporters
+	MaxSizeBytes int64
+}
+
+// NewConverterFactory returns a converter that wires a file_storage extension into the sending
+// queue of every queue capable exporter, so telemetry survives network outages and restarts.
+// The collector config on disk is left untouched.
+func NewConverterFactory(s Settings) confmap.ConverterFactory {
+	return confmap.NewConverterFactory(func(set confmap.ConverterSettings) confmap.Converter {
+		return &converter{settings: s, logger: set.Logger}
+	})
+}
+
+type converter struct {
+	settings Settings
+	logger   *zap.Logger
+}
+
+func (c *converter) Convert(_ context.Context, conf *confmap.Conf) error {
+	overlay, replaced := Overlay(conf.ToStringMap(), c.settings)
+	for _, r := range replaced {
+		c.logger.Warn("sending queue size replaced to stay within the persistent buffer cap", zap.String("detail", r))
+	}
+	if overlay == nil {
+		return nil
+	}
+	return conf.Merge(confmap.NewFromStringMap(overlay))
+}
+
+// Overlay computes the config that has to be merged into cfg to enable persistent buffering.
+// It returns nil when no exporter can be buffered. With a cap every queue is sized in bytes
+// within its share of MaxSizeBytes, the returned descriptions list the queue sizes of cfg
+// that were replaced for that.
+func Overlay(cfg map[string]any, s Settings) (map[string]any, []string) {
+	exporters, _ := cfg["exporters"].(map[string]any)
+	var names []string
+	for name, raw := range exporters {
+		if bufferable(name, raw) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	// split the cap evenly, each exporter owns its own queue in the storage
+	perExporter := s.MaxSizeBytes / int64(len(names))
+	exporterOverlay := make(map[string]any, len(names))
+	var replaced []string
+	for _, name := range names {
+		queue := map[string]any{
+			"enabled": true,
+			"storage": ExtensionID,
+		}
+		if perExporter > 0 {
+			// a size in other units than bytes cannot be held to the cap, so it is replaced as well
+			queue["sizer"] = "bytes"
+			queue["queue_size"] = perExporter
+			if sizer, size, set := queueSize(exporters[name]); set {
+				if sizer == "bytes" && size > 0 && size <= perExporter {
+					queue["queue_size"] = size
+				} else {
+					replaced = append(replaced, fmt.Sprintf("exporter %s: queue_size %d with sizer %s replaced by %d bytes, its share of the %d bytes cap",
+						name, size, sizer, perExporter, s.MaxSizeBytes))
+				}
+			}
+		}
+		exporterOverlay[name] = map[string]any{"sending_queue": queue}
+	}
+
+	service, _ := cfg["service"].(map[string]any)
+	var extensions []any
+	if existing, ok := service["extensions"].([]any); ok {
+		extensions = append(extensions, existing...)
+	}
+	if !slices.Contains(extensions, any(ExtensionID)) {
+		extensions = append(extensions, ExtensionID)
+	}
+
+	return map[string]any{
+		"extensions": map[string]any{
+			ExtensionID: map[string]any{
+				"directory":        s.Dir,
+				"create_directory": true,
+				"compaction": map[string]any{
+					"on_start":   true,
+					"on_rebound": true,
+					"directory":  s.Dir,
+				},
+			},
+		},
+		"exporters": exporterOverlay,
+		"service": map[string]any{
+			"extensions": extensions,
+		},
+	}, replaced
+}
+
+// bufferable reports whether the exporter supports a sending queue that the agent may persist.
+// Exporters with an explicitly disabled queue or their own storage are left alone.
+func bufferable(name string, raw any) bool {
+	exporterType, _, _ := strings.Cut(name, "/")
+	exporterCfg, _ := raw.(map[string]any)
+	queue, hasQueue := exporterCfg["sending_queue"].(map[string]any)
+	if hasQueue {
+		if enabled, ok := queue["enabled"].(bool); ok && !enabled {
+			return false
+		}
+		if _, ok := queue["storage"]; ok {
+			return false
+		}
+		return true
+	}
+	return exporterType == "otlp" || exporterType == "otlphttp"
+}
+
+// queueSize returns the size and sizer of the exporter's sending queue and whether either is set.
+// The sizer defaults to requests like in the collector.
+func queueSize(raw any) (string, int64, bool) {
+	exporterCfg, _ := raw.(map[string]any)
+	queue, _ := exporterCfg["sending_queue"].(map[string]any)
+	rawSizer, hasSizer := queue["sizer"]
+	rawSize, hasSize := queue["queue_size"]
+	sizer, _ := rawSizer.(string)
+	if sizer == "" {
+		sizer = "requests"
+	}
+	var size int64
+	switch v := rawSize.(type) {
+	case int:
+		size = int64(v)
+	case int64:
+		size = v
+	case uint64:
+		size = int64(v)
+	case float64:
+		size = int64(v)
+	}
+	return sizer, size, hasSizer || hasSize
+}
+
+// Usage returns the number of bytes currently used by the buffer directory.
+func Usage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,95 @@
+package buffer
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOverlayQueueSize(t *testing.T) {
+	capped := map[string]any{"enabled": true, "storage": ExtensionID, "sizer": "bytes", "queue_size": int64(1024)}
+	tests := []struct {
+		name     string
+		queue    map[string]any
+		want     map[string]any
+		replaced bool
+	}{
+		{name: "no queue", want: capped},
+		{name: "queue without size", queue: map[string]any{"num_consumers": 4}, want: capped},
+		{name: "queue_size in requests", queue: map[string]any{"queue_size": 5000}, want: capped, replaced: true},
+		{name: "items sizer", queue: map[string]any{"sizer": "items"}, want: capped, replaced: true},
+		{
+			name:  "bytes within the share",
+			queue: map[string]any{"sizer": "bytes", "queue_size": 512},
+			want:  map[string]any{"enabled": true, "storage": ExtensionID, "sizer": "bytes", "queue_size": int64(512)},
+		},
+		{name: "bytes over the share", queue: map[string]any{"sizer": "bytes", "queue_size": 4096}, want: capped, replaced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := map[string]any{}
+			if tt.queue != nil {
+				exporter["sending_queue"] = tt.queue
+			}
+			cfg := map[string]any{"exporters": map[string]any{"otlphttp": exporter}}
+			overlay, replaced := Overlay(cfg, Settings{Dir: "/tmp/buffer", MaxSizeBytes: 1024})
+			got := overlay["exporters"].(map[string]any)["otlphttp"].(map[string]any)["sending_queue"]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sending_queue overlay = %v, want %v", got, tt.want)
+			}
+			if (len(replaced) > 0) != tt.replaced {
+				t.Errorf("replaced = %v, want replaced %v", replaced, tt.replaced)
+			}
+		})
+	}
+
+	// without a cap the queue keeps its size
+	overlay, _ := Overlay(map[string]any{"exporters": map[string]any{"otlphttp": map[string]any{
+		"sending_queue": map[string]any{"queue_size": 5000},
+	}}}, Settings{Dir: "/tmp/buffer"})
+	if got := overlay["exporters"].(map[string]any)["otlphttp"].(map[string]any)["sending_queue"]; !reflect.DeepEqual(got, map[string]any{"enabled": true, "storage": ExtensionID}) {
+		t.Errorf("sending_queue overlay without a cap = %v", got)
+	}
+}
+
+func TestConverterCapsShippedConfigs(t *testing.T) {
+	const maxSize = 512 * 1024 * 1024
+	for _, path := range []string{"../../configs/host-col-config.yaml", "../../configs/docker-col-config.yaml"} {
+		t.Run(path, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var raw map[string]any
+			if err := yaml.Unmarshal(data, &raw); err != nil {
+				t.Fatal(err)
+			}
+			conf := confmap.NewFromStringMap(raw)
+			core, logs := observer.New(zap.WarnLevel)
+			converter := NewConverterFactory(Settings{Dir: "/tmp/buffer", MaxSizeBytes: maxSize}).Create(confmap.ConverterSettings{Logger: zap.New(core)})
+			if err := converter.Convert(context.Background(), conf); err != nil {
+				t.Fatal(err)
+			}
+
+			queue, ok := conf.Get("exporters::otlphttp::sending_queue").(map[string]any)
+			if !ok {
+				t.Fatalf("otlphttp has no sending queue: %v", conf.Get("exporters::otlphttp"))
+			}
+			if queue["sizer"] != "bytes" || queue["queue_size"] != int64(maxSize) || queue["storage"] != ExtensionID {
+				t.Errorf("sending_queue = %v, want %d bytes in %s", queue, maxSize, ExtensionID)
+			}
+			if queue["num_consumers"] != 10 {
+				t.Errorf("other queue settings not kept: %v", queue)
+			}
+			if logs.Len() != 1 {
+				t.Errorf("replaced queue_size reported %d times, want once", logs.Len())
+			}
+		})
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/kloudmate/km-agent/internal/secrets"
 	"gopkg.in/yaml.v3"
 )
 
@@ -23,6 +25,55 @@ type Config struct {
 	ConfigCheckInterval int
 	DockerMode          bool
 	DockerEndpoint      string
+	// APIKeyFile is read instead of APIKey when set and watched for rotation
+	APIKeyFile string
+	// SecretsDir holds one file per secret, resolvable in collector configs as ${kmsecret:<file name>}
+	SecretsDir string
+	// Network holds proxy and TLS settings for control plane calls
+	Network NetworkConfig
+	// Buffering persists exporter queues to disk
+	Buffering BufferingConfig
+	// ConfigPublicKeyFile pins the PEM public key that must have signed config check responses
+	ConfigPublicKeyFile string
+	// UpgradePublicKeyFile pins the PEM Ed25519 key that must have signed agent binaries, self-upgrade
+	// is disabled without it
+	UpgradePublicKeyFile string
+	// PolicyFile holds local guardrails remote configs must satisfy before they are applied
+	PolicyFile string
+	// ConfigHistoryDir keeps snapshots of applied collector configs, ConfigHistorySize bounds their number
+	ConfigHistoryDir  string
+	ConfigHistorySize int
+	// PinConfig freezes the collector config, remote updates are reported but not applied
+	PinConfig bool
+	// PinConfigUntil is an optional RFC 3339 expiry for PinConfig
+	PinConfigUntil string
+	// ConfigPinFile pins the config while it exists, e.g. created with `kmagent config pin`
+	ConfigPinFile string
+	// InventoryInterval is how often in seconds the host inventory sent with config checks is refreshed
+	InventoryInterval int
+	// ControlAddr is the loopback address of the local control API, empty disables it
+	ControlAddr string
+	// Logging selects where agent and collector logs are written
+	Logging LoggingConfig
+	// ShipAgentLogs also exports the agent's own logs to the collector endpoint as OTLP logs
+	ShipAgentLogs bool
+}
+
+// LoggingConfig selects the log sink, one of stderr, file, journald, syslog or eventlog,
+// and the rotation of the file sink.
+type LoggingConfig struct {
+	Sink       string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// BufferingConfig controls the agent managed persistent buffer for exporter queues.
+type BufferingConfig struct {
+	Enabled   bool
+	Dir       string
+	MaxSizeMB int
 }
 
 func GetAgentConfigUpdaterURL(collectorEndpoint string) string {
@@ -64,6 +115,49 @@ func GetDefaultConfigPath() string {
 	}
 }
 
+// GetDefaultStateDir returns the directory for agent state such as buffers and upgrade markers based on OS
+func GetDefaultStateDir() string {
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		return filepath.Join(programData, "KloudMate", "kmagent")
+	} else if runtime.GOOS == "darwin" {
+		return "/Library/Application Support/kmagent"
+	} else {
+		// Linux/Unix and Docker
+		return "/var/lib/kmagent"
+	}
+}
+
+// GetDefaultBufferDir returns the default directory for persisted exporter queues based on OS
+func GetDefaultBufferDir() string {
+	return filepath.Join(GetDefaultStateDir(), "buffer")
+}
+
+// GetDefaultHistoryDir returns the default directory for collector config snapshots based on OS
+func GetDefaultHistoryDir() string {
+	return filepath.Join(GetDefaultStateDir(), "history")
+}
+
+// GetDefaultPinFile returns the default path of the config pin flag file based on OS
+func GetDefaultPinFile() string {
+	return filepath.Join(GetDefaultStateDir(), "config.pinned")
+}
+
+// GetDefaultLogFile returns the default path of the agent log file based on OS
+func GetDefaultLogFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetDefaultStateDir(), "logs", "kmagent.log")
+	} else if runtime.GOOS == "darwin" {
+		return "/Library/Logs/kmagent/kmagent.log"
+	} else {
+		// Linux/Unix
+		return "/var/log/kmagent/kmagent.log"
+	}
+}
+
 // GetDockerConfigPath returns the configuration path when running in Docker
 func GetDockerConfigPath() string {
 	return "/etc/kmagent/config.yaml"
@@ -74,7 +168,13 @@ func GetDockerConfigPath() string {
 func (c *Config) LoadConfig() error {
 
 	os.Setenv("KM_COLLECTOR_ENDPOINT", c.ExporterEndpoint)
-	os.Setenv("KM_API_KEY", c.APIKey)
+	c.Network.ExportEnv()
+
+	// the API key is kept in the secret store instead of the environment, the collector
+	// resolves ${env:KM_API_KEY} and ${kmsecret:api_key} from there.
+	if err := LoadSecrets(c.APIKey, c.APIKeyFile, c.SecretsDir); err != nil {
+		return err
+	}
 
 	if c.ConfigUpdateURL == "" {
 		c.ConfigUpdateURL = GetAgentConfigUpdaterURL(c.ExporterEndpoint)
@@ -113,9 +213,63 @@ func (c *Config) LoadConfig() error {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
+	if c.ConfigHistoryDir == "" {
+		c.ConfigHistoryDir = GetDefaultHistoryDir()
+	}
+	if c.ConfigPinFile == "" {
+		c.ConfigPinFile = GetDefaultPinFile()
+	}
+	if c.Logging.File == "" {
+		c.Logging.File = GetDefaultLogFile()
+	}
+	if c.PinConfigUntil != "" {
+		if _, err := time.Parse(time.RFC3339, c.PinConfigUntil); err != nil {
+			return fmt.Errorf("invalid pin-config-until, expected RFC 3339: %w", err)
+		}
+	}
+
+	if c.Buffering.Enabled {
+		if c.Buffering.Dir == "" {
+			c.Buffering.Dir = GetDefaultBufferDir()
+		}
+		if err := os.MkdirAll(c.Buffering.Dir, 0750); err != nil {
+			return fmt.Errorf("failed to create buffer directory: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// CurrentAPIKey returns the latest API key, reflecting rotations of the API key file.
+func (c *Config) CurrentAPIKey() string {
+	return currentAPIKey(c.APIKey)
+}
+
+// LoadSecrets populates the default secret store from the secrets directory and the API key
+// file, falling back to the API key passed as flag or environment variable.
+func LoadSecrets(apiKey, apiKeyFile, secretsDir string) error {
+	store := secrets.Default()
+	if secretsDir != "" {
+		if err := store.LoadDir(secretsDir); err != nil {
+			return err
+		}
+	}
+	if apiKeyFile != "" {
+		return store.LoadFile(secrets.APIKey, apiKeyFile)
+	}
+	if _, found := store.Get(secrets.APIKey); !found && apiKey != "" {
+		store.Set(secrets.APIKey, apiKey)
+	}
+	return nil
+}
+
+func currentAPIKey(fallback string) string {
+	if v, found := secrets.Default().Get(secrets.APIKey); found {
+		return v
+	}
+	return fallback
+}
+
 func (c *Config) Hostname() string {
 	n, e := os.Hostname()
 	if e != nil {
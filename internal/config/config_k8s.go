@@ -1,8 +1,15 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
 	"go.uber.org/zap"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -17,14 +24,18 @@ const (
 
 type K8sAgentConfig struct {
 	Logger    *zap.SugaredLogger
-	K8sClient *kubernetes.Clientset
-	StopCh    chan struct{}
-	Version   string
+	K8sClient kubernetes.Interface
+	// DynamicClient reads and writes custom resources such as Instrumentation, may be nil
+	DynamicClient dynamic.Interface
+	StopCh        chan struct{}
+	Version       string
 
 	OtelCollectorConfig map[string]interface{}
 	ExporterEndpoint    string
 	ConfigUpdateURL     string
 	APIKey              string
+	APIKeyFile          string
+	SecretsDir          string
 	ConfigCheckInterval string
 	// Kubernetes specific
 	KubeNamespace           string
@@ -34,26 +45,107 @@ type K8sAgentConfig struct {
 	ConfigmapDeploymentName string
 	DaemonSetName           string
 	DeploymentName          string
+	// AgentConfigName is the KloudMateAgentConfig resource in KubeNamespace the updater watches,
+	// empty disables it
+	AgentConfigName string
+	// Kubeconfig is a kubeconfig file to use instead of the in-cluster service account
+	Kubeconfig string
+	// HealthAddr is the listen address for the health and metrics endpoints
+	HealthAddr string
+	// ControlAddr is the loopback address of the local control API, empty disables it
+	ControlAddr string
+	// Network holds proxy and TLS settings for control plane calls
+	Network NetworkConfig
+	// ConfigPublicKeyFile pins the PEM public key that must have signed config check responses
+	ConfigPublicKeyFile string
+	// PolicyFile holds local guardrails remote configs must satisfy before they are applied
+	PolicyFile string
+	// ShipAgentLogs also exports the updater's own logs to the collector endpoint as OTLP logs
+	ShipAgentLogs bool
 }
 
-func NewKubeConfig(cfg K8sAgentConfig, clientset *kubernetes.Clientset, logger *zap.Logger, version string) (*K8sAgentConfig, error) {
+func NewKubeConfig(cfg K8sAgentConfig, clientset kubernetes.Interface, logger *zap.Logger, version string) (*K8sAgentConfig, error) {
 
 	agent := &K8sAgentConfig{
 		Logger:                  logger.Sugar(),
 		K8sClient:               clientset,
+		StopCh:                  make(chan struct{}),
 		ExporterEndpoint:        cfg.ExporterEndpoint,
 		ConfigUpdateURL:         GetAgentConfigUpdaterURL(cfg.ExporterEndpoint),
 		APIKey:                  cfg.APIKey,
+		APIKeyFile:              cfg.APIKeyFile,
+		SecretsDir:              cfg.SecretsDir,
 		ConfigCheckInterval:     cfg.ConfigCheckInterval,
 		KubeNamespace:           cfg.KubeNamespace,
 		Version:                 version,
 		ClusterName:             cfg.ClusterName,
 		DaemonSetName:           cfg.DaemonSetName,
 		DeploymentName:          cfg.DeploymentName,
+		Kubeconfig:              cfg.Kubeconfig,
+		AgentConfigName:         cfg.AgentConfigName,
 		ConfigmapDaemonsetName:  cfg.ConfigmapDaemonsetName,
 		ConfigmapDeploymentName: cfg.ConfigmapDeploymentName,
+		Network:                 cfg.Network,
+		ConfigPublicKeyFile:     cfg.ConfigPublicKeyFile,
+		PolicyFile:              cfg.PolicyFile,
+	}
+
+	if err := LoadSecrets(cfg.APIKey, cfg.APIKeyFile, cfg.SecretsDir); err != nil {
+		return nil, err
 	}
 
 	agent.Logger.Infoln("kube updater initialized successfully")
 	return agent, nil
 }
+
+// CurrentAPIKey returns the latest API key, reflecting rotations of the API key file.
+func (c *K8sAgentConfig) CurrentAPIKey() string {
+	return currentAPIKey(c.APIKey)
+}
+
+// KubeRESTConfig resolves the client config from kubeconfig, then the KUBECONFIG files, then the
+// in-cluster service account and finally ~/.kube/config, so agents also run outside a cluster.
+func KubeRESTConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		cfg, err := rest.InClusterConfig()
+		if err == nil {
+			return cfg, nil
+		}
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+		}
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return cfg, nil
+}
+
+// NewKubeClient returns a clientset for the config resolved by KubeRESTConfig.
+func NewKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	cfg, err := KubeRESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// NewDynamicClient returns a dynamic client for custom resources, resolved like NewKubeClient.
+func NewDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+	cfg, err := KubeRESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic kubernetes client: %w", err)
+	}
+	return client, nil
+}
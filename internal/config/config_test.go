@@ -0,0 +1,45 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/secrets"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCurrentAPIKeyFollowsRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_key")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSecrets("flag-key", path, ""); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{APIKey: "flag-key", APIKeyFile: path}
+	if got := c.CurrentAPIKey(); got != "first" {
+		t.Fatalf("CurrentAPIKey() = %q, want the key file over the flag", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := secrets.Default().WatchFile(ctx, secrets.APIKey, path, zaptest.NewLogger(t).Sugar()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".new", []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.CurrentAPIKey() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("CurrentAPIKey() = %q after rotation, want second", c.CurrentAPIKey())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/collector/confmap"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	EnvProxyURL       = "KM_PROXY_URL"
+	EnvNoProxy        = "KM_NO_PROXY"
+	EnvCAFile         = "KM_CA_FILE"
+	EnvClientCertFile = "KM_CLIENT_CERT_FILE"
+	EnvClientKeyFile  = "KM_CLIENT_KEY_FILE"
+	EnvMinTLSVersion  = "KM_TLS_MIN_VERSION"
+)
+
+// NetworkConfig holds the proxy and TLS settings used for calls to the KloudMate control plane.
+type NetworkConfig struct {
+	// ProxyURL is the HTTP(S) proxy to use, credentials may be passed as user info
+	ProxyURL string
+	// NoProxy is a comma separated list of hosts that bypass the proxy
+	NoProxy string
+	// CAFile is a PEM bundle of additional root CAs, e.g. for TLS intercepting proxies
+	CAFile string
+	// ClientCertFile and ClientKeyFile enable mTLS when both are set
+	ClientCertFile string
+	ClientKeyFile  string
+	// MinTLSVersion is one of 1.0, 1.1, 1.2 or 1.3
+	MinTLSVersion string
+}
+
+// TLSConfig builds a tls.Config from the CA bundle, client certificate and minimum version.
+func (n NetworkConfig) TLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if n.MinTLSVersion != "" {
+		v, err := parseTLSVersion(n.MinTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.MinVersion = v
+	}
+
+	if n.CAFile != "" {
+		pem, err := os.ReadFile(n.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", n.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if n.ClientCertFile != "" || n.ClientKeyFile != "" {
+		if n.ClientCertFile == "" || n.ClientKeyFile == "" {
+			return nil, fmt.Errorf("both client certificate and key must be set for mTLS")
+		}
+		cert, err := tls.LoadX509KeyPair(n.ClientCertFile, n.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// ProxyFunc returns the proxy selector for the configured proxy, falling back to the proxy
+// variables of the environment. NoProxy applies in both cases.
+func (n NetworkConfig) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	proxyCfg := httpproxy.FromEnvironment()
+	if n.ProxyURL != "" {
+		if _, err := url.Parse(n.ProxyURL); err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxyCfg = &httpproxy.Config{HTTPProxy: n.ProxyURL, HTTPSProxy: n.ProxyURL, NoProxy: n.NoProxy}
+	} else if n.NoProxy != "" {
+		proxyCfg.NoProxy = strings.Trim(proxyCfg.NoProxy+","+n.NoProxy, ",")
+	}
+	proxy := proxyCfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// NewTransport returns a clone of the default transport with the proxy and TLS settings applied.
+func (n NetworkConfig) NewTransport() (*http.Transport, error) {
+	tlsCfg, err := n.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := n.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.TLSClientConfig = tlsCfg
+	return t, nil
+}
+
+// NetworkFromEnv reads the settings from the KM_* variables ExportEnv sets, as the Helm chart
+// does for the kube agent pods.
+func NetworkFromEnv() NetworkConfig {
+	return NetworkConfig{
+		ProxyURL:       os.Getenv(EnvProxyURL),
+		NoProxy:        os.Getenv(EnvNoProxy),
+		CAFile:         os.Getenv(EnvCAFile),
+		ClientCertFile: os.Getenv(EnvClientCertFile),
+		ClientKeyFile:  os.Getenv(EnvClientKeyFile),
+		MinTLSVersion:  os.Getenv(EnvMinTLSVersion),
+	}
+}
+
+// ExportEnv exposes the TLS settings to the collector so the default configs can reference them
+// with ${env:...}. The proxy is not exported, its URL may carry credentials, exporters get it
+// from ProxyConverterFactory instead.
+func (n NetworkConfig) ExportEnv() {
+	os.Setenv(EnvCAFile, n.CAFile)
+	os.Setenv(EnvClientCertFile, n.ClientCertFile)
+	os.Setenv(EnvClientKeyFile, n.ClientKeyFile)
+	os.Setenv(EnvMinTLSVersion, n.MinTLSVersion)
+}
+
+// proxiedExporters are the exporter types whose HTTP client config accepts proxy_url.
+var proxiedExporters = map[string]bool{"otlphttp": true}
+
+// ProxyConverterFactory returns a converter setting proxy_url on the collector's HTTP exporters
+// whose endpoint does not bypass the proxy. Exporters with their own proxy_url are left alone.
+func (n NetworkConfig) ProxyConverterFactory() confmap.ConverterFactory {
+	return confmap.NewConverterFactory(func(confmap.ConverterSettings) confmap.Converter {
+		return proxyConverter{network: n}
+	})
+}
+
+type proxyConverter struct {
+	network NetworkConfig
+}
+
+func (c proxyConverter) Convert(_ context.Context, conf *confmap.Conf) error {
+	overlay, err := c.network.ProxyOverlay(conf.ToStringMap())
+	if err != nil || overlay == nil {
+		return err
+	}
+	return conf.Merge(confmap.NewFromStringMap(overlay))
+}
+
+// ProxyOverlay computes the proxy_url settings to merge into a collector config, nil when no
+// exporter needs one.
+func (n NetworkConfig) ProxyOverlay(cfg map[string]any) (map[string]any, error) {
+	if n.ProxyURL == "" {
+		return nil, nil
+	}
+	proxy, err := n.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	exporters, _ := cfg["exporters"].(map[string]any)
+	overlay := map[string]any{}
+	for name, raw := range exporters {
+		exporterType, _, _ := strings.Cut(name, "/")
+		exporterCfg, _ := raw.(map[string]any)
+		if _, set := exporterCfg["proxy_url"]; !proxiedExporters[exporterType] || set {
+			continue
+		}
+		// an endpoint that cannot be parsed is left to the exporter to reject
+		endpoint, _ := exporterCfg["endpoint"].(string)
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		proxyURL, err := proxy(&http.Request{URL: u})
+		if err != nil || proxyURL == nil {
+			continue
+		}
+		overlay[name] = map[string]any{"proxy_url": proxyURL.String()}
+	}
+	if len(overlay) == 0 {
+		return nil, nil
+	}
+	return map[string]any{"exporters": overlay}, nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q", v)
+	}
+}
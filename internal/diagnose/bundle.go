@@ -0,0 +1,139 @@
+// Package diagnose builds support bundles, gzipped tarballs with the versions, redacted configs,
+// logs, status and connectivity results support needs to troubleshoot an agent.
+package diagnose
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bundle writes files into a gzipped tarball under a single top level directory. Sections that
+// fail are listed in errors.txt instead of aborting the bundle.
+type Bundle struct {
+	f      *os.File
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	dir    string
+	now    time.Time
+	files  []string
+	errors []string
+}
+
+// DefaultPath returns a bundle name like kmagent-diagnose-<host>-<timestamp>.tar.gz.
+func DefaultPath(binary string) string {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-diagnose-%s-%s.tar.gz", binary, host, time.Now().UTC().Format("20060102T150405Z"))
+}
+
+// Create starts a bundle at path. It is only readable by the current user since, although
+// secrets are redacted, configs and logs can still reveal infrastructure details.
+func Create(path string) (*Bundle, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	return &Bundle{
+		f:   f,
+		gz:  gz,
+		tw:  tar.NewWriter(gz),
+		dir: strings.TrimSuffix(strings.TrimSuffix(baseName(path), ".gz"), ".tar"),
+		now: time.Now(),
+	}, nil
+}
+
+// Add writes data as name, a slash separated path inside the bundle.
+func (b *Bundle) Add(name string, data []byte) {
+	hdr := &tar.Header{
+		Name:    path.Join(b.dir, name),
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		b.Fail(name, err)
+		return
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		b.Fail(name, err)
+		return
+	}
+	b.files = append(b.files, name)
+}
+
+// AddJSON writes v as indented JSON.
+func (b *Bundle) AddJSON(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	b.Add(name, data)
+}
+
+// AddFileTail writes up to the last maxBytes of the file at src.
+func (b *Bundle) AddFileTail(name, src string, maxBytes int64) {
+	f, err := os.Open(src)
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > maxBytes {
+		if _, err := f.Seek(-maxBytes, io.SeekEnd); err != nil {
+			b.Fail(name, err)
+			return
+		}
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		b.Fail(name, err)
+		return
+	}
+	b.Add(name, data)
+}
+
+// Fail records that a section could not be collected.
+func (b *Bundle) Fail(section string, err error) {
+	b.errors = append(b.errors, fmt.Sprintf("%s: %v", section, err))
+}
+
+// Close writes the index of collected files and errors and finishes the tarball.
+func (b *Bundle) Close() error {
+	sort.Strings(b.files)
+	index := "collected at " + b.now.UTC().Format(time.RFC3339) + "\n\n" + strings.Join(b.files, "\n") + "\n"
+	b.Add("index.txt", []byte(index))
+	if len(b.errors) > 0 {
+		b.Add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	if err := b.tw.Close(); err != nil {
+		b.f.Close()
+		return err
+	}
+	if err := b.gz.Close(); err != nil {
+		b.f.Close()
+		return err
+	}
+	return b.f.Close()
+}
+
+// Errors returns the sections that could not be collected.
+func (b *Bundle) Errors() []string {
+	return b.errors
+}
+
+func baseName(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	return path.Base(p)
+}
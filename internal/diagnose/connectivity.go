@@ -0,0 +1,80 @@
+package diagnose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Check is the outcome of a connectivity test against one endpoint.
+type Check struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Resolved []string `json:"resolved,omitempty"`
+	Status   int      `json:"status,omitempty"`
+	Latency  string   `json:"latency,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// CheckEndpoint resolves the endpoint's host and sends a GET through client, which carries the
+// agent's proxy and TLS settings. Any HTTP response, including 4xx, means the endpoint is reachable.
+func CheckEndpoint(ctx context.Context, client *http.Client, name, rawURL string) Check {
+	c := Check{Name: name, URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		c.Error = fmt.Sprintf("invalid url %q", rawURL)
+		return c
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	// resolution fails legitimately behind a proxy, keep going and let the request decide
+	if addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err == nil {
+		c.Resolved = addrs
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	c.Latency = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	c.Status = resp.StatusCode
+	return c
+}
+
+// Fetch GETs a local endpoint such as the control API or a metrics endpoint.
+func Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// local endpoints must not go through a configured proxy
+	resp, err := (&http.Client{Transport: &http.Transport{Proxy: nil}}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return data, fmt.Errorf("%s returned status %d", rawURL, resp.StatusCode)
+	}
+	return data, nil
+}
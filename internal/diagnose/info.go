@@ -0,0 +1,84 @@
+package diagnose
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/kloudmate/km-agent/internal/version"
+	"go.opentelemetry.io/collector/otelcol"
+)
+
+// Build is the version and build information of the running binary.
+type Build struct {
+	Version          string            `json:"version"`
+	CollectorVersion string            `json:"collector_version"`
+	GoVersion        string            `json:"go_version"`
+	OS               string            `json:"os"`
+	Arch             string            `json:"arch"`
+	NumCPU           int               `json:"num_cpu"`
+	Hostname         string            `json:"hostname"`
+	UID              int               `json:"uid"`
+	Module           string            `json:"module,omitempty"`
+	Settings         map[string]string `json:"settings,omitempty"`
+	Deps             []string          `json:"deps,omitempty"`
+}
+
+// BuildInfo returns the version and build information of the running binary.
+func BuildInfo(agentVersion string) Build {
+	host, _ := os.Hostname()
+	b := Build{
+		Version:          agentVersion,
+		CollectorVersion: version.GetCollectorVersion(),
+		GoVersion:        runtime.Version(),
+		OS:               runtime.GOOS,
+		Arch:             runtime.GOARCH,
+		NumCPU:           runtime.NumCPU(),
+		Hostname:         host,
+		UID:              os.Getuid(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		b.Module = info.Main.Path + "@" + info.Main.Version
+		b.Settings = map[string]string{}
+		for _, s := range info.Settings {
+			b.Settings[s.Key] = s.Value
+		}
+		for _, d := range info.Deps {
+			dep := d.Path + "@" + d.Version
+			if d.Replace != nil {
+				dep += " => " + d.Replace.Path + "@" + d.Replace.Version
+			}
+			b.Deps = append(b.Deps, dep)
+		}
+	}
+	return b
+}
+
+// Components lists the component types compiled into the collector by kind.
+func Components(factories func() (otelcol.Factories, error)) (map[string][]string, error) {
+	f, err := factories()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]string{}
+	for t := range f.Receivers {
+		out["receivers"] = append(out["receivers"], t.String())
+	}
+	for t := range f.Processors {
+		out["processors"] = append(out["processors"], t.String())
+	}
+	for t := range f.Exporters {
+		out["exporters"] = append(out["exporters"], t.String())
+	}
+	for t := range f.Extensions {
+		out["extensions"] = append(out["extensions"], t.String())
+	}
+	for t := range f.Connectors {
+		out["connectors"] = append(out["connectors"], t.String())
+	}
+	for _, names := range out {
+		sort.Strings(names)
+	}
+	return out, nil
+}
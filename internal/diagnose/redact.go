@@ -0,0 +1,98 @@
+package diagnose
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secret values in bundles.
+const Redacted = "[REDACTED]"
+
+var (
+	sensitiveKey = regexp.MustCompile(`(?i)(pass(word|wd)?|secret|token|api[_-]?key|authorization|private[_-]?key|credential|bearer|cookie|signature)`)
+	// fileKey matches keys naming where a secret is stored rather than the secret, e.g. api_key_file
+	fileKey = regexp.MustCompile(`(?i)(file|path|dir)$`)
+)
+
+// Redact returns a copy of v, a decoded YAML or JSON document, with the values of secret looking
+// keys replaced and credentials stripped from URLs. References like ${env:KM_API_KEY} or
+// ${kmsecret:name} are kept since they do not reveal the secret.
+func Redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			if sensitiveKey.MatchString(k) && !fileKey.MatchString(k) {
+				out[k] = redactSecret(val)
+				continue
+			}
+			out[k] = Redact(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = Redact(val)
+		}
+		return out
+	case string:
+		return redactURL(v)
+	default:
+		return v
+	}
+}
+
+// RedactYAML redacts a YAML document, see Redact.
+func RedactYAML(data []byte) ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(Redact(doc))
+}
+
+func isReference(s string) bool {
+	return strings.HasPrefix(s, "${") && strings.HasSuffix(s, "}")
+}
+
+// redactSecret hides the value of a secret looking key. Every scalar of a list is hidden, nested
+// documents are redacted key by key.
+func redactSecret(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		return Redact(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = redactSecret(val)
+		}
+		return out
+	case string:
+		if v == "" || isReference(v) {
+			return v
+		}
+	}
+	return Redacted
+}
+
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	// a user without a password is often a token, e.g. https://<token>@host
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	} else {
+		u.User = url.User("xxxxx")
+	}
+	return u.String()
+}
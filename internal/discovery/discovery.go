@@ -0,0 +1,215 @@
+// Package discovery matches local listening services to the receivers compiled into the agent.
+package discovery
+
+import (
+	"cmp"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Candidate is an integration the host looks like it could enable, with a receiver config
+// fragment the server can offer for one-click enablement.
+type Candidate struct {
+	Integration string                 `json:"integration"`
+	Receiver    string                 `json:"receiver"`
+	Process     string                 `json:"process,omitempty"`
+	Endpoint    string                 `json:"endpoint"`
+	Port        uint32                 `json:"port,omitempty"`
+	Config      map[string]interface{} `json:"config"`
+}
+
+// Service is a local process listening on a TCP port.
+type Service struct {
+	Process string
+	// Exe is the base name of the process executable
+	Exe     string
+	Address string
+	Port    uint32
+}
+
+// rule describes how an integration is recognised and configured.
+type rule struct {
+	integration string
+	receiver    string
+	processes   []string
+	ports       []uint32
+	// runtimes are generic process names, like java, that only match on one of the ports
+	runtimes []string
+	config   func(hostPort string) map[string]interface{}
+}
+
+// match reports whether the service is the integration, by process name or by a generic runtime
+// on a well known port. A service without a process or executable name never matches.
+func (r rule) match(s Service) (bool, bool) {
+	byPort := slices.Contains(r.ports, s.Port)
+	for _, name := range []string{normalize(s.Process), normalize(s.Exe)} {
+		if name == "" {
+			continue
+		}
+		if slices.Contains(r.processes, name) || (byPort && slices.Contains(r.runtimes, name)) {
+			return true, byPort
+		}
+	}
+	return false, false
+}
+
+// secretRef points credentials at the secrets directory, ${kmsecret:<name>}.
+func secretRef(name string) string {
+	return "${kmsecret:" + name + "}"
+}
+
+var rules = []rule{
+	{
+		integration: "mysql", receiver: "mysql",
+		processes: []string{"mysqld", "mariadbd"}, ports: []uint32{3306},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": hp, "username": secretRef("mysql_username"), "password": secretRef("mysql_password")}
+		},
+	},
+	{
+		integration: "postgresql", receiver: "postgresql",
+		processes: []string{"postgres", "postmaster"}, ports: []uint32{5432},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{
+				"endpoint": hp, "username": secretRef("postgresql_username"), "password": secretRef("postgresql_password"),
+				"tls": map[string]interface{}{"insecure": true},
+			}
+		},
+	},
+	{
+		integration: "redis", receiver: "redis",
+		processes: []string{"redis-server", "valkey-server"}, ports: []uint32{6379},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": hp}
+		},
+	},
+	{
+		integration: "nginx", receiver: "nginx",
+		processes: []string{"nginx"}, ports: []uint32{80, 8080},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": "http://" + hp + "/nginx_status"}
+		},
+	},
+	{
+		integration: "apache", receiver: "apache",
+		processes: []string{"httpd", "apache2"}, ports: []uint32{80, 8080},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": "http://" + hp + "/server-status?auto"}
+		},
+	},
+	{
+		// the management plugin port, not AMQP, serves the metrics API
+		integration: "rabbitmq", receiver: "rabbitmq",
+		processes: []string{"rabbitmq-server"}, ports: []uint32{15672}, runtimes: []string{"beam.smp", "beam", "erl"},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": "http://" + hp, "username": secretRef("rabbitmq_username"), "password": secretRef("rabbitmq_password")}
+		},
+	},
+	{
+		integration: "mongodb", receiver: "mongodb",
+		processes: []string{"mongod"}, ports: []uint32{27017},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"hosts": []interface{}{map[string]interface{}{"endpoint": hp}}, "tls": map[string]interface{}{"insecure": true}}
+		},
+	},
+	{
+		integration: "elasticsearch", receiver: "elasticsearch",
+		processes: []string{"elasticsearch", "opensearch"}, ports: []uint32{9200}, runtimes: []string{"java"},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": "http://" + hp}
+		},
+	},
+	{
+		integration: "kafka", receiver: "kafkametrics",
+		processes: []string{"kafka"}, ports: []uint32{9092}, runtimes: []string{"java"},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"brokers": []interface{}{hp}, "scrapers": []interface{}{"brokers", "topics", "consumers"}}
+		},
+	},
+	{
+		integration: "oracledb", receiver: "oracledb",
+		processes: []string{"tnslsnr"}, ports: []uint32{1521},
+		config: func(hp string) map[string]interface{} {
+			return map[string]interface{}{"endpoint": hp, "username": secretRef("oracledb_username"), "password": secretRef("oracledb_password"), "service": "ORCL"}
+		},
+	},
+	{
+		integration: "sqlserver", receiver: "sqlserver",
+		processes: []string{"sqlservr"}, ports: []uint32{1433},
+		config: func(hp string) map[string]interface{} {
+			host, port, _ := net.SplitHostPort(hp)
+			p, _ := strconv.Atoi(port)
+			return map[string]interface{}{"server": host, "port": p, "username": secretRef("sqlserver_username"), "password": secretRef("sqlserver_password")}
+		},
+	},
+}
+
+// dockerSocket is checked to propose docker_stats, which talks to the daemon over its socket.
+var dockerSocket = "/var/run/docker.sock"
+
+// Discover returns the integrations matching the services, at most one per integration.
+func Discover(services []Service) []Candidate {
+	best := map[string]Candidate{}
+	for _, l := range services {
+		for _, r := range rules {
+			matched, byPort := r.match(l)
+			if !matched {
+				continue
+			}
+			hostPort := net.JoinHostPort(endpointHost(l.Address), strconv.Itoa(int(l.Port)))
+			c := Candidate{
+				Integration: r.integration,
+				Receiver:    r.receiver,
+				Process:     cmp.Or(l.Process, l.Exe),
+				Endpoint:    hostPort,
+				Port:        l.Port,
+				Config:      map[string]interface{}{r.receiver + "/discovered": r.config(hostPort)},
+			}
+			// prefer the well known port when a process listens on several
+			if prev, ok := best[r.integration]; !ok || (!slices.Contains(r.ports, prev.Port) && byPort) {
+				best[r.integration] = c
+			}
+		}
+	}
+
+	if _, err := os.Stat(dockerSocket); err == nil {
+		endpoint := "unix://" + dockerSocket
+		best["docker"] = Candidate{
+			Integration: "docker",
+			Receiver:    "docker_stats",
+			Process:     "dockerd",
+			Endpoint:    endpoint,
+			Config:      map[string]interface{}{"docker_stats/discovered": map[string]interface{}{"endpoint": endpoint}},
+		}
+	}
+
+	out := make([]Candidate, 0, len(best))
+	for _, c := range best {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Integration < out[j].Integration })
+	return out
+}
+
+// normalize lower cases a process name and strips the windows executable suffix.
+func normalize(name string) string {
+	if name == "" {
+		return ""
+	}
+	name = strings.ToLower(filepath.Base(name))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// endpointHost maps wildcard bind addresses to the loopback address the agent should scrape.
+func endpointHost(addr string) string {
+	switch addr {
+	case "", "0.0.0.0", "::", "*":
+		return "localhost"
+	}
+	return addr
+}
@@ -0,0 +1,36 @@
+package discovery
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	dockerSocket = filepath.Join(t.TempDir(), "docker.sock")
+	tests := []struct {
+		name     string
+		services []Service
+		want     []string
+	}{
+		{name: "unknown process on a web port", services: []Service{{Port: 80}, {Port: 8080, Address: "0.0.0.0"}}},
+		{name: "unrelated process on a web port", services: []Service{{Process: "node", Port: 8080}}},
+		{name: "nginx by process", services: []Service{{Process: "nginx", Port: 8081}}, want: []string{"nginx"}},
+		{name: "nginx by executable", services: []Service{{Exe: "nginx", Port: 80}}, want: []string{"nginx"}},
+		{name: "windows executable", services: []Service{{Process: "SQLSERVR.EXE", Port: 1433}}, want: []string{"sqlserver"}},
+		{name: "java on the kafka port", services: []Service{{Process: "java", Port: 9092}}, want: []string{"kafka"}},
+		{name: "java elsewhere", services: []Service{{Process: "java", Port: 8080}}},
+		{name: "unknown process on the kafka port", services: []Service{{Port: 9092}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range Discover(tt.services) {
+				got = append(got, c.Integration)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Discover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
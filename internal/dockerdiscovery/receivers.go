@@ -0,0 +1,176 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/collector/confmap"
+)
+
+// Labels read from containers.
+const (
+	// LabelScrapePort enables a prometheus receiver scraping the container on this port
+	LabelScrapePort = "kloudmate.io/scrape-port"
+	// LabelScrapePath overrides the metrics path, defaults to /metrics
+	LabelScrapePath = "kloudmate.io/scrape-path"
+	// LabelScrapeInterval overrides the scrape interval, defaults to 30s
+	LabelScrapeInterval = "kloudmate.io/scrape-interval"
+	// LabelLogs enables a filelog receiver for the container, json parses each line as JSON
+	LabelLogs = "kloudmate.io/logs"
+	// LabelServiceName sets service.name on the container's telemetry
+	LabelServiceName = "kloudmate.io/service-name"
+)
+
+// NewConverterFactory returns a converter adding receivers for the watcher's current targets.
+// The agent restarts the collector when the watcher reports a change, which resolves the config again.
+func NewConverterFactory(w *Watcher) confmap.ConverterFactory {
+	return confmap.NewConverterFactory(func(confmap.ConverterSettings) confmap.Converter {
+		return &converter{watcher: w}
+	})
+}
+
+type converter struct {
+	watcher *Watcher
+}
+
+func (c *converter) Convert(_ context.Context, conf *confmap.Conf) error {
+	overlay := Overlay(conf.ToStringMap(), c.watcher.Targets())
+	if overlay == nil {
+		return nil
+	}
+	return conf.Merge(confmap.NewFromStringMap(overlay))
+}
+
+// Overlay computes the receivers and pipeline changes to merge into cfg for targets.
+// It returns nil when no target asks for collection.
+func Overlay(cfg map[string]any, targets []Target) map[string]any {
+	receivers := map[string]any{}
+	var metricsIDs, logsIDs, logPaths []any
+	for _, t := range targets {
+		if id, r := scrapeReceiver(t); r != nil {
+			receivers[id] = r
+			metricsIDs = append(metricsIDs, id)
+		}
+		if id, r := logsReceiver(t); r != nil {
+			receivers[id] = r
+			logsIDs = append(logsIDs, id)
+			logPaths = append(logPaths, t.LogPath)
+		}
+	}
+	if len(receivers) == 0 {
+		return nil
+	}
+
+	// keep generic container log receivers from shipping the same lines twice
+	existing, _ := cfg["receivers"].(map[string]any)
+	for id, raw := range existing {
+		r, _ := raw.(map[string]any)
+		if !strings.HasPrefix(id, "filelog") || r == nil || len(logPaths) == 0 {
+			continue
+		}
+		exclude, _ := r["exclude"].([]any)
+		receivers[id] = map[string]any{"exclude": append(slices.Clone(exclude), logPaths...)}
+	}
+
+	pipelines := map[string]any{}
+	addToPipeline(cfg, pipelines, "metrics", metricsIDs)
+	addToPipeline(cfg, pipelines, "logs", logsIDs)
+
+	overlay := map[string]any{"receivers": receivers}
+	if len(pipelines) > 0 {
+		overlay["service"] = map[string]any{"pipelines": pipelines}
+	}
+	return overlay
+}
+
+// addToPipeline appends ids to the default pipeline of the signal, e.g. metrics, or the first metrics/* pipeline.
+func addToPipeline(cfg map[string]any, overlay map[string]any, signal string, ids []any) {
+	if len(ids) == 0 {
+		return
+	}
+	service, _ := cfg["service"].(map[string]any)
+	pipelines, _ := service["pipelines"].(map[string]any)
+	name := ""
+	if _, ok := pipelines[signal]; ok {
+		name = signal
+	} else {
+		var names []string
+		for n := range pipelines {
+			if strings.HasPrefix(n, signal+"/") {
+				names = append(names, n)
+			}
+		}
+		slices.Sort(names)
+		if len(names) > 0 {
+			name = names[0]
+		}
+	}
+	if name == "" {
+		return
+	}
+	pipeline, _ := pipelines[name].(map[string]any)
+	current, _ := pipeline["receivers"].([]any)
+	overlay[name] = map[string]any{"receivers": append(slices.Clone(current), ids...)}
+}
+
+func scrapeReceiver(t Target) (string, map[string]any) {
+	port := t.Labels[LabelScrapePort]
+	if port == "" || t.Host == "" {
+		return "", nil
+	}
+	path := t.Labels[LabelScrapePath]
+	if path == "" {
+		path = "/metrics"
+	}
+	interval := t.Labels[LabelScrapeInterval]
+	if interval == "" {
+		interval = "30s"
+	}
+	labels := map[string]any{
+		"container_id":   shortID(t.ID),
+		"container_name": t.Name,
+	}
+	if svc := t.Labels[LabelServiceName]; svc != "" {
+		labels["service_name"] = svc
+	}
+	return "prometheus/docker-" + t.Name, map[string]any{
+		"config": map[string]any{
+			"scrape_configs": []any{map[string]any{
+				"job_name":        t.Name,
+				"scrape_interval": interval,
+				"metrics_path":    path,
+				"static_configs": []any{map[string]any{
+					"targets": []any{net.JoinHostPort(t.Host, port)},
+					"labels":  labels,
+				}},
+			}},
+		},
+	}
+}
+
+func logsReceiver(t Target) (string, map[string]any) {
+	mode := strings.ToLower(t.Labels[LabelLogs])
+	if mode == "" || mode == "false" || t.LogPath == "" {
+		return "", nil
+	}
+	operators := []any{map[string]any{"type": "container", "format": "docker", "add_metadata_from_filepath": false}}
+	if mode == "json" {
+		operators = append(operators, map[string]any{"type": "json_parser", "parse_from": "body", "on_error": "send"})
+	}
+	resource := map[string]any{
+		"container.id":         t.ID,
+		"container.name":       t.Name,
+		"container.image.name": t.Image,
+	}
+	if svc := t.Labels[LabelServiceName]; svc != "" {
+		resource["service.name"] = svc
+	}
+	return "filelog/docker-" + t.Name, map[string]any{
+		"include":   []any{t.LogPath},
+		"start_at":  "end",
+		"operators": operators,
+		"resource":  resource,
+	}
+}
@@ -0,0 +1,275 @@
+// Package dockerdiscovery watches the Docker daemon and builds receivers for containers that opt in through labels.
+package dockerdiscovery
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// DefaultEndpoint is the Docker daemon socket used when no endpoint is configured.
+const DefaultEndpoint = "unix:///var/run/docker.sock"
+
+const labelPrefix = "kloudmate.io/"
+
+// Target is a running container that asked for collection through labels.
+type Target struct {
+	ID      string
+	Name    string
+	Image   string
+	Host    string
+	LogPath string
+	Labels  map[string]string
+}
+
+// dockerAPI is the part of the Docker client the watcher uses.
+type dockerAPI interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerInspect(ctx context.Context, id string) (container.InspectResponse, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+}
+
+// Watcher keeps the set of labelled containers in sync with the Docker daemon.
+type Watcher struct {
+	client   dockerAPI
+	logger   *zap.SugaredLogger
+	debounce time.Duration
+
+	mu      sync.RWMutex
+	targets map[string]Target
+	timer   *time.Timer
+	changes chan struct{}
+}
+
+// NewWatcher connects to the Docker daemon at endpoint, e.g. unix:///var/run/docker.sock or tcp://host:2375.
+func NewWatcher(endpoint string, logger *zap.SugaredLogger) (*Watcher, error) {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	cli, err := client.NewClientWithOpts(client.WithHost(endpoint), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return newWatcher(cli, logger), nil
+}
+
+func newWatcher(cli dockerAPI, logger *zap.SugaredLogger) *Watcher {
+	return &Watcher{
+		client:   cli,
+		logger:   logger,
+		debounce: 5 * time.Second,
+		targets:  map[string]Target{},
+		changes:  make(chan struct{}, 1),
+	}
+}
+
+// Changes is signalled once the set of targets settles after a change.
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Targets returns the current targets ordered by name.
+func (w *Watcher) Targets() []Target {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	out := make([]Target, 0, len(w.targets))
+	for _, t := range w.targets {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Sync takes the initial snapshot of the labelled containers currently running. It does not signal
+// Changes, the collector is started with this snapshot.
+func (w *Watcher) Sync(ctx context.Context) error {
+	return w.sync(ctx, false)
+}
+
+// sync replaces the targets with the labelled containers currently running and, with notify,
+// signals Changes when they differ from the previous set.
+func (w *Watcher) sync(ctx context.Context, notify bool) error {
+	containers, err := w.client.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	targets := map[string]Target{}
+	for _, c := range containers {
+		if !labelled(c.Labels) {
+			continue
+		}
+		t, err := w.inspect(ctx, c.ID)
+		if err != nil {
+			w.logger.Warnw("failed to inspect container", "id", c.ID, "error", err)
+			continue
+		}
+		targets[t.ID] = t
+	}
+
+	w.mu.Lock()
+	changed := !maps.EqualFunc(w.targets, targets, targetEqual)
+	w.targets = targets
+	w.mu.Unlock()
+	if changed && notify {
+		w.notify()
+	}
+	return nil
+}
+
+// Run syncs and follows container events until ctx is done, reconnecting with backoff.
+func (w *Watcher) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := w.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		w.logger.Warnw("docker event stream interrupted, reconnecting", "error", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func (w *Watcher) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// subscribe before syncing so no container started in between is missed
+	msgs, errs := w.client.Events(ctx, events.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionStart)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionDestroy)),
+	)})
+	// containers may have changed since the initial snapshot or while disconnected
+	if err := w.sync(ctx, true); err != nil {
+		return err
+	}
+	for {
+		select {
+		case msg := <-msgs:
+			w.handle(ctx, msg)
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *Watcher) handle(ctx context.Context, msg events.Message) {
+	id := msg.Actor.ID
+	switch msg.Action {
+	case events.ActionStart:
+		if !labelled(msg.Actor.Attributes) {
+			return
+		}
+		t, err := w.inspect(ctx, id)
+		if err != nil {
+			w.logger.Warnw("failed to inspect started container", "id", id, "error", err)
+			return
+		}
+		w.mu.Lock()
+		w.targets[id] = t
+		w.mu.Unlock()
+		w.logger.Infow("discovered docker container", "name", t.Name, "id", shortID(id))
+		w.notify()
+	case events.ActionDie, events.ActionDestroy:
+		w.mu.Lock()
+		t, ok := w.targets[id]
+		delete(w.targets, id)
+		w.mu.Unlock()
+		if ok {
+			w.logger.Infow("docker container gone", "name", t.Name, "id", shortID(id))
+			w.notify()
+		}
+	}
+}
+
+func (w *Watcher) inspect(ctx context.Context, id string) (Target, error) {
+	info, err := w.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return Target{}, err
+	}
+	t := Target{ID: info.ID, LogPath: info.LogPath}
+	if info.ContainerJSONBase == nil {
+		return Target{}, fmt.Errorf("container %s has no inspect data", id)
+	}
+	t.Name = strings.TrimPrefix(info.Name, "/")
+	if info.Config != nil {
+		t.Image = info.Config.Image
+		t.Labels = info.Config.Labels
+	}
+	t.Host = containerHost(info)
+	return t, nil
+}
+
+// containerHost returns the address the agent scrapes the container on.
+func containerHost(info container.InspectResponse) string {
+	if info.HostConfig != nil && info.HostConfig.NetworkMode.IsHost() {
+		return "localhost"
+	}
+	if info.NetworkSettings == nil {
+		return ""
+	}
+	names := make([]string, 0, len(info.NetworkSettings.Networks))
+	for name := range info.NetworkSettings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ep := info.NetworkSettings.Networks[name]; ep != nil && ep.IPAddress != "" {
+			return ep.IPAddress
+		}
+	}
+	return ""
+}
+
+// notify signals Changes once no further change happened within the debounce window.
+func (w *Watcher) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.debounce, func() {
+		select {
+		case w.changes <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func labelled(labels map[string]string) bool {
+	for k := range labels {
+		if strings.HasPrefix(k, labelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func targetEqual(a, b Target) bool {
+	return a.ID == b.ID && a.Host == b.Host && a.LogPath == b.LogPath && maps.Equal(a.Labels, b.Labels)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
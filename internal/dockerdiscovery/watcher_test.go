@@ -0,0 +1,111 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeDocker is a Docker daemon with a fixed set of containers and an event stream fed by the test.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers map[string]map[string]string
+	events     chan events.Message
+}
+
+func (f *fakeDocker) add(id string, labels map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers[id] = labels
+}
+
+func (f *fakeDocker) ContainerList(context.Context, container.ListOptions) ([]container.Summary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []container.Summary
+	for id, labels := range f.containers {
+		out = append(out, container.Summary{ID: id, Labels: labels})
+	}
+	return out, nil
+}
+
+func (f *fakeDocker) ContainerInspect(_ context.Context, id string) (container.InspectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{ID: id, Name: "/" + id, LogPath: "/var/lib/docker/containers/" + id + ".log"},
+		Config:            &container.Config{Image: id + ":latest", Labels: f.containers[id]},
+		NetworkSettings: &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"bridge": {IPAddress: "172.17.0.2"},
+		}},
+	}, nil
+}
+
+func (f *fakeDocker) Events(context.Context, events.ListOptions) (<-chan events.Message, <-chan error) {
+	return f.events, make(chan error)
+}
+
+func TestWatcher(t *testing.T) {
+	docker := &fakeDocker{
+		containers: map[string]map[string]string{
+			"web":   {"kloudmate.io/scrape-port": "9100"},
+			"cache": {"com.example": "unlabelled"},
+		},
+		events: make(chan events.Message),
+	}
+	w := newWatcher(docker, zaptest.NewLogger(t).Sugar())
+	w.debounce = 10 * time.Millisecond
+
+	if err := w.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertTargets(t, w, "web")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// neither the initial snapshot nor the resync on connect may restart the collector
+	select {
+	case <-w.Changes():
+		t.Fatal("Changes signalled without a container change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	docker.add("db", map[string]string{"kloudmate.io/logs": "true"})
+	docker.events <- events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "db", Attributes: map[string]string{"kloudmate.io/logs": "true"}}}
+	waitChange(t, w)
+	assertTargets(t, w, "db", "web")
+
+	docker.events <- events.Message{Action: events.ActionDie, Actor: events.Actor{ID: "web"}}
+	waitChange(t, w)
+	assertTargets(t, w, "db")
+}
+
+func waitChange(t *testing.T, w *Watcher) {
+	t.Helper()
+	select {
+	case <-w.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Changes not signalled")
+	}
+}
+
+func assertTargets(t *testing.T, w *Watcher, names ...string) {
+	t.Helper()
+	targets := w.Targets()
+	if len(targets) != len(names) {
+		t.Fatalf("Targets() = %+v, want %v", targets, names)
+	}
+	for i, target := range targets {
+		if target.Name != names[i] || target.Host != "172.17.0.2" {
+			t.Errorf("Targets()[%d] = %+v, want %s on 172.17.0.2", i, target, names[i])
+		}
+	}
+}
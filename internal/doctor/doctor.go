@@ -0,0 +1,98 @@
+// Package doctor runs preflight checks of the agent's connectivity to KloudMate and of its
+// permissions on the local data sources, each reported with a remediation hint on failure.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/config"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	Pass Status = "PASS"
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+	Skip Status = "SKIP"
+)
+
+// Result reports one check, Hint suggests a fix when the check did not pass.
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+func pass(name, format string, args ...any) Result {
+	return Result{Name: name, Status: Pass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func skip(name, format string, args ...any) Result {
+	return Result{Name: name, Status: Skip, Detail: fmt.Sprintf(format, args...)}
+}
+
+func fail(name string, err error, hint string) Result {
+	return Result{Name: name, Status: Fail, Detail: err.Error(), Hint: hint}
+}
+
+func warn(name, detail, hint string) Result {
+	return Result{Name: name, Status: Warn, Detail: detail, Hint: hint}
+}
+
+// Doctor runs the checks against a loaded agent configuration.
+type Doctor struct {
+	cfg     *config.Config
+	client  *http.Client
+	timeout time.Duration
+}
+
+// New returns a Doctor using the agent's proxy and TLS settings for control plane calls.
+func New(cfg *config.Config) (*Doctor, error) {
+	transport, err := cfg.Network.NewTransport()
+	if err != nil {
+		return nil, fmt.Errorf("invalid network settings: %w", err)
+	}
+	return &Doctor{
+		cfg:     cfg,
+		client:  &http.Client{Transport: transport},
+		timeout: 15 * time.Second,
+	}, nil
+}
+
+// Run executes every check in order. Checks that depend on an earlier one are skipped when it failed,
+// so the first failure points at the root cause.
+func (d *Doctor) Run(ctx context.Context) []Result {
+	var results []Result
+	results = append(results, d.checkNetwork(ctx)...)
+	results = append(results, d.checkFilelog())
+	results = append(results, d.checkJournald())
+	results = append(results, d.checkDocker(ctx))
+	return results
+}
+
+// Failed reports whether any result failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes one line per result and an indented hint for results that did not pass.
+func Print(w io.Writer, results []Result) {
+	for _, r := range results {
+		fmt.Fprintf(w, "[%s] %-14s %s\n", r.Status, r.Name, r.Detail)
+		if r.Hint != "" && r.Status != Pass {
+			fmt.Fprintf(w, "       hint: %s\n", r.Hint)
+		}
+	}
+}
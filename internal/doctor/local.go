@@ -0,0 +1,212 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// maxProbedFiles bounds how many files matched by filelog globs are opened.
+const maxProbedFiles = 500
+
+var envRef = regexp.MustCompile(`\$\{(?:env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// checkFilelog opens the files matched by the include globs of every filelog receiver in the
+// collector config, the way the receiver would with the agent's privileges.
+func (d *Doctor) checkFilelog() Result {
+	const name = "filelog"
+	data, err := os.ReadFile(d.cfg.OtelConfigPath)
+	if err != nil {
+		return fail(name, err, "check the config flag points at the collector config")
+	}
+	var doc struct {
+		Receivers map[string]struct {
+			Include []string `yaml:"include"`
+			Exclude []string `yaml:"exclude"`
+		} `yaml:"receivers"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fail(name, fmt.Errorf("failed to parse %s: %w", d.cfg.OtelConfigPath, err), "")
+	}
+
+	var include, exclude []string
+	for id, r := range doc.Receivers {
+		if typ, _, _ := strings.Cut(id, "/"); typ == "filelog" {
+			include = append(include, r.Include...)
+			exclude = append(exclude, r.Exclude...)
+		}
+	}
+	if len(include) == 0 {
+		return skip(name, "no filelog receivers configured")
+	}
+
+	var matched, readable int
+	var denied []string
+	for _, pattern := range include {
+		files, err := doublestar.FilepathGlob(expandEnv(pattern), doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				denied = append(denied, pattern)
+			}
+			continue
+		}
+		for _, file := range files {
+			if excluded(file, exclude) {
+				continue
+			}
+			matched++
+			if matched > maxProbedFiles {
+				break
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				denied = append(denied, file)
+				continue
+			}
+			f.Close()
+			readable++
+		}
+	}
+
+	switch {
+	case len(denied) > 0:
+		return fail(name, fmt.Errorf("%d of %d matched paths are not readable, e.g. %s", len(denied), matched, denied[0]), permissionHint())
+	case matched == 0:
+		return warn(name, "no files match "+strings.Join(include, ", "), "check the include globs, files created later are picked up once they exist")
+	default:
+		return pass(name, "%d files readable", readable)
+	}
+}
+
+func excluded(file string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.PathMatch(expandEnv(p), file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandEnv resolves ${env:NAME} and ${NAME} references the way the collector would.
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+	})
+}
+
+func permissionHint() string {
+	if runtime.GOOS == "windows" {
+		return "run the KloudMate Agent service as LocalSystem or grant its account read access to the files"
+	}
+	return "grant the agent read access, e.g. `setfacl -R -m u:<agent user>:rX <dir>` or add it to the group owning the logs (often adm)"
+}
+
+// checkJournald reads the systemd journal directories, which requires root or membership of the
+// systemd-journal group.
+func (d *Doctor) checkJournald() Result {
+	const name = "journald"
+	if runtime.GOOS != "linux" {
+		return skip(name, "not a Linux host")
+	}
+	var found bool
+	for _, dir := range []string{"/var/log/journal", "/run/log/journal"} {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		found = true
+		file, err := firstJournal(dir)
+		if err != nil {
+			return fail(name, err, "add the agent user to the systemd-journal group: `usermod -aG systemd-journal <agent user>`")
+		}
+		if file == "" {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return fail(name, err, "add the agent user to the systemd-journal group: `usermod -aG systemd-journal <agent user>`")
+		}
+		f.Close()
+		return pass(name, "journal readable at %s", dir)
+	}
+	if !found {
+		return skip(name, "no systemd journal on this host")
+	}
+	return warn(name, "journal directories contain no journal files", "enable persistent storage with Storage=persistent in journald.conf")
+}
+
+// firstJournal returns the first *.journal file below dir, one machine-id directory deep.
+func firstJournal(dir string) (string, error) {
+	var file string
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() && strings.Count(strings.TrimPrefix(path, dir), string(filepath.Separator)) > 1 {
+			return filepath.SkipDir
+		}
+		if !e.IsDir() && strings.HasSuffix(path, ".journal") {
+			file = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return file, err
+}
+
+// checkDocker pings the Docker daemon used for container metrics and discovery.
+func (d *Doctor) checkDocker(ctx context.Context) Result {
+	const name = "docker"
+	endpoint := d.cfg.DockerEndpoint
+	if endpoint == "" {
+		endpoint = "unix:///var/run/docker.sock"
+	}
+	socket, isUnix := strings.CutPrefix(endpoint, "unix://")
+	if isUnix {
+		if _, err := os.Stat(socket); err != nil {
+			if !d.cfg.DockerMode {
+				return skip(name, "no Docker socket at %s", socket)
+			}
+			return fail(name, err, "mount the Docker socket into the agent container: -v /var/run/docker.sock:/var/run/docker.sock:ro")
+		}
+	}
+
+	transport := &http.Transport{Proxy: nil}
+	base := strings.Replace(endpoint, "tcp://", "http://", 1)
+	if isUnix {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		base = "http://docker"
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/_ping", nil)
+	if err != nil {
+		return fail(name, err, "check docker-endpoint")
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return fail(name, err, "add the agent user to the docker group: `usermod -aG docker <agent user>`")
+		}
+		return fail(name, err, "check that the Docker daemon is running and docker-endpoint is correct")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fail(name, fmt.Errorf("ping returned status %d", resp.StatusCode), "check docker-endpoint")
+	}
+	return pass(name, "Docker API %s reachable at %s", resp.Header.Get("Api-Version"), endpoint)
+}
@@ -0,0 +1,70 @@
+package doctor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/config"
+)
+
+func TestCheckConfigAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   Status
+		hint   string
+	}{
+		{name: "accepted", status: http.StatusOK, want: Pass},
+		{name: "incomplete request", status: http.StatusBadRequest, want: Pass},
+		{name: "invalid request", status: http.StatusUnprocessableEntity, want: Pass},
+		{name: "method not allowed", status: http.StatusMethodNotAllowed, want: Fail},
+		{name: "rejected key", status: http.StatusUnauthorized, want: Fail, hint: keyHint},
+		{name: "forbidden key", status: http.StatusForbidden, want: Fail, hint: keyHint},
+		{name: "wrong path", status: http.StatusNotFound, want: Fail},
+		{name: "server error", status: http.StatusBadGateway, want: Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the probe must not look like a config check from a real agent
+				if r.Method != http.MethodPost {
+					t.Errorf("method = %s, want POST", r.Method)
+				}
+				if body, _ := io.ReadAll(r.Body); string(body) != "{}" {
+					t.Errorf("probe sent host details: %s", body)
+				}
+				if got := r.Header.Get("Authorization"); got != "test-key" {
+					t.Errorf("Authorization = %q", got)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			d := &Doctor{
+				cfg:     &config.Config{APIKey: "test-key", ConfigUpdateURL: srv.URL + "/agents/config-check"},
+				client:  srv.Client(),
+				timeout: 5 * time.Second,
+			}
+			got := d.checkConfigAPI(context.Background())
+			if got.Status != tt.want {
+				t.Errorf("status = %s (%s), want %s", got.Status, got.Detail, tt.want)
+			}
+			if tt.hint != "" && got.Hint != tt.hint {
+				t.Errorf("hint = %q, want %q", got.Hint, tt.hint)
+			}
+		})
+	}
+}
+
+func TestCheckConfigAPIUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	d := &Doctor{cfg: &config.Config{ConfigUpdateURL: srv.URL}, client: http.DefaultClient, timeout: time.Second}
+	if got := d.checkConfigAPI(context.Background()); got.Status != Fail {
+		t.Errorf("status = %s, want %s", got.Status, Fail)
+	}
+}
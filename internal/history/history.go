@@ -0,0 +1,321 @@
+// Package history keeps a bounded, on-disk record of collector configs applied by the agent.
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SourceRemote = "remote"
+	SourceLocal  = "local"
+	SourceCLI    = "cli"
+
+	OutcomeApplied  = "applied"
+	OutcomeRejected = "rejected"
+	OutcomeFailed   = "failed"
+
+	indexFile = "index.json"
+	pauseFile = "remote-updates.paused"
+	lockName  = ".lock"
+)
+
+// DefaultLimit is the number of snapshots kept when no limit is configured.
+const DefaultLimit = 20
+
+// Entry describes one snapshot in the history.
+type Entry struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+	Source    string    `json:"source"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// Pause records why remote updates were paused.
+type Pause struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// Store is a history directory holding one snapshot file per version and an index. The running
+// agent and the kmagent config commands share the directory, changes are serialized by a lock
+// file in it and files are replaced atomically, so readers never see a partial write.
+type Store struct {
+	dir   string
+	limit int
+	mu    sync.Mutex
+}
+
+// New returns a store in dir keeping at most limit snapshots.
+func New(dir string, limit int) *Store {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	return &Store{dir: dir, limit: limit}
+}
+
+// Hash returns the hex sha256 of a config.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record stores a snapshot of data and prunes the oldest entries beyond the limit.
+func (s *Store) Record(data []byte, source, outcome, detail string) (Entry, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer unlock()
+	return s.record(data, source, outcome, detail)
+}
+
+// RecordFile snapshots the config file at path unless it matches the latest applied entry,
+// so local edits are preserved before they are overwritten.
+func (s *Store) RecordFile(path, source string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.recordFile(path, source)
+}
+
+// List returns the entries from oldest to newest.
+func (s *Store) List() ([]Entry, error) {
+	return s.load()
+}
+
+// Get returns an entry and its snapshot.
+func (s *Store) Get(version int) (Entry, []byte, error) {
+	entries, err := s.load()
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	for _, e := range entries {
+		if e.Version == version {
+			data, err := os.ReadFile(s.snapshotPath(version))
+			if err != nil {
+				return Entry{}, nil, fmt.Errorf("failed to read snapshot %d: %w", version, err)
+			}
+			return e, data, nil
+		}
+	}
+	return Entry{}, nil, fmt.Errorf("config version %d not found in history", version)
+}
+
+// Rollback writes the snapshot of version to path, records it as a CLI change and pauses
+// remote updates so the next config check does not overwrite it.
+func (s *Store) Rollback(version int, path string) (Entry, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer unlock()
+	_, data, err := s.Get(version)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := s.recordFile(path, SourceLocal); err != nil {
+		return Entry{}, err
+	}
+	if err := WriteFile(path, data); err != nil {
+		return Entry{}, err
+	}
+	detail := fmt.Sprintf("rollback to version %d", version)
+	entry, err := s.record(data, SourceCLI, OutcomeApplied, detail)
+	if err != nil {
+		return Entry{}, err
+	}
+	return entry, s.pause(detail)
+}
+
+// Pause stops the agent from applying remote configs until Resume is called.
+func (s *Store) Pause(reason string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.pause(reason)
+}
+
+// Resume allows remote configs to be applied again.
+func (s *Store) Resume() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = os.Remove(filepath.Join(s.dir, pauseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Paused returns the pause record, nil when remote updates are allowed.
+func (s *Store) Paused() (*Pause, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, pauseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Pause
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse pause marker: %w", err)
+	}
+	return &p, nil
+}
+
+// WriteFile replaces path atomically through a temporary file in the same directory.
+func WriteFile(path string, data []byte) error {
+	tempFile := path + ".new"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write new config to temporary file: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
+
+// lock takes the history lock shared with other processes, the returned func releases it.
+func (s *Store) lock() (func(), error) {
+	s.mu.Lock()
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, lockName), os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to open history lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock history: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *Store) recordFile(path, source string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config for history: %w", err)
+	}
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	hash := Hash(data)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Outcome == OutcomeApplied {
+			if entries[i].Hash == hash {
+				return nil
+			}
+			break
+		}
+	}
+	_, err = s.record(data, source, OutcomeApplied, "")
+	return err
+}
+
+func (s *Store) pause(reason string) error {
+	data, err := json.Marshal(Pause{Reason: reason, Since: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(s.dir, pauseFile), data)
+}
+
+func (s *Store) record(data []byte, source, outcome, detail string) (Entry, error) {
+	entries, err := s.load()
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{
+		Version:   1,
+		Timestamp: time.Now().UTC(),
+		Hash:      Hash(data),
+		Source:    source,
+		Outcome:   outcome,
+		Detail:    detail,
+	}
+	if n := len(entries); n > 0 {
+		entry.Version = entries[n-1].Version + 1
+	}
+
+	if err := os.WriteFile(s.snapshotPath(entry.Version), data, 0640); err != nil {
+		return Entry{}, fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	entries = append(entries, entry)
+	for len(entries) > s.limit {
+		os.Remove(s.snapshotPath(entries[0].Version))
+		entries = entries[1:]
+	}
+	return entry, s.save(entries)
+}
+
+func (s *Store) snapshotPath(version int) string {
+	return filepath.Join(s.dir, strconv.Itoa(version)+".yaml")
+}
+
+func (s *Store) load() ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history index: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse history index: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *Store) save(entries []Entry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(filepath.Join(s.dir, indexFile), data); err != nil {
+		return fmt.Errorf("failed to write history index: %w", err)
+	}
+	return nil
+}
+
+// writeAtomic replaces a file in the history directory through a temporary file.
+func writeAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".new", data, 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		os.Remove(path + ".new")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	s := New(t.TempDir(), 3)
+	for i := 1; i <= 5; i++ {
+		entry, err := s.Record([]byte("v"+strconv.Itoa(i)), SourceRemote, OutcomeApplied, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Version != i || entry.Hash != Hash([]byte("v"+strconv.Itoa(i))) {
+			t.Errorf("Record() = %+v, want version %d", entry, i)
+		}
+	}
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, e := range entries {
+		versions = append(versions, e.Version)
+	}
+	if len(versions) != 3 || versions[0] != 3 || versions[2] != 5 {
+		t.Fatalf("versions = %v, want the latest 3", versions)
+	}
+	if _, _, err := s.Get(1); err == nil {
+		t.Error("pruned version still available")
+	}
+	if _, err := os.Stat(s.snapshotPath(1)); !os.IsNotExist(err) {
+		t.Errorf("pruned snapshot kept: %v", err)
+	}
+	if _, data, err := s.Get(4); err != nil || string(data) != "v4" {
+		t.Errorf("Get(4) = %q, %v", data, err)
+	}
+}
+
+func TestRecordFile(t *testing.T) {
+	dir := t.TempDir()
+	s := New(filepath.Join(dir, "history"), 0)
+	path := filepath.Join(dir, "config.yaml")
+
+	if err := s.RecordFile(path, SourceLocal); err != nil {
+		t.Fatalf("missing config: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.RecordFile(path, SourceLocal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a rejected config does not hide the applied one the file matches
+	if _, err := s.Record([]byte("bad"), SourceRemote, OutcomeRejected, "policy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordFile(path, SourceLocal); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Source != SourceLocal || entries[1].Outcome != OutcomeRejected {
+		t.Errorf("entries = %+v, want the local file once and the rejection", entries)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	s := New(filepath.Join(dir, "history"), 0)
+	path := filepath.Join(dir, "config.yaml")
+	if _, err := s.Record([]byte("good"), SourceRemote, OutcomeApplied, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := s.Rollback(1, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "good" {
+		t.Errorf("config = %q, want the restored version", data)
+	}
+	if entry.Version != 3 || entry.Source != SourceCLI || entry.Detail != "rollback to version 1" {
+		t.Errorf("Rollback() = %+v", entry)
+	}
+	if _, data, err := s.Get(2); err != nil || string(data) != "edited" {
+		t.Errorf("local edit not kept before the rollback: %q, %v", data, err)
+	}
+	pause, err := s.Paused()
+	if err != nil || pause == nil || pause.Reason != "rollback to version 1" {
+		t.Errorf("Paused() = %+v, %v, want paused by the rollback", pause, err)
+	}
+
+	if _, err := s.Rollback(42, path); err == nil {
+		t.Error("rollback to an unknown version succeeded")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "history"), 0)
+	if pause, err := s.Paused(); err != nil || pause != nil {
+		t.Fatalf("Paused() = %+v, %v, want not paused", pause, err)
+	}
+	if err := s.Resume(); err != nil {
+		t.Errorf("Resume() without a pause: %v", err)
+	}
+	if err := s.Pause("maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	if pause, err := s.Paused(); err != nil || pause == nil || pause.Reason != "maintenance" || pause.Since.IsZero() {
+		t.Errorf("Paused() = %+v, %v", pause, err)
+	}
+	if err := s.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if pause, err := s.Paused(); err != nil || pause != nil {
+		t.Errorf("Paused() after Resume() = %+v, %v", pause, err)
+	}
+}
+
+func TestStoresShareTheLock(t *testing.T) {
+	// each store opens its own lock file handle, like the agent and a CLI command do
+	dir := t.TempDir()
+	stores := []*Store{New(dir, 100), New(dir, 100)}
+	var wg sync.WaitGroup
+	for _, s := range stores {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(s *Store, i int) {
+				defer wg.Done()
+				if _, err := s.Record([]byte(strconv.Itoa(i)), SourceRemote, OutcomeApplied, ""); err != nil {
+					t.Error(err)
+				}
+			}(s, i)
+		}
+	}
+	wg.Wait()
+	entries, err := stores[0].List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("recorded %d entries, want 20", len(entries))
+	}
+	for i, e := range entries {
+		if e.Version != i+1 {
+			t.Fatalf("entry %d has version %d, writes were lost", i, e.Version)
+		}
+	}
+}
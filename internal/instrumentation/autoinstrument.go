@@ -8,8 +8,8 @@ import (
 
 type InstrumentAnnotiation map[string]any
 
-// KmCrdAnnotation annotation tells deployment to connect to km-instrumentation crd and enabled/disable the instrumentation
-func KmCrdAnnotation(osl string, enabled bool) (InstrumentAnnotiation, map[string]string) {
+// SharedCRD returns the namespace and name of the Instrumentation resource installed with the chart.
+func SharedCRD() (string, string) {
 	ns := os.Getenv("KM_NAMESPACE")
 	if ns == "" {
 		ns = "km-agent"
@@ -18,21 +18,23 @@ func KmCrdAnnotation(osl string, enabled bool) (InstrumentAnnotiation, map[strin
 	if crd == "" {
 		crd = "km-agent-instrumentation-crd"
 	}
-	lang := ""
-	switch osl {
-	case "nodejs":
-		lang = "nodejs"
-	case "Java":
-		lang = "java"
-	case "Python":
-		lang = "python"
-	case "Go":
-		lang = "go"
-	case "dotnet":
-		lang = "dotnet"
-	}
+	return ns, crd
+}
 
-	if ns == "" || lang == "" {
+// KmCrdAnnotation annotation tells deployment to connect to km-instrumentation crd and enabled/disable the instrumentation
+func KmCrdAnnotation(osl string, enabled bool) (InstrumentAnnotiation, map[string]string) {
+	return KmCrdAnnotationRef(osl, enabled, "")
+}
+
+// KmCrdAnnotationRef is KmCrdAnnotation pointing at the Instrumentation resource ref, namespace/name,
+// instead of the shared one when ref is set.
+func KmCrdAnnotationRef(osl string, enabled bool, ref string) (InstrumentAnnotiation, map[string]string) {
+	if ref == "" {
+		ns, crd := SharedCRD()
+		ref = fmt.Sprintf("%s/%s", ns, crd)
+	}
+	key := LanguageFor(osl).InjectAnnotation()
+	if key == "" {
 		return InstrumentAnnotiation{}, nil
 	}
 
@@ -44,12 +46,12 @@ func KmCrdAnnotation(osl string, enabled bool) (InstrumentAnnotiation, map[strin
 						// this annotation will tell k8s api to trigger rollout
 						"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
 						// contains location/scope of instrumentation crd
-						fmt.Sprintf("instrumentation.opentelemetry.io/inject-%s", lang): fmt.Sprintf("%s/%s", ns, crd),
+						key: ref,
 						// TODO: target specific containers
 						// "instrumentation.opentelemetry.io/container-names": fmt.Sprintf("%t", enabled),
 					},
 				},
 			},
 		},
-	}, map[string]string{fmt.Sprintf("instrumentation.opentelemetry.io/inject-%s", lang): fmt.Sprintf("%s/%s", ns, crd)}
+	}, map[string]string{key: ref}
 }
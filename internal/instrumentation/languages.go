@@ -0,0 +1,77 @@
+package instrumentation
+
+import "strings"
+
+// Method is how workloads of a language are instrumented.
+type Method string
+
+const (
+	// MethodOperator injects an OpenTelemetry agent through the operator's inject annotation
+	MethodOperator Method = "operator"
+	// MethodEBPF leaves the workload untouched, the agent's eBPF receiver instruments it
+	MethodEBPF Method = "ebpf"
+	// MethodUnsupported means the workload cannot be instrumented
+	MethodUnsupported Method = "unsupported"
+)
+
+// Language describes a language reported by the detector.
+type Language struct {
+	// Name is the spelling reported to KloudMate, e.g. Java
+	Name string
+	// Key names the language in inject annotations, Instrumentation specs and the
+	// kloudmate.io/instrumentation annotation, e.g. java
+	Key string
+	// Aliases are other spellings accepted for the language
+	Aliases []string
+	Method  Method
+}
+
+// InjectAnnotation returns the operator annotation that instruments the language, empty unless it
+// uses MethodOperator.
+func (l Language) InjectAnnotation() string {
+	if l.Method != MethodOperator {
+		return ""
+	}
+	return "instrumentation.opentelemetry.io/inject-" + l.Key
+}
+
+// languages are the languages the agent knows, add new ones here.
+var languages = []Language{
+	{Name: "Java", Key: "java", Aliases: []string{"jvm", "kotlin", "scala"}, Method: MethodOperator},
+	{Name: "Python", Key: "python", Aliases: []string{"py"}, Method: MethodOperator},
+	{Name: "nodejs", Key: "nodejs", Aliases: []string{"node", "node.js", "javascript", "typescript"}, Method: MethodOperator},
+	{Name: "Go", Key: "go", Aliases: []string{"golang"}, Method: MethodOperator},
+	{Name: "dotnet", Key: "dotnet", Aliases: []string{".net", "csharp", "c#"}, Method: MethodOperator},
+	{Name: "PHP", Key: "php", Method: MethodEBPF},
+	{Name: "Ruby", Key: "ruby", Aliases: []string{"rb"}, Method: MethodEBPF},
+	{Name: "Rust", Key: "rust", Method: MethodEBPF},
+}
+
+var languageIndex = func() map[string]Language {
+	index := map[string]Language{}
+	for _, l := range languages {
+		for _, name := range append([]string{l.Name, l.Key}, l.Aliases...) {
+			index[normalizeLanguage(name)] = l
+		}
+	}
+	return index
+}()
+
+func normalizeLanguage(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// LanguageFor is LookupLanguage without the ok result.
+func LanguageFor(name string) Language {
+	l, _ := LookupLanguage(name)
+	return l
+}
+
+// LookupLanguage finds a language by name, key or alias, ignoring case. Unknown languages are
+// returned with MethodUnsupported and ok false.
+func LookupLanguage(name string) (Language, bool) {
+	if l, ok := languageIndex[normalizeLanguage(name)]; ok {
+		return l, true
+	}
+	return Language{Name: name, Key: normalizeLanguage(name), Method: MethodUnsupported}, false
+}
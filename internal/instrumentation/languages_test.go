@@ -0,0 +1,65 @@
+package instrumentation
+
+import "testing"
+
+func TestLookupLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		key    string
+		method Method
+		known  bool
+	}{
+		{name: "Java", want: "Java", key: "java", method: MethodOperator, known: true},
+		{name: " KOTLIN ", want: "Java", key: "java", method: MethodOperator, known: true},
+		{name: "golang", want: "Go", key: "go", method: MethodOperator, known: true},
+		{name: "node.js", want: "nodejs", key: "nodejs", method: MethodOperator, known: true},
+		{name: "C#", want: "dotnet", key: "dotnet", method: MethodOperator, known: true},
+		{name: "RUBY", want: "Ruby", key: "ruby", method: MethodEBPF, known: true},
+		{name: "php", want: "PHP", key: "php", method: MethodEBPF, known: true},
+		{name: "COBOL", want: "COBOL", key: "cobol", method: MethodUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, known := LookupLanguage(tt.name)
+			if l.Name != tt.want || l.Key != tt.key || l.Method != tt.method || known != tt.known {
+				t.Errorf("LookupLanguage(%q) = %s/%s/%s, %v, want %s/%s/%s, %v",
+					tt.name, l.Name, l.Key, l.Method, known, tt.want, tt.key, tt.method, tt.known)
+			}
+		})
+	}
+}
+
+func TestInjectAnnotation(t *testing.T) {
+	for name, want := range map[string]string{
+		"java":  "instrumentation.opentelemetry.io/inject-java",
+		"node":  "instrumentation.opentelemetry.io/inject-nodejs",
+		"ruby":  "",
+		"cobol": "",
+	} {
+		if got := LanguageFor(name).InjectAnnotation(); got != want {
+			t.Errorf("LanguageFor(%q).InjectAnnotation() = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Override
+		err   bool
+	}{
+		{value: "disabled", want: Override{Value: OverrideDisabled}},
+		{value: " Off ", want: Override{Value: OverrideDisabled}},
+		{value: "true", want: Override{Value: OverrideEnabled, Enabled: true}},
+		{value: "Python", want: Override{Value: "python", Enabled: true, Language: "Python"}},
+		{value: "golang", want: Override{Value: "go", Enabled: true, Language: "Go"}},
+		{value: "sometimes", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOverride(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseOverride(%q) = %+v, %v, want %+v, error %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
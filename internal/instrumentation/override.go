@@ -0,0 +1,40 @@
+package instrumentation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OverrideAnnotation lets app teams control auto instrumentation of a workload, on the workload
+// or its pod template: disabled, enabled or a language such as java, see LookupLanguage.
+const OverrideAnnotation = "kloudmate.io/instrumentation"
+
+const (
+	OverrideDisabled = "disabled"
+	OverrideEnabled  = "enabled"
+)
+
+// Override is a parsed OverrideAnnotation.
+type Override struct {
+	// Value is the normalized annotation value reported to the control plane
+	Value   string
+	Enabled bool
+	// Language replaces the detected language when set, spelled as the detector reports it
+	Language string
+}
+
+// ParseOverride parses an OverrideAnnotation value.
+func ParseOverride(value string) (Override, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	switch v {
+	case OverrideDisabled, "false", "off":
+		return Override{Value: OverrideDisabled}, nil
+	case OverrideEnabled, "true", "on":
+		return Override{Value: OverrideEnabled, Enabled: true}, nil
+	}
+	lang, ok := LookupLanguage(v)
+	if !ok {
+		return Override{}, fmt.Errorf("invalid %s value %q", OverrideAnnotation, value)
+	}
+	return Override{Value: lang.Key, Enabled: true, Language: lang.Name}, nil
+}
@@ -0,0 +1,173 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+const metadataTimeout = time.Second
+
+// Cloud is the instance metadata of the cloud provider the host runs on.
+type Cloud struct {
+	Provider     string `json:"provider"`
+	Platform     string `json:"platform"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	AccountID    string `json:"account_id,omitempty"`
+	InstanceID   string `json:"instance_id,omitempty"`
+	InstanceType string `json:"instance_type,omitempty"`
+}
+
+// cloudDetector queries the AWS, GCP and Azure instance metadata services in parallel, the
+// same endpoints the resourcedetection processor uses. Metadata is link-local, so proxies are bypassed.
+type cloudDetector struct {
+	client   *http.Client
+	endpoint string
+}
+
+func newCloudDetector() *cloudDetector {
+	return &cloudDetector{
+		client:   &http.Client{Timeout: metadataTimeout, Transport: &http.Transport{Proxy: nil}},
+		endpoint: "http://169.254.169.254",
+	}
+}
+
+func (d *cloudDetector) detect(ctx context.Context) *Cloud {
+	ctx, cancel := context.WithTimeout(ctx, 2*metadataTimeout)
+	defer cancel()
+
+	detectors := []func(context.Context) (*Cloud, error){d.aws, d.gcp, d.azure}
+	results := make(chan *Cloud, len(detectors))
+	for _, fn := range detectors {
+		go func() {
+			c, err := fn(ctx)
+			if err != nil {
+				c = nil
+			}
+			results <- c
+		}()
+	}
+	for range detectors {
+		if c := <-results; c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *cloudDetector) aws(ctx context.Context) (*Cloud, error) {
+	// IMDSv2 requires a session token, IMDSv1 only instances ignore the header
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, d.endpoint+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, _ := d.fetch(req)
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+"/latest/dynamic/instance-identity/document", nil)
+	if token != nil {
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	}
+	body, err := d.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		AccountID        string `json:"accountId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.InstanceID == "" {
+		return nil, fmt.Errorf("invalid instance identity document")
+	}
+	return &Cloud{
+		Provider:     "aws",
+		Platform:     "aws_ec2",
+		Region:       doc.Region,
+		Zone:         doc.AvailabilityZone,
+		AccountID:    doc.AccountID,
+		InstanceID:   doc.InstanceID,
+		InstanceType: doc.InstanceType,
+	}, nil
+}
+
+func (d *cloudDetector) gcp(ctx context.Context) (*Cloud, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+"/computeMetadata/v1/?recursive=true", nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, err := d.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Instance struct {
+			ID          json.Number `json:"id"`
+			MachineType string      `json:"machineType"`
+			Zone        string      `json:"zone"`
+		} `json:"instance"`
+		Project struct {
+			ProjectID string `json:"projectId"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.Instance.ID == "" {
+		return nil, fmt.Errorf("invalid gcp metadata")
+	}
+	// zone and machine type are resource paths like projects/123/zones/us-central1-a
+	zone := path.Base(doc.Instance.Zone)
+	region := zone
+	if i := len(zone) - 2; i > 0 && zone[i] == '-' {
+		region = zone[:i]
+	}
+	return &Cloud{
+		Provider:     "gcp",
+		Platform:     "gcp_compute_engine",
+		Region:       region,
+		Zone:         zone,
+		AccountID:    doc.Project.ProjectID,
+		InstanceID:   doc.Instance.ID.String(),
+		InstanceType: path.Base(doc.Instance.MachineType),
+	}, nil
+}
+
+func (d *cloudDetector) azure(ctx context.Context) (*Cloud, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+"/metadata/instance/compute?api-version=2021-12-13&format=json", nil)
+	req.Header.Set("Metadata", "true")
+	body, err := d.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Location       string `json:"location"`
+		Zone           string `json:"zone"`
+		SubscriptionID string `json:"subscriptionId"`
+		VMID           string `json:"vmId"`
+		VMSize         string `json:"vmSize"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.VMID == "" {
+		return nil, fmt.Errorf("invalid azure metadata")
+	}
+	return &Cloud{
+		Provider:     "azure",
+		Platform:     "azure_vm",
+		Region:       doc.Location,
+		Zone:         doc.Zone,
+		AccountID:    doc.SubscriptionID,
+		InstanceID:   doc.VMID,
+		InstanceType: doc.VMSize,
+	}, nil
+}
+
+func (d *cloudDetector) fetch(req *http.Request) ([]byte, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata endpoint returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Container describes whether the agent runs in a container and which runtimes are present on the host.
+type Container struct {
+	Containerized bool     `json:"containerized"`
+	Runtimes      []string `json:"runtimes,omitempty"`
+}
+
+var runtimeSockets = map[string][]string{
+	"docker":     {"/var/run/docker.sock", `\\.\pipe\docker_engine`},
+	"containerd": {"/run/containerd/containerd.sock", `\\.\pipe\containerd-containerd`},
+	"podman":     {"/run/podman/podman.sock"},
+	"cri-o":      {"/var/run/crio/crio.sock"},
+}
+
+func detectContainer() Container {
+	c := Container{Containerized: containerized()}
+	for _, name := range []string{"docker", "containerd", "podman", "cri-o"} {
+		for _, sock := range runtimeSockets[name] {
+			if _, err := os.Stat(sock); err == nil {
+				c.Runtimes = append(c.Runtimes, name)
+				break
+			}
+		}
+	}
+	return c
+}
+
+func containerized() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, hint := range []string{"docker", "kubepods", "containerd", "libpod"} {
+		if strings.Contains(string(cgroup), hint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+// Package inventory collects host facts sent with config checks so the server can target configs per host class.
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/discovery"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
+	"go.uber.org/zap"
+)
+
+// DefaultRefreshInterval is how often the inventory is collected when no interval is configured.
+const DefaultRefreshInterval = 15 * time.Minute
+
+// maxListeners caps the number of listening sockets reported.
+const maxListeners = 200
+
+// collectTimeout bounds a single collection, slow probes only delay the next refresh.
+const collectTimeout = time.Minute
+
+// Inventory is the host inventory sent with the config check.
+type Inventory struct {
+	OS        OS         `json:"os"`
+	CPU       CPU        `json:"cpu"`
+	Memory    Memory     `json:"memory"`
+	Cloud     *Cloud     `json:"cloud,omitempty"`
+	Container Container  `json:"container"`
+	Listeners []Listener `json:"listeners,omitempty"`
+	// Integrations are the receivers discovery proposes for the local services
+	Integrations []discovery.Candidate `json:"integrations,omitempty"`
+	CollectedAt  time.Time             `json:"collected_at"`
+}
+
+type OS struct {
+	Name           string `json:"name"`
+	Family         string `json:"family"`
+	Version        string `json:"version"`
+	Kernel         string `json:"kernel"`
+	KernelArch     string `json:"kernel_arch"`
+	HostID         string `json:"host_id,omitempty"`
+	Virtualization string `json:"virtualization,omitempty"`
+}
+
+type CPU struct {
+	LogicalCount  int    `json:"logical_count"`
+	PhysicalCount int    `json:"physical_count"`
+	Model         string `json:"model,omitempty"`
+}
+
+type Memory struct {
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// Listener is a local process accepting connections on a TCP port.
+type Listener struct {
+	Process string `json:"process"`
+	Address string `json:"address"`
+	Port    uint32 `json:"port"`
+	// Exe is the base name of the process executable, used when the process name is unavailable
+	Exe string `json:"exe,omitempty"`
+}
+
+// Collector caches the inventory and refreshes it in the background on a slower cadence than
+// the config check, so config checks never wait for collection.
+type Collector struct {
+	logger   *zap.SugaredLogger
+	interval time.Duration
+	cloud    *cloudDetector
+
+	mu      sync.Mutex
+	current *Inventory
+}
+
+// NewCollector returns a collector refreshing at most once per interval.
+func NewCollector(logger *zap.SugaredLogger, interval time.Duration) *Collector {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return &Collector{
+		logger:   logger,
+		interval: interval,
+		cloud:    newCloudDetector(),
+	}
+}
+
+// Run collects the inventory right away and then once per interval until ctx is done.
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		inv := c.Collect(ctx)
+		c.mu.Lock()
+		c.current = inv
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get returns the cached inventory, nil until the first collection has finished.
+func (c *Collector) Get() *Inventory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// Collect gathers a fresh inventory without touching the cache.
+func (c *Collector) Collect(ctx context.Context) *Inventory {
+	ctx, cancel := context.WithTimeout(ctx, collectTimeout)
+	defer cancel()
+	return c.collect(ctx)
+}
+
+func (c *Collector) collect(ctx context.Context) *Inventory {
+	inv := &Inventory{CollectedAt: time.Now().UTC()}
+
+	if info, err := host.InfoWithContext(ctx); err == nil {
+		inv.OS = OS{
+			Name:           info.Platform,
+			Family:         info.PlatformFamily,
+			Version:        info.PlatformVersion,
+			Kernel:         info.KernelVersion,
+			KernelArch:     info.KernelArch,
+			HostID:         info.HostID,
+			Virtualization: info.VirtualizationSystem,
+		}
+		if inv.OS.Name == "" {
+			inv.OS.Name = info.OS
+		}
+	} else {
+		c.logger.Debugw("failed to collect host info", "error", err)
+	}
+
+	if n, err := cpu.CountsWithContext(ctx, true); err == nil {
+		inv.CPU.LogicalCount = n
+	}
+	if n, err := cpu.CountsWithContext(ctx, false); err == nil {
+		inv.CPU.PhysicalCount = n
+	}
+	if infos, err := cpu.InfoWithContext(ctx); err == nil && len(infos) > 0 {
+		inv.CPU.Model = infos[0].ModelName
+	}
+	if vm, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		inv.Memory.TotalBytes = vm.Total
+	}
+
+	inv.Cloud = c.cloud.detect(ctx)
+	inv.Container = detectContainer()
+	inv.Listeners = listeners(ctx)
+	services := make([]discovery.Service, len(inv.Listeners))
+	for i, l := range inv.Listeners {
+		services[i] = discovery.Service{Process: l.Process, Exe: l.Exe, Address: l.Address, Port: l.Port}
+	}
+	inv.Integrations = discovery.Discover(services)
+	return inv
+}
+
+// listeners returns the TCP sockets in LISTEN state with the owning process name.
+func listeners(ctx context.Context) []Listener {
+	conns, err := net.ConnectionsWithContext(ctx, "tcp")
+	if err != nil {
+		return nil
+	}
+	type owner struct{ name, exe string }
+	owners := map[int32]owner{}
+	seen := map[Listener]bool{}
+	var out []Listener
+	for _, conn := range conns {
+		if conn.Status != "LISTEN" {
+			continue
+		}
+		o, ok := owners[conn.Pid]
+		if !ok && conn.Pid > 0 {
+			if p, err := process.NewProcessWithContext(ctx, conn.Pid); err == nil {
+				o.name, _ = p.NameWithContext(ctx)
+				if exe, err := p.ExeWithContext(ctx); err == nil && exe != "" {
+					o.exe = filepath.Base(exe)
+				}
+			}
+			owners[conn.Pid] = o
+		}
+		l := Listener{Process: o.name, Exe: o.exe, Address: conn.Laddr.IP, Port: conn.Laddr.Port}
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Port != out[j].Port {
+			return out[i].Port < out[j].Port
+		}
+		return out[i].Address < out[j].Address
+	})
+	if len(out) > maxListeners {
+		out = out[:maxListeners]
+	}
+	return out
+}
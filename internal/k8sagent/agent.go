@@ -3,34 +3,45 @@ package k8sagent
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"context"
 
+	"github.com/kloudmate/km-agent/internal/config"
 	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/secrets"
 	"github.com/kloudmate/km-agent/internal/version"
 	"go.opentelemetry.io/collector/otelcol"
 	"go.uber.org/zap"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 // K8sConfig holds all configuration values from environment variables
 type K8sConfig struct {
 	APIKey              string `env:"KM_API_KEY"`
+	APIKeyFile          string `env:"KM_API_KEY_FILE"`
+	SecretsDir          string `env:"KM_SECRETS_DIR"`
 	CollectorEndpoint   string `env:"KM_COLLECTOR_ENDPOINT"`
 	ConfigCheckInterval string `env:"KM_CONFIG_CHECK_INTERVAL"`
 	DeploymentMode      string `env:"DEPLOYMENT_MODE"`
 	ConfigMapName       string `env:"CONFIGMAP_NAME"`
 	PodNamespace        string `env:"POD_NAMESPACE"`
+	ClusterName         string `env:"KM_CLUSTER_NAME"`
+	ShipAgentLogs       bool   `env:"KM_SHIP_AGENT_LOGS"`
+	// Kubeconfig is used instead of the in-cluster service account, KUBECONFIG is honoured as well
+	Kubeconfig string `env:"KM_KUBECONFIG"`
+	// Network holds the proxy and TLS settings for shipping the agent's logs, from the KM_* variables
+	Network config.NetworkConfig
 }
 
 type K8sAgent struct {
 	Cfg       *K8sConfig
 	Logger    *zap.SugaredLogger
 	Collector *otelcol.Collector
-	K8sClient *kubernetes.Clientset
+	K8sClient kubernetes.Interface
 
 	collectorMu     sync.Mutex
 	wg              sync.WaitGroup
@@ -38,6 +49,7 @@ type K8sAgent struct {
 	collectorCancel context.CancelFunc
 	stopCh          chan struct{}
 	AgentInfo       AgentInfo
+	logOTLP         *kmlogger.OTLPCore
 }
 
 type AgentInfo struct {
@@ -48,24 +60,31 @@ type AgentInfo struct {
 
 func NewK8sAgent(info *AgentInfo) (*K8sAgent, error) {
 	zapCfg := zap.NewProductionConfig()
-	zapCfg.Level = zap.NewAtomicLevelAt(kmlogger.ParseLogLevel())
+	zapCfg.Level = kmlogger.Level()
 	zapLogger, err := zapCfg.Build()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
-	logger := zapLogger.Sugar()
-
 	cfg := NewK8sConfig()
+	if err := config.LoadSecrets(cfg.APIKey, cfg.APIKeyFile, cfg.SecretsDir); err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %w", err)
+	}
 
-	kubecfg, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	var logOTLP *kmlogger.OTLPCore
+	if cfg.ShipAgentLogs {
+		if logOTLP, err = newOTLPCore(cfg, info); err != nil {
+			zapLogger.Warn("agent logs will not be shipped", zap.Error(err))
+		} else {
+			zapLogger = kmlogger.WithOTLP(zapLogger, logOTLP)
+		}
 	}
-	logger.Info("loaded in-cluster kubernetes config")
+	logger := zapLogger.Sugar()
 
-	k8sClient, err := kubernetes.NewForConfig(kubecfg)
+	k8sClient, err := config.NewKubeClient(cfg.Kubeconfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		logger.Errorw("failed to create kubernetes client", "error", err)
+		closeLogs(logOTLP)
+		return nil, err
 	}
 
 	agent := &K8sAgent{
@@ -74,6 +93,7 @@ func NewK8sAgent(info *AgentInfo) (*K8sAgent, error) {
 		K8sClient: k8sClient,
 		AgentInfo: *info,
 		stopCh:    make(chan struct{}),
+		logOTLP:   logOTLP,
 	}
 	agent.AgentInfo.setEnvForAgentVersion()
 	agent.AgentInfo.CollectorVersion = version.GetCollectorVersion()
@@ -86,6 +106,42 @@ func NewK8sAgent(info *AgentInfo) (*K8sAgent, error) {
 	return agent, nil
 }
 
+// newOTLPCore exports the agent's logs to the collector endpoint through the configured proxy and TLS settings.
+func newOTLPCore(cfg *K8sConfig, info *AgentInfo) (*kmlogger.OTLPCore, error) {
+	transport, err := cfg.Network.NewTransport()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return kmlogger.NewOTLPCore(kmlogger.OTLPConfig{
+		Endpoint: cfg.CollectorEndpoint,
+		APIKey: func() string {
+			if v, ok := secrets.Default().Get(secrets.APIKey); ok {
+				return v
+			}
+			return cfg.APIKey
+		},
+		Transport: transport,
+		Resource: map[string]string{
+			"host.name":          hostname,
+			"service.name":       "km-kube-agent",
+			"service.version":    info.Version,
+			"k8s.cluster.name":   cfg.ClusterName,
+			"k8s.namespace.name": cfg.PodNamespace,
+		},
+	})
+}
+
+// closeLogs flushes the shipped agent logs, if any.
+func closeLogs(core *kmlogger.OTLPCore) {
+	if core == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	core.Close(ctx)
+}
+
 // StartAgent creates an otel config from agent config and then runs the agent.
 func (km *K8sAgent) StartAgent(ctx context.Context) error {
 	km.Logger.Infow("starting kubernetes agent",
@@ -93,6 +149,17 @@ func (km *K8sAgent) StartAgent(ctx context.Context) error {
 		"commitSHA", km.AgentInfo.CommitSHA,
 		"collectorVersion", km.AgentInfo.CollectorVersion,
 	)
+	kmlogger.WatchLevelSignals(ctx, km.Logger)
+	if km.Cfg.SecretsDir != "" {
+		if err := secrets.Default().WatchDir(ctx, km.Cfg.SecretsDir, km.Logger); err != nil {
+			return err
+		}
+	}
+	if km.Cfg.APIKeyFile != "" {
+		if err := secrets.Default().WatchFile(ctx, secrets.APIKey, km.Cfg.APIKeyFile, km.Logger); err != nil {
+			return err
+		}
+	}
 	return km.Start(ctx)
 }
 
@@ -112,6 +179,12 @@ func (a *K8sAgent) Stop() {
 	a.wg.Wait()
 	a.stopInternalCollector()
 	a.Logger.Info("collector agent stopped")
+	a.FlushLogs()
+}
+
+// FlushLogs sends the buffered agent logs before the process exits, e.g. after a failed start.
+func (a *K8sAgent) FlushLogs() {
+	closeLogs(a.logOTLP)
 }
 
 func (a *K8sAgent) Stopch() {
@@ -126,11 +199,17 @@ func NewK8sConfig() *K8sConfig {
 	config := &K8sConfig{
 		ConfigCheckInterval: os.Getenv("KM_CONFIG_CHECK_INTERVAL"),
 		APIKey:              os.Getenv("KM_API_KEY"),
+		APIKeyFile:          os.Getenv("KM_API_KEY_FILE"),
+		SecretsDir:          os.Getenv("KM_SECRETS_DIR"),
 		CollectorEndpoint:   os.Getenv("KM_COLLECTOR_ENDPOINT"),
 		ConfigMapName:       os.Getenv("CONFIGMAP_NAME"),
 		DeploymentMode:      os.Getenv("DEPLOYMENT_MODE"),
 		PodNamespace:        os.Getenv("POD_NAMESPACE"),
+		ClusterName:         os.Getenv("KM_CLUSTER_NAME"),
+		Kubeconfig:          os.Getenv("KM_KUBECONFIG"),
+		Network:             config.NetworkFromEnv(),
 	}
+	config.ShipAgentLogs, _ = strconv.ParseBool(os.Getenv("KM_SHIP_AGENT_LOGS"))
 
 	if strings.ToUpper(config.DeploymentMode) == "DAEMONSET" {
 		config.DeploymentMode = "DAEMONSET"
@@ -142,8 +221,8 @@ func NewK8sConfig() *K8sConfig {
 }
 
 func (c *K8sConfig) Validate() error {
-	if c.APIKey == "" {
-		return fmt.Errorf("KM_API_KEY is required")
+	if c.APIKey == "" && c.APIKeyFile == "" {
+		return fmt.Errorf("KM_API_KEY or KM_API_KEY_FILE is required")
 	}
 	if c.CollectorEndpoint == "" {
 		return fmt.Errorf("KM_COLLECTOR_ENDPOINT is required")
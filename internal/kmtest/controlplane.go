@@ -0,0 +1,178 @@
+// Package kmtest provides fakes of the KloudMate endpoints the agents talk to, a programmable
+// config check API and an OTLP/HTTP sink, for end-to-end tests running entirely on loopback.
+package kmtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ConfigCheckPath is where the fake control plane serves config checks.
+const ConfigCheckPath = "/agents/config-check"
+
+// Request is a config check received by the fake control plane.
+type Request struct {
+	Header http.Header
+	// Body is the decoded JSON payload, e.g. Body["agent_status"]
+	Body map[string]any
+	Raw  []byte
+}
+
+// Status returns a string field of the payload, empty when absent.
+func (r Request) Status(field string) string {
+	s, _ := r.Body[field].(string)
+	return s
+}
+
+// ControlPlane is a fake config check API. Responses are served from a queue of one-shot
+// responses first, then the default response.
+type ControlPlane struct {
+	t      testing.TB
+	server *httptest.Server
+
+	mu       sync.Mutex
+	apiKey   string
+	status   int
+	fallback any
+	queue    []any
+	requests []Request
+	received chan struct{}
+}
+
+// NewControlPlane starts a fake control plane answering every check with an empty response
+// until told otherwise. It is closed when the test ends.
+func NewControlPlane(t testing.TB) *ControlPlane {
+	t.Helper()
+	cp := &ControlPlane{
+		t:        t,
+		status:   http.StatusOK,
+		fallback: map[string]any{"restart_required": false},
+		received: make(chan struct{}, 1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(ConfigCheckPath, cp.serveConfigCheck)
+	cp.server = httptest.NewServer(mux)
+	t.Cleanup(cp.server.Close)
+	return cp
+}
+
+// URL returns the config check URL to configure the agent with.
+func (cp *ControlPlane) URL() string {
+	return cp.server.URL + ConfigCheckPath
+}
+
+// RequireAPIKey makes the fake answer 401 to checks without this Authorization header.
+func (cp *ControlPlane) RequireAPIKey(key string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.apiKey = key
+}
+
+// SetStatus makes the fake answer with an HTTP status instead of a response, OK restores normal operation.
+func (cp *ControlPlane) SetStatus(code int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.status = code
+}
+
+// Respond sets the response served once the queue is empty, e.g. an updater.ConfigUpdateResponse.
+func (cp *ControlPlane) Respond(resp any) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.fallback = resp
+}
+
+// Enqueue adds responses that are each served to exactly one check.
+func (cp *ControlPlane) Enqueue(resps ...any) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.queue = append(cp.queue, resps...)
+}
+
+// Requests returns the checks received so far.
+func (cp *ControlPlane) Requests() []Request {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]Request(nil), cp.requests...)
+}
+
+// WaitForRequest blocks until a check matching match arrives, including checks received earlier,
+// and fails the test after timeout. A nil match accepts any check.
+func (cp *ControlPlane) WaitForRequest(timeout time.Duration, match func(Request) bool) Request {
+	cp.t.Helper()
+	return cp.waitFrom(0, timeout, match)
+}
+
+// WaitForNewRequest is like WaitForRequest but only considers checks received after the call.
+func (cp *ControlPlane) WaitForNewRequest(timeout time.Duration, match func(Request) bool) Request {
+	cp.t.Helper()
+	return cp.waitFrom(len(cp.Requests()), timeout, match)
+}
+
+func (cp *ControlPlane) waitFrom(seen int, timeout time.Duration, match func(Request) bool) Request {
+	cp.t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		reqs := cp.Requests()
+		for ; seen < len(reqs); seen++ {
+			if match == nil || match(reqs[seen]) {
+				return reqs[seen]
+			}
+		}
+		select {
+		case <-cp.received:
+		case <-deadline.C:
+			cp.t.Fatalf("no matching config check within %s, received %d", timeout, len(reqs))
+			return Request{}
+		}
+	}
+}
+
+func (cp *ControlPlane) serveConfigCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := Request{Header: r.Header.Clone(), Raw: raw}
+	if err := json.Unmarshal(raw, &req.Body); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cp.mu.Lock()
+	cp.requests = append(cp.requests, req)
+	apiKey, status := cp.apiKey, cp.status
+	var resp any
+	switch {
+	case apiKey != "" && r.Header.Get("Authorization") != apiKey:
+		status = http.StatusUnauthorized
+	case status == http.StatusOK && len(cp.queue) > 0:
+		resp, cp.queue = cp.queue[0], cp.queue[1:]
+	default:
+		resp = cp.fallback
+	}
+	cp.mu.Unlock()
+
+	select {
+	case cp.received <- struct{}{}:
+	default:
+	}
+
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
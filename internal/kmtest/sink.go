@@ -0,0 +1,194 @@
+package kmtest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+)
+
+// OTLPSink is an OTLP/HTTP endpoint recording everything exported to it, protobuf or JSON encoded
+// and optionally gzip compressed.
+type OTLPSink struct {
+	t      testing.TB
+	server *httptest.Server
+
+	mu      sync.Mutex
+	spans   int
+	points  int
+	records int
+	headers []http.Header
+}
+
+// NewOTLPSink starts a sink that is closed when the test ends.
+func NewOTLPSink(t testing.TB) *OTLPSink {
+	t.Helper()
+	s := &OTLPSink{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", s.handle(func(body []byte, json bool) error {
+		req := ptraceotlp.NewExportRequest()
+		if err := unmarshal(req.UnmarshalProto, req.UnmarshalJSON, body, json); err != nil {
+			return err
+		}
+		s.spans += req.Traces().SpanCount()
+		return nil
+	}))
+	mux.HandleFunc("/v1/metrics", s.handle(func(body []byte, json bool) error {
+		req := pmetricotlp.NewExportRequest()
+		if err := unmarshal(req.UnmarshalProto, req.UnmarshalJSON, body, json); err != nil {
+			return err
+		}
+		s.points += req.Metrics().DataPointCount()
+		return nil
+	}))
+	mux.HandleFunc("/v1/logs", s.handle(func(body []byte, json bool) error {
+		req := plogotlp.NewExportRequest()
+		if err := unmarshal(req.UnmarshalProto, req.UnmarshalJSON, body, json); err != nil {
+			return err
+		}
+		s.records += req.Logs().LogRecordCount()
+		return nil
+	}))
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Endpoint returns the base URL to use as collector endpoint, signals are posted to /v1/<signal>.
+func (s *OTLPSink) Endpoint() string {
+	return s.server.URL
+}
+
+// Spans, DataPoints and LogRecords return the number of items received so far.
+func (s *OTLPSink) Spans() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spans
+}
+
+func (s *OTLPSink) DataPoints() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.points
+}
+
+func (s *OTLPSink) LogRecords() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// Headers returns the headers of every export request received.
+func (s *OTLPSink) Headers() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
+
+// WaitFor polls cond until it holds and fails the test after timeout.
+func (s *OTLPSink) WaitFor(timeout time.Duration, what string, cond func() bool) {
+	s.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("sink did not receive %s within %s", what, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s *OTLPSink) handle(record func(body []byte, json bool) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			reader = gz
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json := r.Header.Get("Content-Type") == "application/json"
+		s.mu.Lock()
+		s.headers = append(s.headers, r.Header.Clone())
+		err = record(body, json)
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if json {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func unmarshal(proto, json func([]byte) error, body []byte, isJSON bool) error {
+	if isJSON {
+		return json(body)
+	}
+	return proto(body)
+}
+
+// ExportSpans posts n test spans to an OTLP/HTTP endpoint such as a collector's otlp receiver.
+func ExportSpans(endpoint string, n int) error {
+	traces := ptrace.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		span := spans.AppendEmpty()
+		span.SetName("kmtest")
+		span.SetTraceID(pcommon.TraceID{1, byte(i + 1)})
+		span.SetSpanID(pcommon.SpanID{1, byte(i + 1)})
+		span.SetStartTimestamp(pcommon.NewTimestampFromTime(now.Add(-time.Millisecond)))
+		span.SetEndTimestamp(pcommon.NewTimestampFromTime(now))
+	}
+	body, err := ptraceotlp.NewExportRequestFromTraces(traces).MarshalProto()
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(strings.TrimSuffix(endpoint, "/")+"/v1/traces", "application/x-protobuf", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FreeAddr returns a loopback address with a currently unused port, for collector receivers.
+func FreeAddr(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve a port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// CollectorLoggingOptions gates the collector's internal logs on the process wide level and
+// writes them to the agent's log sink when one was set up with NewSinkLogger.
+func CollectorLoggingOptions() []zap.Option {
+	return []zap.Option{zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if sink := collectorCore.Load(); sink != nil {
+			c = (*sink).With([]zapcore.Field{zap.String("component", "collector")})
+		}
+		return levelCore{Core: c}
+	})}
+}
+
+// NewCollectorLevelConverterFactory lowers the collector's own telemetry log level to debug so
+// that the process wide level, not the collector config, decides what is logged.
+func NewCollectorLevelConverterFactory() confmap.ConverterFactory {
+	return confmap.NewConverterFactory(func(confmap.ConverterSettings) confmap.Converter {
+		return levelConverter{}
+	})
+}
+
+type levelConverter struct{}
+
+func (levelConverter) Convert(_ context.Context, conf *confmap.Conf) error {
+	return conf.Merge(confmap.NewFromStringMap(map[string]any{
+		"service": map[string]any{"telemetry": map[string]any{"logs": map[string]any{"level": "debug"}}},
+	}))
+}
+
+// levelCore drops entries below the process wide level before they reach the wrapped core.
+type levelCore struct {
+	zapcore.Core
+}
+
+func (c levelCore) Enabled(l zapcore.Level) bool {
+	return level.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !level.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{Core: c.Core.With(fields)}
+}
@@ -1,12 +1,20 @@
 package logger
 
 import (
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// level is shared by every logger of the process, including the embedded collector's,
+// so a change through SetLevel, the control API or a signal applies everywhere at once.
+var level = zap.NewAtomicLevelAt(ParseLogLevel())
+
 // ParseLogLevel reads the KM_LOG_LEVEL environment variable and returns the
 // corresponding zapcore.Level. Supported values: debug, info, warn, error.
 // @amitava82: here it defaults to InfoLevel if unset or unrecognized.
@@ -22,3 +30,48 @@ func ParseLogLevel() zapcore.Level {
 		return zapcore.InfoLevel
 	}
 }
+
+// Level returns the process wide log level, to be used as the level of every zap logger built.
+func Level() zap.AtomicLevel {
+	return level
+}
+
+// SetLevel changes the process wide log level, accepting debug, info, warn or error.
+func SetLevel(s string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", s, err)
+	}
+	level.SetLevel(l)
+	return nil
+}
+
+// ResetLevel restores the level configured through KM_LOG_LEVEL.
+func ResetLevel() {
+	level.SetLevel(ParseLogLevel())
+}
+
+// LevelHandler serves the current level on GET and changes it on PUT with a body like {"level":"debug"}.
+func LevelHandler() http.Handler {
+	return level
+}
+
+var (
+	remoteMu    sync.Mutex
+	remoteLevel string
+)
+
+// ApplyRemoteLevel applies the level sent by the control plane. It only acts when the remote value
+// changes, so a level set locally while debugging is not reverted by the next config check.
+func ApplyRemoteLevel(s string) (bool, error) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if s == "" || s == remoteLevel {
+		return false, nil
+	}
+	if err := SetLevel(s); err != nil {
+		return false, err
+	}
+	remoteLevel = s
+	return true, nil
+}
@@ -0,0 +1,291 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultOTLPBufferSize    = 1000
+	defaultOTLPFlushInterval = 5 * time.Second
+	otlpBatchSize            = 512
+)
+
+// OTLPConfig configures shipping the agent's own logs to KloudMate as OTLP logs.
+type OTLPConfig struct {
+	// Endpoint is the OTLP/HTTP endpoint the collector exports to, /v1/logs is appended
+	Endpoint string
+	// APIKey returns the current API key, sent as the Authorization header
+	APIKey func() string
+	// Resource attributes identifying the agent, e.g. host.name and service.version
+	Resource map[string]string
+	// Transport carries the proxy and TLS settings, nil uses the default transport
+	Transport http.RoundTripper
+	// BufferSize bounds the records waiting to be sent. Records logged while it is full are dropped
+	// and reported as a count with the next batch.
+	BufferSize    int
+	FlushInterval time.Duration
+}
+
+// OTLPCore is a zap core exporting entries as OTLP log records in the background. Export
+// failures are not logged, which would feed back into the core; the affected records are
+// counted as dropped instead.
+type OTLPCore struct {
+	*otlpExporter
+	fields []zapcore.Field
+}
+
+type otlpRecord struct {
+	entry zapcore.Entry
+	attrs map[string]any
+}
+
+type otlpExporter struct {
+	cfg     OTLPConfig
+	url     string
+	client  *http.Client
+	records chan otlpRecord
+	dropped atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewOTLPCore starts exporting entries logged through the returned core at the process wide level.
+// Close flushes the buffered records.
+func NewOTLPCore(cfg OTLPConfig) (*OTLPCore, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("no OTLP endpoint configured for agent logs")
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultOTLPBufferSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultOTLPFlushInterval
+	}
+	e := &otlpExporter{
+		cfg:     cfg,
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/logs",
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: cfg.Transport},
+		records: make(chan otlpRecord, cfg.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return &OTLPCore{otlpExporter: e}, nil
+}
+
+func (c *OTLPCore) Enabled(l zapcore.Level) bool {
+	return level.Enabled(l)
+}
+
+func (c *OTLPCore) With(fields []zapcore.Field) zapcore.Core {
+	return &OTLPCore{otlpExporter: c.otlpExporter, fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+func (c *OTLPCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *OTLPCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	select {
+	case c.records <- otlpRecord{entry: e, attrs: enc.Fields}:
+	default:
+		c.dropped.Add(1)
+	}
+	return nil
+}
+
+func (c *OTLPCore) Sync() error {
+	return nil
+}
+
+// Close stops the exporter after sending the buffered records, or when ctx is done.
+func (c *OTLPCore) Close(ctx context.Context) error {
+	c.once.Do(func() { close(c.stop) })
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]otlpRecord, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) > 0 || e.dropped.Load() > 0 {
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case r := <-e.records:
+			batch = append(batch, r)
+			if len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case r := <-e.records:
+					batch = append(batch, r)
+					if len(batch) >= otlpBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) send(batch []otlpRecord) {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	for k, v := range e.cfg.Resource {
+		rl.Resource().Attributes().PutStr(k, v)
+	}
+	sl := rl.ScopeLogs().AppendEmpty()
+	sl.Scope().SetName("github.com/kloudmate/km-agent/internal/logger")
+
+	dropped := e.dropped.Swap(0)
+	if dropped > 0 {
+		lr := sl.LogRecords().AppendEmpty()
+		lr.SetTimestamp(pcommon.NewTimestampFromTime(time.Now()))
+		lr.SetSeverityNumber(plog.SeverityNumberWarn)
+		lr.SetSeverityText("warn")
+		lr.Body().SetStr("agent log records were dropped")
+		lr.Attributes().PutInt("dropped_records", dropped)
+	}
+	for _, r := range batch {
+		toLogRecord(r, sl.LogRecords().AppendEmpty())
+	}
+
+	body, err := plogotlp.NewExportRequestFromLogs(ld).MarshalProto()
+	if err == nil {
+		err = e.post(body)
+	}
+	if err != nil {
+		e.dropped.Add(int64(len(batch)) + dropped)
+	}
+}
+
+func (e *otlpExporter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if e.cfg.APIKey != nil {
+		if key := e.cfg.APIKey(); key != "" {
+			req.Header.Set("Authorization", key)
+		}
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP logs export returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func toLogRecord(r otlpRecord, lr plog.LogRecord) {
+	lr.SetTimestamp(pcommon.NewTimestampFromTime(r.entry.Time))
+	lr.SetObservedTimestamp(pcommon.NewTimestampFromTime(time.Now()))
+	lr.SetSeverityNumber(severityNumber(r.entry.Level))
+	lr.SetSeverityText(r.entry.Level.String())
+	lr.Body().SetStr(r.entry.Message)
+
+	attrs := lr.Attributes()
+	if r.entry.LoggerName != "" {
+		attrs.PutStr("logger.name", r.entry.LoggerName)
+	}
+	if r.entry.Caller.Defined {
+		attrs.PutStr("code.filepath", r.entry.Caller.File)
+		attrs.PutInt("code.lineno", int64(r.entry.Caller.Line))
+	}
+	if r.entry.Stack != "" {
+		attrs.PutStr("exception.stacktrace", r.entry.Stack)
+	}
+	for k, v := range r.attrs {
+		switch v := v.(type) {
+		case string:
+			attrs.PutStr(k, v)
+		case bool:
+			attrs.PutBool(k, v)
+		case int:
+			attrs.PutInt(k, int64(v))
+		case int32:
+			attrs.PutInt(k, int64(v))
+		case int64:
+			attrs.PutInt(k, v)
+		case uint32:
+			attrs.PutInt(k, int64(v))
+		case float32:
+			attrs.PutDouble(k, float64(v))
+		case float64:
+			attrs.PutDouble(k, v)
+		default:
+			attrs.PutStr(k, fmt.Sprint(v))
+		}
+	}
+}
+
+func severityNumber(l zapcore.Level) plog.SeverityNumber {
+	switch l {
+	case zapcore.DebugLevel:
+		return plog.SeverityNumberDebug
+	case zapcore.InfoLevel:
+		return plog.SeverityNumberInfo
+	case zapcore.WarnLevel:
+		return plog.SeverityNumberWarn
+	case zapcore.ErrorLevel:
+		return plog.SeverityNumberError
+	default:
+		return plog.SeverityNumberFatal
+	}
+}
+
+// WithOTLP returns a logger also writing to the OTLP core.
+func WithOTLP(logger *zap.Logger, core *OTLPCore) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, core)
+	}))
+}
@@ -0,0 +1,165 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.uber.org/zap"
+)
+
+// otlpSink is a local stand-in for the OTLP/HTTP logs endpoint. It keeps the accepted requests
+// and counts all of them.
+type otlpSink struct {
+	mu       sync.Mutex
+	requests []plog.Logs
+	auth     []string
+	status   atomic.Int32
+	attempts atomic.Int32
+}
+
+func newOTLPSink(t *testing.T) (*otlpSink, *httptest.Server) {
+	s := &otlpSink{}
+	s.status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" {
+			t.Errorf("export sent to %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := plogotlp.NewExportRequest()
+		if err := req.UnmarshalProto(body); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+		status := int(s.status.Load())
+		s.attempts.Add(1)
+		if status == http.StatusOK {
+			s.mu.Lock()
+			s.requests = append(s.requests, req.Logs())
+			s.auth = append(s.auth, r.Header.Get("Authorization"))
+			s.mu.Unlock()
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *otlpSink) records() []plog.LogRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []plog.LogRecord
+	for _, ld := range s.requests {
+		sl := ld.ResourceLogs().At(0).ScopeLogs().At(0)
+		for i := 0; i < sl.LogRecords().Len(); i++ {
+			out = append(out, sl.LogRecords().At(i))
+		}
+	}
+	return out
+}
+
+// countingTransport records that exports go through the configured transport.
+type countingTransport struct {
+	calls atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOTLPCoreExports(t *testing.T) {
+	sink, srv := newOTLPSink(t)
+	transport := &countingTransport{}
+	core, err := NewOTLPCore(OTLPConfig{
+		Endpoint:      srv.URL + "/",
+		APIKey:        func() string { return "key-1" },
+		Resource:      map[string]string{"service.name": "kmagent"},
+		Transport:     transport,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core).With(zap.String("component", "updater"))
+	logger.Info("config applied", zap.Int("version", 3), zap.Bool("restarted", true))
+	logger.Debug("hidden at the default level")
+	if err := core.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records := sink.records()
+	if len(records) != 1 {
+		t.Fatalf("exported %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.Body().Str() != "config applied" || r.SeverityNumber() != plog.SeverityNumberInfo {
+		t.Errorf("record = %q at %v", r.Body().Str(), r.SeverityNumber())
+	}
+	attrs := r.Attributes().AsRaw()
+	if attrs["component"] != "updater" || attrs["version"] != int64(3) || attrs["restarted"] != true {
+		t.Errorf("attributes = %v", attrs)
+	}
+	if v, _ := sink.requests[0].ResourceLogs().At(0).Resource().Attributes().Get("service.name"); v.Str() != "kmagent" {
+		t.Errorf("service.name = %q", v.Str())
+	}
+	if sink.auth[0] != "key-1" {
+		t.Errorf("Authorization = %q", sink.auth[0])
+	}
+	if transport.calls.Load() != 1 {
+		t.Errorf("configured transport used %d times, want 1", transport.calls.Load())
+	}
+}
+
+func TestOTLPCoreReportsDropped(t *testing.T) {
+	sink, srv := newOTLPSink(t)
+	sink.status.Store(http.StatusServiceUnavailable)
+	core, err := NewOTLPCore(OTLPConfig{Endpoint: srv.URL, FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	logger.Warn("lost while the endpoint is down")
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.attempts.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no export attempted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sink.status.Store(http.StatusOK)
+	logger.Info("after recovery")
+	if err := core.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lost record is reported as a count once the endpoint accepts exports again
+	var dropped int64
+	var bodies []string
+	for _, r := range sink.records() {
+		if n, ok := r.Attributes().Get("dropped_records"); ok {
+			dropped += n.Int()
+			continue
+		}
+		bodies = append(bodies, r.Body().Str())
+	}
+	if dropped < 1 {
+		t.Errorf("dropped_records = %d, want at least 1", dropped)
+	}
+	if len(bodies) != 1 || bodies[0] != "after recovery" {
+		t.Errorf("exported records = %q, want only the one logged after recovery", bodies)
+	}
+}
+
+func TestNewOTLPCoreNeedsEndpoint(t *testing.T) {
+	if _, err := NewOTLPCore(OTLPConfig{}); err == nil {
+		t.Error("NewOTLPCore() succeeded without an endpoint")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// WatchLevelSignals switches to debug logging on SIGUSR1 and back to the configured level on SIGUSR2.
+func WatchLevelSignals(ctx context.Context, logger *zap.SugaredLogger) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case sig := <-ch:
+				if sig == syscall.SIGUSR1 {
+					level.SetLevel(zapcore.DebugLevel)
+				} else {
+					ResetLevel()
+				}
+				logger.Infow("log level changed by signal", "signal", sig.String(), "level", level.String())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
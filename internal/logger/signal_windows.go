@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// WatchLevelSignals is a no-op on windows, which has no user signals. Use the control API instead.
+func WatchLevelSignals(context.Context, *zap.SugaredLogger) {}
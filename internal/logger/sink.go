@@ -0,0 +1,141 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log sinks selectable with SinkConfig.Sink.
+const (
+	SinkStderr   = "stderr"
+	SinkFile     = "file"
+	SinkJournald = "journald"
+	SinkSyslog   = "syslog"
+	SinkEventLog = "eventlog"
+)
+
+// SinkConfig selects where logs are written and how the file sink is rotated.
+type SinkConfig struct {
+	Sink       string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// collectorCore is the sink core the embedded collector logs to, nil keeps the collector's own output.
+var collectorCore atomic.Pointer[zapcore.Core]
+
+// NewSinkLogger builds a logger writing to the configured sink at the process wide level and
+// routes the embedded collector's own logs to the same sink.
+func NewSinkLogger(cfg SinkConfig) (*KmLogger, error) {
+	sink := strings.ToLower(cfg.Sink)
+	if sink == "" {
+		sink = defaultSink
+	}
+
+	var (
+		core   zapcore.Core
+		closer func()
+		err    error
+	)
+	switch sink {
+	case SinkStderr:
+		core = zapcore.NewCore(newJSONEncoder(true), zapcore.Lock(os.Stderr), level)
+	case SinkFile:
+		var w *lumberjack.Logger
+		if w, err = newFileWriter(cfg); err == nil {
+			core = zapcore.NewCore(newJSONEncoder(true), zapcore.AddSync(w), level)
+			closer = func() { w.Close() }
+		}
+	default:
+		core, closer, err = newPlatformCore(sink)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up %s log sink: %w", sink, err)
+	}
+
+	collectorCore.Store(&core)
+	return &KmLogger{Logger: zap.New(core), closer: closer}, nil
+}
+
+// newFileWriter returns a rotating writer after making sure the log file can be written.
+func newFileWriter(cfg SinkConfig) (*lumberjack.Logger, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("no log file configured")
+	}
+	if err := ensureLogDir(filepath.Dir(cfg.File)); err != nil {
+		return nil, err
+	}
+	// lumberjack opens the file lazily, open it once so permission errors surface at startup
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &lumberjack.Logger{
+		Filename:   cfg.File,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   true,
+	}, nil
+}
+
+// newJSONEncoder returns the agent's JSON encoder. Sinks that stamp entries themselves, like
+// journald and syslog, omit the timestamp.
+func newJSONEncoder(withTime bool) zapcore.Encoder {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if !withTime {
+		encoderConfig.TimeKey = ""
+	}
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
+
+// leveledCore hands each encoded entry to a writer that keeps the level, e.g. as a journald priority.
+type leveledCore struct {
+	enc   zapcore.Encoder
+	write func(l zapcore.Level, msg string) error
+}
+
+func (c *leveledCore) Enabled(l zapcore.Level) bool {
+	return level.Enabled(l)
+}
+
+func (c *leveledCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &leveledCore{enc: enc, write: c.write}
+}
+
+func (c *leveledCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *leveledCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	return c.write(e.Level, strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func (c *leveledCore) Sync() error {
+	return nil
+}
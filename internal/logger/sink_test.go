@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSinkRotates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kmagent", "kmagent.log")
+	l, err := NewSinkLogger(SinkConfig{Sink: SinkFile, File: file, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.MustCleanup()
+
+	line := strings.Repeat("x", 1024)
+	for i := 0; i < 1500; i++ {
+		l.Logger.Info(line)
+	}
+
+	// rotated files are compressed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backups, _ := filepath.Glob(filepath.Join(filepath.Dir(file), "kmagent-*.log.gz"))
+		if len(backups) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backups = %v, want one compressed backup", backups)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 || info.Size() > 1<<20 {
+		t.Errorf("current log file is %d bytes, want a fresh file below the 1 MB limit", info.Size())
+	}
+}
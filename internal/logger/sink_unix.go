@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultSink keeps writing to stderr, which the service manager captures.
+const defaultSink = SinkStderr
+
+// journald and syslog endpoints, replaced in tests
+var (
+	journalEnabled = journal.Enabled
+	journalSend    = journal.Send
+	newSyslog      = func() (*syslog.Writer, error) { return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "kmagent") }
+)
+
+func newPlatformCore(sink string) (zapcore.Core, func(), error) {
+	switch sink {
+	case SinkJournald:
+		if !journalEnabled() {
+			return nil, nil, fmt.Errorf("journald socket is not available")
+		}
+		vars := map[string]string{"SYSLOG_IDENTIFIER": "kmagent"}
+		return &leveledCore{enc: newJSONEncoder(false), write: func(l zapcore.Level, msg string) error {
+			return journalSend(msg, journalPriority(l), vars)
+		}}, nil, nil
+	case SinkSyslog:
+		w, err := newSyslog()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &leveledCore{enc: newJSONEncoder(false), write: func(l zapcore.Level, msg string) error {
+			switch {
+			case l <= zapcore.DebugLevel:
+				return w.Debug(msg)
+			case l == zapcore.InfoLevel:
+				return w.Info(msg)
+			case l == zapcore.WarnLevel:
+				return w.Warning(msg)
+			case l == zapcore.ErrorLevel:
+				return w.Err(msg)
+			default:
+				return w.Crit(msg)
+			}
+		}}, func() { w.Close() }, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported log sink %q", sink)
+}
+
+func journalPriority(l zapcore.Level) journal.Priority {
+	switch {
+	case l <= zapcore.DebugLevel:
+		return journal.PriDebug
+	case l == zapcore.InfoLevel:
+		return journal.PriInfo
+	case l == zapcore.WarnLevel:
+		return journal.PriWarning
+	case l == zapcore.ErrorLevel:
+		return journal.PriErr
+	default:
+		return journal.PriCrit
+	}
+}
+
+// ensureLogDir creates the log directory readable only by the agent's user and group. An existing
+// directory is used as is, its owner and mode are left to the administrator.
+func ensureLogDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("log directory %s is not a directory", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return nil
+}
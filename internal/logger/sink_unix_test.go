@@ -0,0 +1,123 @@
+//go:build !windows
+
+package logger
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+func TestEnsureLogDir(t *testing.T) {
+	existing := t.TempDir()
+	if err := os.Chmod(existing, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureLogDir(existing); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0777 {
+		t.Errorf("existing directory mode changed to %v", info.Mode().Perm())
+	}
+
+	created := filepath.Join(existing, "kmagent")
+	if err := ensureLogDir(created); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(created); info.Mode().Perm()&^0750 != 0 {
+		t.Errorf("created directory mode = %v, want at most 0750", info.Mode().Perm())
+	}
+
+	file := filepath.Join(existing, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureLogDir(file); err == nil {
+		t.Error("ensureLogDir() accepted a regular file")
+	}
+}
+
+func TestJournaldSink(t *testing.T) {
+	type entry struct {
+		msg      string
+		priority journal.Priority
+	}
+	var sent []entry
+	enabled, send := journalEnabled, journalSend
+	defer func() { journalEnabled, journalSend = enabled, send }()
+	journalEnabled = func() bool { return true }
+	journalSend = func(msg string, p journal.Priority, vars map[string]string) error {
+		if vars["SYSLOG_IDENTIFIER"] != "kmagent" {
+			t.Errorf("SYSLOG_IDENTIFIER = %q", vars["SYSLOG_IDENTIFIER"])
+		}
+		sent = append(sent, entry{msg, p})
+		return nil
+	}
+
+	l, err := NewSinkLogger(SinkConfig{Sink: SinkJournald})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Logger.Info("started")
+	l.Logger.Warn("disk almost full")
+	l.Logger.Error("export failed")
+
+	want := []journal.Priority{journal.PriInfo, journal.PriWarning, journal.PriErr}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %d entries, want %d", len(sent), len(want))
+	}
+	for i, e := range sent {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(e.msg), &fields); err != nil {
+			t.Fatalf("entry %q is not JSON: %v", e.msg, err)
+		}
+		if _, ok := fields["timestamp"]; ok {
+			t.Errorf("entry %q has a timestamp, journald stamps entries itself", e.msg)
+		}
+		if e.priority != want[i] {
+			t.Errorf("entry %q priority = %d, want %d", e.msg, e.priority, want[i])
+		}
+	}
+
+	journalEnabled = func() bool { return false }
+	if _, err := NewSinkLogger(SinkConfig{Sink: SinkJournald}); err == nil {
+		t.Error("NewSinkLogger() succeeded without a journald socket")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+	orig := newSyslog
+	defer func() { newSyslog = orig }()
+	newSyslog = func() (*syslog.Writer, error) {
+		return syslog.Dial("unixgram", sock, syslog.LOG_DAEMON|syslog.LOG_INFO, "kmagent")
+	}
+
+	l, err := NewSinkLogger(SinkConfig{Sink: SinkSyslog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.MustCleanup()
+	l.Logger.Warn("disk almost full")
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// daemon facility (3) * 8 + warning severity (4)
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<28>") || !strings.Contains(got, "kmagent") || !strings.Contains(got, `"msg":"disk almost full"`) {
+		t.Errorf("syslog message = %q", got)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package logger
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultSink is the Windows Event Log, like SetupLogger.
+const defaultSink = SinkEventLog
+
+func newPlatformCore(sink string) (zapcore.Core, func(), error) {
+	if sink != SinkEventLog {
+		return nil, nil, fmt.Errorf("unsupported log sink %q on windows", sink)
+	}
+	w, err := NewWinlogWriter(sourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(newJSONEncoder(true), w, level), func() { w.(*winlogWriter).log.Close() }, nil
+}
+
+// ensureLogDir creates the log directory, which inherits the ProgramData ACLs.
+func ensureLogDir(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return nil
+}
@@ -12,12 +12,13 @@ import (
 
 type KmLogger struct {
 	Logger *zap.Logger
+	closer func()
 }
 
 // SetupLogger returns simple logger for unix systems
 func SetupLogger() *KmLogger {
 	cfg := zap.NewProductionConfig()
-	cfg.Level = zap.NewAtomicLevelAt(ParseLogLevel())
+	cfg.Level = Level()
 	logger, err := cfg.Build()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create logger: %v\n", err)
@@ -28,4 +29,7 @@ func SetupLogger() *KmLogger {
 
 func (k *KmLogger) MustCleanup() {
 	k.Logger.Sync()
+	if k.closer != nil {
+		k.closer()
+	}
 }
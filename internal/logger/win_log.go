@@ -18,6 +18,7 @@ const sourceName = "kmagent"
 type KmLogger struct {
 	Logger *zap.Logger
 	syncer zapcore.WriteSyncer
+	closer func()
 }
 
 // winlogWriter is a custom WriteSyncer that writes to the Windows Event Log.
@@ -76,7 +77,7 @@ func SetupLogger() *KmLogger {
 	loggerCore := zapcore.NewCore(
 		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
 		winlogSyncer,
-		ParseLogLevel(),
+		Level(),
 	)
 
 	logger := zap.New(loggerCore)
@@ -88,5 +89,10 @@ func SetupLogger() *KmLogger {
 
 func (k *KmLogger) MustCleanup() {
 	k.Logger.Sync()
-	k.syncer.(*winlogWriter).log.Close()
+	if w, ok := k.syncer.(*winlogWriter); ok {
+		w.log.Close()
+	}
+	if k.closer != nil {
+		k.closer()
+	}
 }
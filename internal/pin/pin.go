@@ -0,0 +1,125 @@
+// Package pin freezes the collector config on a host so remote updates are reported but not applied.
+package pin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SourceFile     = "file"
+	SourceCLI      = "cli"
+	SourceConfig   = "config"
+	SourceRollback = "rollback"
+)
+
+// State describes an active pin.
+type State struct {
+	Source string     `json:"source"`
+	Reason string     `json:"reason,omitempty"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// Expired reports whether the pin had an expiry that has passed.
+func (s *State) Expired(now time.Time) bool {
+	return s.Until != nil && !now.Before(*s.Until)
+}
+
+// Read returns the pin held in the flag file, nil when the file does not exist. An empty
+// file, e.g. created with touch, pins indefinitely from its modification time.
+func Read(path string) (*State, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &State{Source: SourceFile, Since: info.ModTime().UTC()}
+	if strings.TrimSpace(string(data)) == "" {
+		return st, nil
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse pin file %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// Write pins the config by writing the flag file.
+func Write(path string, st State) error {
+	if st.Since.IsZero() {
+		st.Since = time.Now().UTC()
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	// replaced through a temporary file, the running agent reads it on every config check
+	if err := os.WriteFile(path+".new", data, 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		os.Remove(path + ".new")
+		return err
+	}
+	return nil
+}
+
+// Clear removes the flag file.
+func Clear(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Resolve returns the active pin, nil when remote configs may be applied. A pin from the agent
+// config takes precedence over the flag file, expired flag files are removed.
+func Resolve(path string, configPinned bool, configUntil string) (*State, error) {
+	now := time.Now()
+	if configPinned {
+		st := &State{Source: SourceConfig, Reason: "pinned in agent config"}
+		if configUntil != "" {
+			until, err := ParseUntil(configUntil)
+			if err != nil {
+				return nil, err
+			}
+			st.Until = &until
+		}
+		if !st.Expired(now) {
+			return st, nil
+		}
+	}
+
+	st, err := Read(path)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	if st.Expired(now) {
+		return nil, Clear(path)
+	}
+	return st, nil
+}
+
+// ParseUntil accepts an RFC 3339 timestamp.
+func ParseUntil(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid pin expiry %q, expected RFC 3339: %w", v, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,95 @@
+package pin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pin", "config.pinned")
+	if st, err := Read(path); err != nil || st != nil {
+		t.Fatalf("Read() without a file = %+v, %v", st, err)
+	}
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := Write(path, State{Source: SourceCLI, Reason: "incident", Until: &until}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Source != SourceCLI || st.Reason != "incident" || st.Since.IsZero() || st.Until == nil || !st.Until.Equal(until) {
+		t.Errorf("Read() = %+v", st)
+	}
+	if err := Clear(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clear(path); err != nil {
+		t.Errorf("Clear() without a file: %v", err)
+	}
+}
+
+func TestReadTouchedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.pinned")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Read(path)
+	if err != nil || st == nil || st.Source != SourceFile || st.Until != nil {
+		t.Errorf("Read() of an empty file = %+v, %v, want an indefinite pin", st, err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Error("Read() accepted an invalid pin file")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name         string
+		file         *State
+		configPinned bool
+		configUntil  string
+		want         string
+		wantErr      bool
+		fileRemoved  bool
+	}{
+		{name: "not pinned"},
+		{name: "flag file", file: &State{Source: SourceCLI}, want: SourceCLI},
+		{name: "agent config wins", file: &State{Source: SourceCLI}, configPinned: true, want: SourceConfig},
+		{name: "agent config until", configPinned: true, configUntil: future, want: SourceConfig},
+		{name: "expired agent config falls back to the file", file: &State{Source: SourceRollback}, configPinned: true, configUntil: past, want: SourceRollback},
+		{name: "invalid agent config until", configPinned: true, configUntil: "tomorrow", wantErr: true},
+		{name: "expired flag file is removed", file: &State{Source: SourceCLI, Until: &time.Time{}}, fileRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.pinned")
+			if tt.file != nil {
+				if err := Write(path, *tt.file); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st, err := Resolve(path, tt.configPinned, tt.configUntil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, want error %v", err, tt.wantErr)
+			}
+			got := ""
+			if st != nil {
+				got = st.Source
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() source = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(path); tt.fileRemoved != os.IsNotExist(err) && tt.file != nil {
+				t.Errorf("pin file removed = %v, want %v", os.IsNotExist(err), tt.fileRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+// Package policy enforces local guardrails on collector configs received from the control plane.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rules is a pair of allow and deny glob lists. Deny always wins, an empty allow list allows everything.
+type Rules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Policy is the local policy file.
+//
+//	components:
+//	  deny: [exec, k8s_events]
+//	exporter_endpoints:
+//	  allow: ["https://*.kloudmate.com*"]
+//	receiver_bind_addresses:
+//	  deny: ["0.0.0.0:*", ":*"]
+//	filelog_paths:
+//	  deny: ["/etc/shadow", "/etc/**"]
+type Policy struct {
+	// Components matches component types, e.g. otlp for otlp/custom
+	Components Rules `yaml:"components"`
+	// ExporterEndpoints matches endpoint values of exporters, * matches any characters
+	ExporterEndpoints Rules `yaml:"exporter_endpoints"`
+	// ReceiverBindAddresses matches host:port endpoints receivers listen on
+	ReceiverBindAddresses Rules `yaml:"receiver_bind_addresses"`
+	// FilelogPaths matches filelog include globs, * stays within a directory and ** crosses them
+	FilelogPaths Rules `yaml:"filelog_paths"`
+}
+
+// Violation describes one part of a config that breaks the policy.
+type Violation struct {
+	Component string
+	Rule      string
+	Value     string
+	Reason    string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s %q %s", v.Component, v.Rule, v.Value, v.Reason)
+}
+
+// ViolationError is returned when a config breaks the policy and lists every violation.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		lines = append(lines, v.String())
+	}
+	return fmt.Sprintf("config rejected by local policy (%d violations): %s", len(lines), strings.Join(lines, "; "))
+}
+
+// IsViolation reports whether err is or wraps a *ViolationError.
+func IsViolation(err error) bool {
+	var v *ViolationError
+	return errors.As(err, &v)
+}
+
+// Load reads a policy file. An empty path returns nil, which allows every config.
+func Load(path string) (*Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+var sections = []string{"receivers", "processors", "exporters", "extensions", "connectors"}
+
+// Evaluate checks a collector config and returns a *ViolationError when it breaks the policy.
+// References to config providers other than env and kmsecret are violations, since the
+// content they resolve to is not checked.
+func (p *Policy) Evaluate(cfg map[string]interface{}) error {
+	if p == nil {
+		return nil
+	}
+	var violations []Violation
+	add := func(component, rule, value, reason string) {
+		violations = append(violations, Violation{Component: component, Rule: rule, Value: value, Reason: reason})
+	}
+
+	// the rules below read the config as received, so references the collector would resolve
+	// into config, e.g. ${file:...} or ${yaml:...}, could hide anything from them
+	for _, r := range references(cfg, "") {
+		if !resolvableSchemes[r.scheme] {
+			add(r.path, "config reference", r.value, fmt.Sprintf("uses the %s provider, only env and kmsecret references are allowed", r.scheme))
+		}
+	}
+
+	for _, section := range sections {
+		components, ok := cfg[section].(map[string]interface{})
+		if !ok && cfg[section] != nil {
+			add(section, "section", fmt.Sprint(cfg[section]), "is not a map of components")
+		}
+		for _, id := range sortedKeys(components) {
+			name := section + "/" + id
+			typ := componentType(id)
+			if ok, reason := p.Components.check(typ, false); !ok {
+				add(name, "component type", typ, reason)
+			}
+			body, ok := components[id].(map[string]interface{})
+			if !ok && components[id] != nil {
+				add(name, "component", fmt.Sprint(components[id]), "is not a map of settings")
+			}
+
+			switch section {
+			case "exporters":
+				for _, ep := range endpoints(body) {
+					if ok, reason := p.ExporterEndpoints.check(ep, false); !ok {
+						add(name, "exporter endpoint", ep, reason)
+					}
+				}
+			case "receivers":
+				for _, ep := range endpoints(body) {
+					if !isBindAddress(ep) {
+						continue
+					}
+					if ok, reason := p.ReceiverBindAddresses.check(ep, false); !ok {
+						add(name, "receiver bind address", ep, reason)
+					}
+				}
+				if typ == "filelog" {
+					for _, path := range stringList(body["include"]) {
+						if ok, reason := p.FilelogPaths.check(path, true); !ok {
+							add(name, "filelog path", path, reason)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// check reports whether value passes the rules. For paths a deny rule also applies when the
+// value is a glob that would expand to the denied path, e.g. /etc/* against /etc/shadow.
+func (r Rules) check(value string, path bool) (bool, string) {
+	value = expandEnv(value)
+	if path {
+		value = pathpkg.Clean(value)
+	}
+	for _, pattern := range r.Deny {
+		if globMatch(pattern, value, path) || (path && globMatch(value, pattern, path)) {
+			return false, fmt.Sprintf("matches deny rule %q", pattern)
+		}
+	}
+	if len(r.Allow) == 0 {
+		return true, ""
+	}
+	for _, pattern := range r.Allow {
+		if globMatch(pattern, value, path) {
+			return true, ""
+		}
+	}
+	return false, "matches no allow rule"
+}
+
+// globMatch matches value against pattern. With path set * does not cross a / while ** does.
+func globMatch(pattern, value string, path bool) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if path && (i+1 >= len(pattern) || pattern[i+1] != '*') {
+				b.WriteString("[^/]*")
+			} else {
+				b.WriteString(".*")
+				for i+1 < len(pattern) && pattern[i+1] == '*' {
+					i++
+				}
+			}
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(value)
+}
+
+var envRef = regexp.MustCompile(`\$\{(?:env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv resolves ${env:NAME} and ${NAME} references the way the collector would.
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+	})
+}
+
+// resolvableSchemes are the config providers a remote config may reference.
+var resolvableSchemes = map[string]bool{"env": true, "kmsecret": true}
+
+// configRef matches a ${...} reference with its leading dollars, $$ escapes a literal $.
+var configRef = regexp.MustCompile(`\$+\{([^}]*)\}`)
+
+var schemeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*$`)
+
+type reference struct {
+	path   string
+	scheme string
+	value  string
+}
+
+// references collects the ${scheme:...} references in every key and string value of v,
+// a bare ${NAME} is an env reference.
+func references(v interface{}, path string) []reference {
+	var out []reference
+	find := func(s, path string) {
+		for _, m := range configRef.FindAllStringSubmatch(s, -1) {
+			if strings.Index(m[0], "{")%2 == 0 {
+				continue // an even number of dollars is escaped text
+			}
+			scheme := "env"
+			if prefix, _, found := strings.Cut(m[1], ":"); found && schemeName.MatchString(prefix) {
+				scheme = prefix
+			}
+			out = append(out, reference{path: path, scheme: scheme, value: m[0]})
+		}
+	}
+	switch t := v.(type) {
+	case string:
+		find(t, path)
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			p := k
+			if path != "" {
+				p = path + "/" + k
+			}
+			find(k, p)
+			out = append(out, references(t[k], p)...)
+		}
+	case []interface{}:
+		for i, item := range t {
+			out = append(out, references(item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return out
+}
+
+// componentType strips the optional name from a component id, otlp/custom becomes otlp.
+func componentType(id string) string {
+	typ, _, _ := strings.Cut(id, "/")
+	return typ
+}
+
+// endpoints collects endpoint style string values anywhere in a component config,
+// e.g. endpoint, traces_endpoint or protocols.grpc.endpoint.
+func endpoints(v interface{}) []string {
+	var out []string
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			if s, ok := t[k].(string); ok && (k == "endpoint" || strings.HasSuffix(k, "_endpoint")) {
+				out = append(out, s)
+				continue
+			}
+			out = append(out, endpoints(t[k])...)
+		}
+	case []interface{}:
+		for _, item := range t {
+			out = append(out, endpoints(item)...)
+		}
+	}
+	return out
+}
+
+// isBindAddress reports whether a receiver endpoint is a host:port listen address rather
+// than a URL or socket the receiver connects to.
+func isBindAddress(ep string) bool {
+	ep = expandEnv(ep)
+	if strings.Contains(ep, "://") {
+		return false
+	}
+	_, _, err := net.SplitHostPort(ep)
+	return err == nil
+}
+
+func stringList(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return t
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
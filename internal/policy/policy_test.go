@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		path           bool
+		want           bool
+	}{
+		{pattern: "otlp", value: "otlp", want: true},
+		{pattern: "otlp", value: "otlphttp"},
+		{pattern: "https://*.kloudmate.com*", value: "https://otel.kloudmate.com:4318", want: true},
+		{pattern: "https://*.kloudmate.com*", value: "https://kloudmate.com.evil.io", want: false},
+		{pattern: "https://*.kloudmate.com*", value: "http://otel.kloudmate.com", want: false},
+		{pattern: "0.0.0.0:*", value: "0.0.0.0:4317", want: true},
+		{pattern: ":*", value: "localhost:4317"},
+		{pattern: "localhost:431?", value: "localhost:4318", want: true},
+		{pattern: "a.b", value: "axb"},
+		{pattern: "/etc/*", value: "/etc/shadow", path: true, want: true},
+		{pattern: "/etc/*", value: "/etc/ssl/cert.pem", path: true},
+		{pattern: "/etc/**", value: "/etc/ssl/cert.pem", path: true, want: true},
+		{pattern: "/var/log/*.log", value: "/var/log/app/x.log", path: true},
+		{pattern: "/var/log/*.log", value: "/var/log/syslog.log", path: true, want: true},
+		{pattern: "*", value: "/etc/shadow", want: true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.value, tt.path); got != tt.want {
+			t.Errorf("globMatch(%q, %q, %v) = %v, want %v", tt.pattern, tt.value, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRulesCheck(t *testing.T) {
+	t.Setenv("KM_TEST_HOST", "otel.kloudmate.com")
+	tests := []struct {
+		name  string
+		rules Rules
+		value string
+		path  bool
+		want  bool
+	}{
+		{name: "no rules", value: "anything", want: true},
+		{name: "denied", rules: Rules{Deny: []string{"exec"}}, value: "exec"},
+		{name: "not denied", rules: Rules{Deny: []string{"exec"}}, value: "otlp", want: true},
+		{name: "allowed", rules: Rules{Allow: []string{"otlp*"}}, value: "otlphttp", want: true},
+		{name: "not allowed", rules: Rules{Allow: []string{"otlp*"}}, value: "debug"},
+		{name: "deny wins over allow", rules: Rules{Allow: []string{"*"}, Deny: []string{"exec"}}, value: "exec"},
+		{name: "env is expanded", rules: Rules{Allow: []string{"https://*.kloudmate.com"}}, value: "https://${env:KM_TEST_HOST}", want: true},
+		{name: "path is cleaned", rules: Rules{Deny: []string{"/etc/**"}}, value: "/var/../etc/shadow", path: true},
+		{name: "glob covering a denied path", rules: Rules{Deny: []string{"/etc/shadow"}}, value: "/etc/*", path: true},
+		{name: "glob beside a denied path", rules: Rules{Deny: []string{"/etc/shadow"}}, value: "/var/log/*", path: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := tt.rules.check(tt.value, tt.path); got != tt.want {
+				t.Errorf("check(%q) = %v (%s), want %v", tt.value, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p := &Policy{
+		Components:            Rules{Deny: []string{"exec"}},
+		ExporterEndpoints:     Rules{Allow: []string{"https://*.kloudmate.com*"}},
+		ReceiverBindAddresses: Rules{Deny: []string{"0.0.0.0:*"}},
+		FilelogPaths:          Rules{Deny: []string{"/etc/**"}},
+	}
+	exporter := func(endpoint string) map[string]interface{} {
+		return map[string]interface{}{"exporters": map[string]interface{}{
+			"otlphttp": map[string]interface{}{"endpoint": endpoint},
+		}}
+	}
+	tests := []struct {
+		name       string
+		cfg        map[string]interface{}
+		violations []string
+	}{
+		{name: "allowed", cfg: exporter("https://otel.kloudmate.com:4318")},
+		{name: "exporter endpoint", cfg: exporter("https://collector.example.com"), violations: []string{"exporters/otlphttp"}},
+		{
+			name:       "denied component",
+			cfg:        map[string]interface{}{"receivers": map[string]interface{}{"exec/custom": nil}},
+			violations: []string{"receivers/exec/custom"},
+		},
+		{
+			name: "receiver bind address",
+			cfg: map[string]interface{}{"receivers": map[string]interface{}{"otlp": map[string]interface{}{
+				"protocols": map[string]interface{}{"grpc": map[string]interface{}{"endpoint": "0.0.0.0:4317"}},
+			}}},
+			violations: []string{"receivers/otlp"},
+		},
+		{
+			name: "filelog path",
+			cfg: map[string]interface{}{"receivers": map[string]interface{}{"filelog": map[string]interface{}{
+				"include": []interface{}{"/var/log/*.log", "/etc/*"},
+			}}},
+			violations: []string{"receivers/filelog"},
+		},
+		{name: "env and secret references", cfg: map[string]interface{}{"exporters": map[string]interface{}{
+			"otlphttp": map[string]interface{}{
+				"endpoint": "https://otel.kloudmate.com", "headers": map[string]interface{}{"Authorization": "${kmsecret:api_key}", "X-Env": "${env:HOME} ${HOME}"},
+			},
+		}}},
+		{name: "section from the yaml provider", cfg: map[string]interface{}{"exporters": "${yaml:otlphttp: {endpoint: https://collector.example.com}}"}, violations: []string{"exporters", "exporters"}},
+		{
+			name:       "component from the file provider",
+			cfg:        map[string]interface{}{"exporters": map[string]interface{}{"otlphttp": "${file:/tmp/exporter.yaml}"}},
+			violations: []string{"exporters/otlphttp", "exporters/otlphttp"},
+		},
+		{name: "value from the file provider", cfg: exporter("https://otel.kloudmate.com${file:/tmp/suffix}"), violations: []string{"exporters/otlphttp/endpoint"}},
+		{name: "nested reference", cfg: exporter("https://otel.kloudmate.com${file:${env:SUFFIX}}"), violations: []string{"exporters/otlphttp/endpoint"}},
+		{name: "escaped reference", cfg: exporter("https://otel.kloudmate.com/$${file:x}")},
+		{
+			name:       "reference outside the component sections",
+			cfg:        map[string]interface{}{"service": map[string]interface{}{"pipelines": []interface{}{"${yaml:x}"}}},
+			violations: []string{"service/pipelines[0]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Evaluate(tt.cfg)
+			var got []string
+			var verr *ViolationError
+			if errors.As(err, &verr) {
+				for _, v := range verr.Violations {
+					got = append(got, v.Component)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.violations) {
+				t.Errorf("Evaluate() violations = %v (%v), want %v", got, err, tt.violations)
+			}
+		})
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Evaluate(exporter("${file:/tmp/x}")); err != nil {
+		t.Errorf("nil policy rejected a config: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// ReadFile reads a secret from path, trimming surrounding whitespace and newlines.
+func ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return value, nil
+}
+
+// LoadFile reads path and stores its content under name.
+func (s *Store) LoadFile(name, path string) error {
+	value, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s.Set(name, value)
+	return nil
+}
+
+// LoadDir stores every regular file in dir as a secret named after the file.
+// Hidden entries such as the "..data" links of Kubernetes Secret volumes are skipped.
+func (s *Store) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets directory: %w", err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if err := s.LoadFile(e.Name(), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchFile keeps name in sync with path until ctx is done. The parent directory is watched
+// so atomic renames and Kubernetes Secret symlink swaps are picked up as rotations.
+func (s *Store) WatchFile(ctx context.Context, name, path string, logger *zap.SugaredLogger) error {
+	return watchDir(ctx, filepath.Dir(path), logger, func() {
+		if err := s.LoadFile(name, path); err != nil {
+			logger.Warnw("failed to reload secret file, keeping previous value", "secret", name, "error", err)
+			return
+		}
+		logger.Debugw("secret reloaded", "secret", name)
+	})
+}
+
+// WatchDir keeps all secrets in dir in sync until ctx is done.
+func (s *Store) WatchDir(ctx context.Context, dir string, logger *zap.SugaredLogger) error {
+	return watchDir(ctx, dir, logger, func() {
+		if err := s.LoadDir(dir); err != nil {
+			logger.Warnw("failed to reload secrets directory, keeping previous values", "dir", dir, "error", err)
+		}
+	})
+}
+
+func watchDir(ctx context.Context, dir string, logger *zap.SugaredLogger, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create secret watcher: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) != 0 {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warnw("secret watcher error", "dir", dir, "error", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// waitForSecret polls s until name holds want.
+func waitForSecret(t *testing.T, s *Store, name, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if v, _ := s.Get(name); v == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			v, _ := s.Get(name)
+			t.Fatalf("secret %s = %q, want %q", name, v, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "key"), "  secret\n")
+	writeFile(t, filepath.Join(dir, "empty"), "\n")
+	if v, err := ReadFile(filepath.Join(dir, "key")); err != nil || v != "secret" {
+		t.Errorf("ReadFile() = %q, %v, want trimmed value", v, err)
+	}
+	if _, err := ReadFile(filepath.Join(dir, "empty")); err == nil {
+		t.Error("ReadFile() accepted an empty file")
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadFile() accepted a missing file")
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "api_key"), "key")
+	writeFile(t, filepath.Join(dir, ".hidden"), "hidden")
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore()
+	if err := s.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get(APIKey); v != "key" {
+		t.Errorf("api_key = %q", v)
+	}
+	for _, name := range []string{".hidden", "nested"} {
+		if _, found := s.Get(name); found {
+			t.Errorf("%s loaded as a secret", name)
+		}
+	}
+}
+
+func TestWatchFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api_key")
+	writeFile(t, path, "first")
+	s := NewStore()
+	if err := s.LoadFile(APIKey, path); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.WatchFile(ctx, APIKey, path, zaptest.NewLogger(t).Sugar()); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, "second")
+	waitForSecret(t, s, APIKey, "second")
+
+	// atomic replacement through a rename
+	writeFile(t, path+".new", "third")
+	if err := os.Rename(path+".new", path); err != nil {
+		t.Fatal(err)
+	}
+	waitForSecret(t, s, APIKey, "third")
+
+	// an empty file keeps the previous value
+	writeFile(t, path, "")
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, filepath.Join(dir, "unrelated"), "x")
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := s.Get(APIKey); v != "third" {
+		t.Errorf("api_key = %q after an empty write, want the previous value", v)
+	}
+}
+
+func TestWatchFileKubernetesSecret(t *testing.T) {
+	// a Secret volume links api_key to ..data/api_key and swaps ..data to a new
+	// timestamped directory on every update
+	dir := t.TempDir()
+	version := func(name, value string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, name, "api_key"), value)
+	}
+	swap := func(target string) {
+		t.Helper()
+		if err := os.Symlink(target, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("..2026_10_19_12_00_00.1", "first")
+	swap("..2026_10_19_12_00_00.1")
+	if err := os.Symlink(filepath.Join("..data", "api_key"), filepath.Join(dir, "api_key")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore()
+	path := filepath.Join(dir, "api_key")
+	if err := s.LoadFile(APIKey, path); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.WatchFile(ctx, APIKey, path, zaptest.NewLogger(t).Sugar()); err != nil {
+		t.Fatal(err)
+	}
+
+	version("..2026_10_19_13_00_00.2", "second")
+	swap("..2026_10_19_13_00_00.2")
+	if err := os.RemoveAll(filepath.Join(dir, "..2026_10_19_12_00_00.1")); err != nil {
+		t.Fatal(err)
+	}
+	waitForSecret(t, s, APIKey, "second")
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "db_password"), "first")
+	s := NewStore()
+	if err := s.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.WatchDir(ctx, dir, zaptest.NewLogger(t).Sugar()); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "db_password"), "second")
+	waitForSecret(t, s, "db_password", "second")
+	writeFile(t, filepath.Join(dir, "token"), "new")
+	waitForSecret(t, s, "token", "new")
+
+	if err := s.WatchDir(ctx, filepath.Join(dir, "missing"), zaptest.NewLogger(t).Sugar()); err == nil {
+		t.Error("WatchDir() accepted a missing directory")
+	}
+}
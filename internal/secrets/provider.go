@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.opentelemetry.io/collector/confmap/provider/envprovider"
+)
+
+// SchemeName is the confmap scheme resolving secrets from the store, e.g. ${kmsecret:api_key}.
+const SchemeName = "kmsecret"
+
+// envBindings maps environment variables referenced by existing collector configs to store entries,
+// so ${env:KM_API_KEY} keeps working without the key being exported into the environment.
+var envBindings = map[string]string{
+	"KM_API_KEY": APIKey,
+}
+
+// NewProviderFactory returns a provider for the kmsecret scheme backed by s.
+// Retrieved values are watched, so the collector reloads its config when a secret rotates.
+func NewProviderFactory(s *Store) confmap.ProviderFactory {
+	return confmap.NewProviderFactory(func(confmap.ProviderSettings) confmap.Provider {
+		return &provider{store: s}
+	})
+}
+
+// NewEnvProviderFactory returns an env provider that serves bound variables from s
+// and delegates everything else to the standard env provider.
+func NewEnvProviderFactory(s *Store) confmap.ProviderFactory {
+	return confmap.NewProviderFactory(func(set confmap.ProviderSettings) confmap.Provider {
+		return &envProvider{
+			store:    s,
+			delegate: envprovider.NewFactory().Create(set),
+		}
+	})
+}
+
+type provider struct {
+	store *Store
+}
+
+func (p *provider) Retrieve(_ context.Context, uri string, watcher confmap.WatcherFunc) (*confmap.Retrieved, error) {
+	name, ok := strings.CutPrefix(uri, SchemeName+":")
+	if !ok {
+		return nil, fmt.Errorf("%q uri is not supported by %q provider", uri, SchemeName)
+	}
+	if _, found := p.store.Get(name); !found {
+		return nil, fmt.Errorf("secret %q is not configured", name)
+	}
+	return retrieve(p.store, name, watcher)
+}
+
+func (*provider) Scheme() string {
+	return SchemeName
+}
+
+func (*provider) Shutdown(context.Context) error {
+	return nil
+}
+
+type envProvider struct {
+	store    *Store
+	delegate confmap.Provider
+}
+
+func (p *envProvider) Retrieve(ctx context.Context, uri string, watcher confmap.WatcherFunc) (*confmap.Retrieved, error) {
+	envVar, _, _ := strings.Cut(strings.TrimPrefix(uri, "env:"), ":-")
+	if name, bound := envBindings[envVar]; bound {
+		if _, found := p.store.Get(name); found {
+			return retrieve(p.store, name, watcher)
+		}
+	}
+	return p.delegate.Retrieve(ctx, uri, watcher)
+}
+
+func (p *envProvider) Scheme() string {
+	return p.delegate.Scheme()
+}
+
+func (p *envProvider) Shutdown(ctx context.Context) error {
+	return p.delegate.Shutdown(ctx)
+}
+
+func retrieve(s *Store, name string, watcher confmap.WatcherFunc) (*confmap.Retrieved, error) {
+	value, _ := s.Get(name)
+	if watcher == nil {
+		return confmap.NewRetrieved(value)
+	}
+	unsubscribe := s.Subscribe(name, func() {
+		watcher(&confmap.ChangeEvent{})
+	})
+	return confmap.NewRetrieved(value, confmap.WithRetrievedClose(func(context.Context) error {
+		unsubscribe()
+		return nil
+	}))
+}
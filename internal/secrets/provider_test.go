@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.uber.org/zap"
+)
+
+func TestProvider(t *testing.T) {
+	s := NewStore()
+	s.Set(APIKey, "first")
+	p := NewProviderFactory(s).Create(confmap.ProviderSettings{Logger: zap.NewNop()})
+	ctx := context.Background()
+
+	changed := make(chan struct{}, 1)
+	retrieved, err := p.Retrieve(ctx, "kmsecret:api_key", func(*confmap.ChangeEvent) { changed <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := retrieved.AsRaw(); err != nil || raw != "first" {
+		t.Errorf("Retrieve() = %v, %v", raw, err)
+	}
+	s.Set(APIKey, "second")
+	select {
+	case <-changed:
+	default:
+		t.Error("rotation not reported to the collector")
+	}
+	if err := retrieved.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Set(APIKey, "third")
+	select {
+	case <-changed:
+		t.Error("rotation reported after the retrieved value was closed")
+	default:
+	}
+
+	if _, err := p.Retrieve(ctx, "kmsecret:missing", nil); err == nil {
+		t.Error("Retrieve() of an unknown secret succeeded")
+	}
+	if _, err := p.Retrieve(ctx, "env:HOME", nil); err == nil {
+		t.Error("Retrieve() accepted another scheme")
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("KM_API_KEY", "from-env")
+	t.Setenv("KM_TEST_VALUE", "plain")
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		store map[string]string
+		uri   string
+		want  string
+	}{
+		{name: "bound variable from the store", store: map[string]string{APIKey: "from-store"}, uri: "env:KM_API_KEY", want: "from-store"},
+		{name: "bound variable with a default", store: map[string]string{APIKey: "from-store"}, uri: "env:KM_API_KEY:-none", want: "from-store"},
+		{name: "bound variable not in the store", uri: "env:KM_API_KEY", want: "from-env"},
+		{name: "other variables", store: map[string]string{APIKey: "from-store"}, uri: "env:KM_TEST_VALUE", want: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			for name, value := range tt.store {
+				s.Set(name, value)
+			}
+			p := NewEnvProviderFactory(s).Create(confmap.ProviderSettings{Logger: zap.NewNop()})
+			retrieved, err := p.Retrieve(ctx, tt.uri, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, err := retrieved.AsRaw(); err != nil || raw != tt.want {
+				t.Errorf("Retrieve(%s) = %v, %v, want %q", tt.uri, raw, err, tt.want)
+			}
+		})
+	}
+}
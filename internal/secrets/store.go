@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"sync"
+)
+
+// APIKey is the name under which the KloudMate API key is stored.
+const APIKey = "api_key"
+
+// Store keeps secret values in memory and notifies subscribers when a value changes.
+// Secrets held here are never exported to the process environment.
+type Store struct {
+	mu          sync.RWMutex
+	values      map[string]string
+	subscribers map[string]map[int]func()
+	nextID      int
+}
+
+var defaultStore = NewStore()
+
+// Default returns the process wide secret store shared by the agent, updaters and collector.
+func Default() *Store {
+	return defaultStore
+}
+
+// NewStore creates an empty secret store.
+func NewStore() *Store {
+	return &Store{
+		values:      make(map[string]string),
+		subscribers: make(map[string]map[int]func()),
+	}
+}
+
+// Set stores value under name and notifies subscribers if it changed.
+func (s *Store) Set(name, value string) {
+	s.mu.Lock()
+	old, ok := s.values[name]
+	s.values[name] = value
+	var notify []func()
+	if !ok || old != value {
+		for _, fn := range s.subscribers[name] {
+			notify = append(notify, fn)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+// Get returns the value stored under name.
+func (s *Store) Get(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[name]
+	return v, ok
+}
+
+// Subscribe registers fn to be called whenever name changes. The returned func removes the subscription.
+func (s *Store) Subscribe(name string, fn func()) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[name] == nil {
+		s.subscribers[name] = make(map[int]func())
+	}
+	id := s.nextID
+	s.nextID++
+	s.subscribers[name][id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers[name], id)
+	}
+}
@@ -0,0 +1,29 @@
+package secrets
+
+import "testing"
+
+func TestStoreNotifiesChanges(t *testing.T) {
+	s := NewStore()
+	calls := 0
+	unsubscribe := s.Subscribe(APIKey, func() { calls++ })
+
+	s.Set(APIKey, "first")
+	s.Set(APIKey, "first")
+	s.Set("other", "value")
+	s.Set(APIKey, "second")
+	if calls != 2 {
+		t.Errorf("subscriber called %d times, want once per change", calls)
+	}
+	if v, found := s.Get(APIKey); !found || v != "second" {
+		t.Errorf("Get() = %q, %v", v, found)
+	}
+
+	unsubscribe()
+	s.Set(APIKey, "third")
+	if calls != 2 {
+		t.Error("subscriber called after unsubscribing")
+	}
+	if _, found := s.Get("missing"); found {
+		t.Error("Get() found a secret that was never set")
+	}
+}
@@ -1,17 +1,20 @@
 package shared
 
 import (
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/secrets"
 	"github.com/kloudmate/km-agent/internal/version"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/confmap"
-	"go.opentelemetry.io/collector/confmap/provider/envprovider"
 	"go.opentelemetry.io/collector/confmap/provider/fileprovider"
 	"go.opentelemetry.io/collector/confmap/provider/yamlprovider"
 	"go.opentelemetry.io/collector/otelcol"
 )
 
-func CollectorInfoFactory(cfgPath string) otelcol.CollectorSettings {
+// CollectorInfoFactory returns the collector settings for the config at cfgPath. Converters are
+// applied to the resolved config before it is unmarshalled, e.g. to inject agent managed components.
+func CollectorInfoFactory(cfgPath string, converters ...confmap.ConverterFactory) otelcol.CollectorSettings {
 	info := component.BuildInfo{
 		Command:     "kmagent",
 		Description: "KloudMate Agent for OpenTelemetry",
@@ -27,12 +30,17 @@ func CollectorInfoFactory(cfgPath string) otelcol.CollectorSettings {
 				DefaultScheme: "env",
 				URIs:          []string{cfgPath},
 				ProviderFactories: []confmap.ProviderFactory{
-					envprovider.NewFactory(),
+					// serves ${env:KM_API_KEY} from the secret store, other variables from the environment
+					secrets.NewEnvProviderFactory(secrets.Default()),
+					secrets.NewProviderFactory(secrets.Default()),
 					fileprovider.NewFactory(),
 					yamlprovider.NewFactory(),
 				},
+				// collector logs follow the agent's log level, see kmlogger.CollectorLoggingOptions
+				ConverterFactories: append([]confmap.ConverterFactory{kmlogger.NewCollectorLevelConverterFactory()}, converters...),
 			},
 		},
+		LoggingOptions:        kmlogger.CollectorLoggingOptions(),
 		SkipSettingGRPCLogger: true,
 	}
 }
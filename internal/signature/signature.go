@@ -0,0 +1,109 @@
+// Package signature verifies detached signatures over control plane responses against a pinned public key.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Header carries the base64 encoded detached signature of the response body.
+const Header = "X-KM-Signature"
+
+var (
+	ErrUnsigned         = errors.New("payload is not signed")
+	ErrInvalidSignature = errors.New("signature verification failed")
+)
+
+// Verifier checks signatures made with Ed25519 keys or, as produced by `cosign sign-blob`,
+// ECDSA P-256 keys over the SHA-256 digest of the payload.
+type Verifier struct {
+	key any
+}
+
+// LoadVerifier reads a PEM encoded PKIX public key from path.
+func LoadVerifier(path string) (*Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	return NewVerifier(data)
+}
+
+// NewVerifier parses a PEM encoded PKIX public key.
+func NewVerifier(pemBytes []byte) (*Verifier, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return &Verifier{key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T, expected Ed25519 or ECDSA", key)
+	}
+}
+
+// Verify checks the base64 encoded signature over payload.
+func (v *Verifier) Verify(payload []byte, sig string) error {
+	sig = strings.TrimSpace(sig)
+	if sig == "" {
+		return ErrUnsigned
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("%w: invalid base64: %v", ErrInvalidSignature, err)
+	}
+
+	var ok bool
+	switch key := v.key.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, payload, raw)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		ok = ecdsa.VerifyASN1(key, digest[:], raw)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Ed25519 reports whether the pinned key is an Ed25519 key.
+func (v *Verifier) Ed25519() bool {
+	_, ok := v.key.(ed25519.PublicKey)
+	return ok
+}
+
+// VerifyPrehashed checks a base64 encoded Ed25519ph signature (RFC 8032) given the SHA-512
+// digest of the payload, for payloads too large to hold in memory such as agent binaries.
+func (v *Verifier) VerifyPrehashed(digest []byte, sig string) error {
+	key, ok := v.key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: prehashed signatures require an Ed25519 key", ErrInvalidSignature)
+	}
+	sig = strings.TrimSpace(sig)
+	if sig == "" {
+		return ErrUnsigned
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("%w: invalid base64: %v", ErrInvalidSignature, err)
+	}
+	if err := ed25519.VerifyWithOptions(key, digest, raw, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func publicKeyPEM(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func newVerifier(t *testing.T, key any) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(publicKeyPEM(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"restart_required":true}`)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(payload)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name    string
+		key     any
+		payload []byte
+		sig     string
+		wantErr error
+	}{
+		{name: "ed25519", key: edPub, payload: payload, sig: encode(ed25519.Sign(edKey, payload))},
+		{name: "ecdsa", key: &ecKey.PublicKey, payload: payload, sig: encode(ecSig)},
+		{name: "signature with surrounding whitespace", key: edPub, payload: payload, sig: " " + encode(ed25519.Sign(edKey, payload)) + "\n"},
+		{name: "unsigned", key: edPub, payload: payload, wantErr: ErrUnsigned},
+		{name: "tampered payload", key: edPub, payload: []byte(`{"restart_required":false}`), sig: encode(ed25519.Sign(edKey, payload)), wantErr: ErrInvalidSignature},
+		{name: "tampered ecdsa payload", key: &ecKey.PublicKey, payload: []byte(`{}`), sig: encode(ecSig), wantErr: ErrInvalidSignature},
+		{name: "other key", key: otherPub, payload: payload, sig: encode(ed25519.Sign(edKey, payload)), wantErr: ErrInvalidSignature},
+		{name: "ecdsa signature for an ed25519 key", key: edPub, payload: payload, sig: encode(ecSig), wantErr: ErrInvalidSignature},
+		{name: "invalid base64", key: edPub, payload: payload, sig: "not base64!", wantErr: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newVerifier(t, tt.key).Verify(tt.payload, tt.sig)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPrehashed(t *testing.T) {
+	payload := []byte("agent binary")
+	digest := sha512.Sum512(payload)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prehashed, err := edKey.Sign(rand.Reader, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.StdEncoding.EncodeToString
+	otherDigest := sha512.Sum512([]byte("another binary"))
+
+	tests := []struct {
+		name    string
+		key     any
+		digest  []byte
+		sig     string
+		wantErr error
+	}{
+		{name: "ed25519ph", key: edPub, digest: digest[:], sig: encode(prehashed)},
+		{name: "unsigned", key: edPub, digest: digest[:], wantErr: ErrUnsigned},
+		{name: "other digest", key: edPub, digest: otherDigest[:], sig: encode(prehashed), wantErr: ErrInvalidSignature},
+		{name: "pure ed25519 signature", key: edPub, digest: digest[:], sig: encode(ed25519.Sign(edKey, payload)), wantErr: ErrInvalidSignature},
+		{name: "ecdsa key", key: &ecKey.PublicKey, digest: digest[:], sig: encode(prehashed), wantErr: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newVerifier(t, tt.key).VerifyPrehashed(tt.digest, tt.sig)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyPrehashed() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := newVerifier(t, edPub); !v.Ed25519() {
+		t.Error("Ed25519() = false for an Ed25519 key")
+	}
+	if _, err := NewVerifier(publicKeyPEM(t, &rsaKey.PublicKey)); err == nil {
+		t.Error("NewVerifier() accepted an RSA key")
+	}
+	if _, err := NewVerifier([]byte("not a key")); err == nil {
+		t.Error("NewVerifier() accepted a file without a PEM block")
+	}
+}
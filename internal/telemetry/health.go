@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+)
+
+// Probe tracks liveness and readiness of a long running update loop.
+type Probe struct {
+	mu          sync.Mutex
+	checks      map[string]func() bool
+	staleAfter  atomic.Int64
+	lastBeat    atomic.Int64
+	firstPassed atomic.Bool
+}
+
+// NewProbe creates a probe that reports unhealthy when no heartbeat was seen within staleAfter.
+func NewProbe(staleAfter time.Duration) *Probe {
+	p := &Probe{checks: make(map[string]func() bool)}
+	p.staleAfter.Store(int64(staleAfter))
+	return p
+}
+
+// AddReadinessCheck registers an additional condition that must hold before the probe is ready.
+func (p *Probe) AddReadinessCheck(name string, fn func() bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks[name] = fn
+}
+
+// SetStaleAfter changes how long the loop may go without a heartbeat before it is considered wedged.
+func (p *Probe) SetStaleAfter(d time.Duration) {
+	p.staleAfter.Store(int64(d))
+}
+
+// ObserveIteration records a completed loop iteration and whether it succeeded.
+func (p *Probe) ObserveIteration(err error) {
+	p.lastBeat.Store(time.Now().UnixNano())
+	if err == nil {
+		p.firstPassed.Store(true)
+	}
+}
+
+// Live returns an error if the loop has not completed an iteration within the stale window.
+func (p *Probe) Live() error {
+	last := p.lastBeat.Load()
+	if last == 0 {
+		return nil
+	}
+	staleAfter := time.Duration(p.staleAfter.Load())
+	if since := time.Since(time.Unix(0, last)); staleAfter > 0 && since > staleAfter {
+		return fmt.Errorf("last update loop iteration was %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+// Ready returns an error until all readiness checks pass and the first iteration succeeded.
+func (p *Probe) Ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, fn := range p.checks {
+		if !fn() {
+			return fmt.Errorf("%s is not ready", name)
+		}
+	}
+	if !p.firstPassed.Load() {
+		return fmt.Errorf("first config check has not succeeded yet")
+	}
+	return nil
+}
+
+// Route is an additional handler served next to the health endpoints.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+// NewServer returns an HTTP server exposing /healthz, /readyz, /metrics, /loglevel and routes on addr.
+// The server is reachable from the network, so /loglevel is read only here, see NewControlServer.
+func NewServer(addr string, p *Probe, routes ...Route) *http.Server {
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		mux.Handle(r.Pattern, r.Handler)
+	}
+	mux.HandleFunc("/healthz", probeHandler(p.Live))
+	mux.HandleFunc("/readyz", probeHandler(p.Ready))
+	mux.Handle("/metrics", MetricsHandler())
+	mux.Handle("/loglevel", readOnly(kmlogger.LevelHandler()))
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// NewControlServer returns an HTTP server for the local control API on addr, exposing
+// PUT /loglevel and routes. Its endpoints are not authenticated, so addr must be a loopback address.
+func NewControlServer(addr string, routes ...Route) (*http.Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("control address %q is not a loopback address", addr)
+	}
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		mux.Handle(r.Pattern, r.Handler)
+	}
+	mux.Handle("/loglevel", kmlogger.LevelHandler())
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}, nil
+}
+
+// readOnly rejects every request to h that is not a GET.
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed, use the local control API", http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
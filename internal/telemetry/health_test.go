@@ -0,0 +1,35 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerLogLevelIsReadOnly(t *testing.T) {
+	srv := NewServer(":0", NewProbe(time.Minute))
+	for method, want := range map[string]int{http.MethodGet: http.StatusOK, http.MethodPut: http.StatusMethodNotAllowed} {
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, httptest.NewRequest(method, "/loglevel", strings.NewReader(`{"level":"debug"}`)))
+		if rec.Code != want {
+			t.Errorf("%s /loglevel = %d, want %d", method, rec.Code, want)
+		}
+	}
+}
+
+func TestNewControlServer(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:13134": true,
+		"[::1]:13134":     true,
+		"localhost:13134": true,
+		":13134":          false,
+		"0.0.0.0:13134":   false,
+		"10.0.0.5:13134":  false,
+	} {
+		if _, err := NewControlServer(addr); (err == nil) != ok {
+			t.Errorf("NewControlServer(%q) error = %v, want ok = %v", addr, err, ok)
+		}
+	}
+}
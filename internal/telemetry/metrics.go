@@ -0,0 +1,132 @@
+package telemetry
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kloudmate/km-agent/internal/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "km_updater"
+
+// Outcome label values shared by all counters.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// OutcomeRejected counts configs refused by the local policy
+	OutcomeRejected = "rejected"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	// DetectionsReceived counts language detection results pushed over RPC.
+	DetectionsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "detections_received_total",
+		Help:      "Number of language detection results received over RPC.",
+	})
+
+	// DetectionCacheSize tracks the number of entries in the detection cache.
+	DetectionCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "detection_cache_entries",
+		Help:      "Number of entries currently held in the detection cache.",
+	})
+
+	configChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_checks_total",
+		Help:      "Number of config checks against the KloudMate API by outcome.",
+	}, []string{"outcome"})
+
+	configMapUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmap_updates_total",
+		Help:      "Number of collector ConfigMap updates by outcome.",
+	}, []string{"outcome"})
+
+	rolloutsTriggered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rollouts_triggered_total",
+		Help:      "Number of agent rollouts triggered by workload kind and outcome.",
+	}, []string{"kind", "outcome"})
+
+	apmPatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apm_patches_total",
+		Help:      "Number of APM instrumentation patches by workload kind, language and outcome.",
+	}, []string{"kind", "language", "outcome"})
+
+	apmRollouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apm_rollouts_total",
+		Help:      "Number of scheduled APM rollouts by result, healthy or reverted.",
+	}, []string{"result"})
+
+	// APMRolloutsPending tracks APM rollouts waiting for a free slot.
+	APMRolloutsPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "apm_rollouts_pending",
+		Help:      "Number of APM rollouts queued by the rollout scheduler.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		DetectionsReceived,
+		DetectionCacheSize,
+		configChecks,
+		configMapUpdates,
+		rolloutsTriggered,
+		apmPatches,
+		apmRollouts,
+		APMRolloutsPending,
+	)
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ObserveConfigCheck records the outcome of a config check.
+func ObserveConfigCheck(err error) {
+	configChecks.WithLabelValues(outcome(err)).Inc()
+}
+
+// ObserveConfigMapUpdate records the outcome of a collector ConfigMap update.
+func ObserveConfigMapUpdate(err error) {
+	if policy.IsViolation(err) {
+		configMapUpdates.WithLabelValues(OutcomeRejected).Inc()
+		return
+	}
+	configMapUpdates.WithLabelValues(outcome(err)).Inc()
+}
+
+// ObserveRollout records the outcome of a rollout restart for the given workload kind.
+func ObserveRollout(kind string, err error) {
+	rolloutsTriggered.WithLabelValues(strings.ToLower(kind), outcome(err)).Inc()
+}
+
+// ObserveAPMPatch records the outcome of an instrumentation patch.
+func ObserveAPMPatch(kind, language string, err error) {
+	apmPatches.WithLabelValues(strings.ToLower(kind), strings.ToLower(language), outcome(err)).Inc()
+}
+
+// ObserveAPMRollout records the result of a scheduled APM rollout.
+func ObserveAPMRollout(result string) {
+	apmRollouts.WithLabelValues(result).Inc()
+}
+
+// MetricsHandler serves the updater's self-metrics in the Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,193 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kloudmate/km-agent/internal/instrumentation"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// InstrumentationOptions tune the OpenTelemetry agent injected into one workload. Unset fields keep
+// the values of the shared Instrumentation resource.
+type InstrumentationOptions struct {
+	Sampler *SamplerOptions `json:"sampler,omitempty"`
+	// Propagators replace the shared propagators, e.g. tracecontext, baggage, b3
+	Propagators []string `json:"propagators,omitempty"`
+	// ResourceAttributes are added to all telemetry of the workload
+	ResourceAttributes map[string]string `json:"resource_attributes,omitempty"`
+	// Env holds extra environment variables for the language agent
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// SamplerOptions select an OpenTelemetry sampler, e.g. parentbased_traceidratio with argument 0.25.
+type SamplerOptions struct {
+	Type     string `json:"type"`
+	Argument string `json:"argument,omitempty"`
+}
+
+// instrumentationGVR identifies OpenTelemetry operator Instrumentation resources.
+var instrumentationGVR = schema.GroupVersionResource{Group: "opentelemetry.io", Version: "v1alpha1", Resource: "instrumentations"}
+
+const managedByLabel = "app.kubernetes.io/managed-by"
+const managedByValue = "km-config-updater"
+
+// instrumentationName is the Instrumentation resource generated for a workload. Names too long for
+// a resource are truncated and end in a hash of the workload, so long names sharing a prefix do not
+// collide.
+func instrumentationName(namespace, kind, workload string) string {
+	name := "km-" + strings.ToLower(kind) + "-" + workload
+	if len(name) <= 63 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(namespace + "/" + strings.ToLower(kind) + "/" + workload))
+	return strings.TrimRight(name[:54], "-.") + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// ensureInstrumentation creates or updates the Instrumentation resource for a workload with options
+// and returns its reference for inject annotations, namespace/name, and whether its spec changed.
+// Workloads without options use the shared resource, see instrument for deleting a generated one.
+func (a *K8sConfigUpdater) ensureInstrumentation(ctx context.Context, app APMConfig) (string, bool, error) {
+	if app.Instrumentation == nil {
+		return "", false, nil
+	}
+	if a.cfg.DynamicClient == nil {
+		return "", false, fmt.Errorf("instrumentation options for %s/%s need a dynamic kubernetes client", app.Namespace, app.Deployment)
+	}
+	lang := instrumentation.LanguageFor(app.Language)
+	if lang.Method != instrumentation.MethodOperator {
+		return "", false, fmt.Errorf("instrumentation options are not supported for %s", app.Language)
+	}
+
+	sharedNs, sharedName := instrumentation.SharedCRD()
+	shared, err := a.cfg.DynamicClient.Resource(instrumentationGVR).Namespace(sharedNs).Get(ctx, sharedName, v1.GetOptions{})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read Instrumentation %s/%s: %w", sharedNs, sharedName, err)
+	}
+	spec, _, err := unstructured.NestedMap(shared.Object, "spec")
+	if err != nil {
+		return "", false, fmt.Errorf("invalid Instrumentation %s/%s: %w", sharedNs, sharedName, err)
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	if err := applyInstrumentationOptions(spec, lang.Key, app.Instrumentation); err != nil {
+		return "", false, err
+	}
+
+	name := instrumentationName(app.Namespace, app.Kind, app.Deployment)
+	ref := app.Namespace + "/" + name
+	resources := a.cfg.DynamicClient.Resource(instrumentationGVR).Namespace(app.Namespace)
+	existing, err := resources.Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": instrumentationGVR.GroupVersion().String(),
+			"kind":       "Instrumentation",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": app.Namespace,
+				"labels":    map[string]interface{}{managedByLabel: managedByValue},
+			},
+			"spec": spec,
+		}}
+		if _, err := resources.Create(ctx, obj, v1.CreateOptions{}); err != nil {
+			return "", false, fmt.Errorf("failed to create Instrumentation %s: %w", ref, err)
+		}
+		a.logger.Infof("[APM]: created Instrumentation %s", ref)
+		return ref, true, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read Instrumentation %s: %w", ref, err)
+	}
+	current, _, _ := unstructured.NestedMap(existing.Object, "spec")
+	if reflect.DeepEqual(current, spec) {
+		return ref, false, nil
+	}
+	existing.Object["spec"] = spec
+	if _, err := resources.Update(ctx, existing, v1.UpdateOptions{}); err != nil {
+		return "", false, fmt.Errorf("failed to update Instrumentation %s: %w", ref, err)
+	}
+	a.logger.Infof("[APM]: updated Instrumentation %s", ref)
+	return ref, true, nil
+}
+
+// deleteInstrumentation removes the Instrumentation resource generated for a workload, if any.
+func (a *K8sConfigUpdater) deleteInstrumentation(ctx context.Context, app APMConfig) {
+	if a.cfg.DynamicClient == nil {
+		return
+	}
+	name := instrumentationName(app.Namespace, app.Kind, app.Deployment)
+	resources := a.cfg.DynamicClient.Resource(instrumentationGVR).Namespace(app.Namespace)
+	existing, err := resources.Get(ctx, name, v1.GetOptions{})
+	if err != nil || existing.GetLabels()[managedByLabel] != managedByValue {
+		return
+	}
+	if err := resources.Delete(ctx, name, v1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		a.logger.Warnf("[APM]: failed to delete Instrumentation %s/%s: %v", app.Namespace, name, err)
+		return
+	}
+	a.logger.Infof("[APM]: deleted Instrumentation %s/%s", app.Namespace, name)
+}
+
+// applyInstrumentationOptions sets options on an Instrumentation spec, env vars go to the language
+// section so they only reach that agent.
+func applyInstrumentationOptions(spec map[string]interface{}, lang string, o *InstrumentationOptions) error {
+	if o.Sampler != nil {
+		sampler := map[string]interface{}{"type": o.Sampler.Type}
+		if o.Sampler.Argument != "" {
+			sampler["argument"] = o.Sampler.Argument
+		}
+		spec["sampler"] = sampler
+	}
+	if len(o.Propagators) > 0 {
+		propagators := make([]interface{}, len(o.Propagators))
+		for i, p := range o.Propagators {
+			propagators[i] = p
+		}
+		spec["propagators"] = propagators
+	}
+	if len(o.ResourceAttributes) > 0 {
+		attrs := map[string]interface{}{}
+		for k, v := range o.ResourceAttributes {
+			attrs[k] = v
+		}
+		if err := unstructured.SetNestedMap(spec, attrs, "resource", "resourceAttributes"); err != nil {
+			return err
+		}
+	}
+	if len(o.Env) > 0 {
+		env, _, err := unstructured.NestedSlice(spec, lang, "env")
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(o.Env))
+		for k := range o.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, map[string]interface{}{"name": k, "value": o.Env[k]})
+		}
+		if err := unstructured.SetNestedSlice(spec, env, lang, "env"); err != nil {
+			return err
+		}
+	}
+	// round trip through JSON so the spec compares equal to the one read back from the API
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	for k := range spec {
+		delete(spec, k)
+	}
+	return json.Unmarshal(data, &spec)
+}
@@ -0,0 +1,262 @@
+package updater
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kloudmate/km-agent/internal/agentconfig"
+	"github.com/kloudmate/km-agent/internal/config"
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestInstrumentationName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name                string
+		namespace, workload string
+		want                string
+	}{
+		{name: "short", namespace: "default", workload: "shop", want: "km-deployment-shop"},
+		{name: "at the limit", namespace: "default", workload: long[:49], want: "km-deployment-" + long[:49]},
+		{name: "truncated", namespace: "default", workload: long, want: "km-deployment-" + long[:40] + "-"},
+		{name: "trailing dash trimmed", namespace: "default", workload: long[:39] + "-" + long, want: "km-deployment-" + long[:39] + "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := instrumentationName(tt.namespace, "Deployment", tt.workload)
+			if !strings.HasPrefix(got, tt.want) || len(got) > 63 || strings.Contains(got, "--") {
+				t.Errorf("instrumentationName() = %q (%d chars), want prefix %q within 63 chars", got, len(got), tt.want)
+			}
+			if got != instrumentationName(tt.namespace, "DEPLOYMENT", tt.workload) {
+				t.Errorf("instrumentationName() depends on the kind's case")
+			}
+		})
+	}
+
+	// long names sharing a prefix get different names
+	names := map[string]bool{}
+	for _, w := range []struct{ namespace, workload string }{
+		{"default", long + "-api"}, {"default", long + "-web"}, {"payments", long + "-api"},
+	} {
+		names[instrumentationName(w.namespace, "Deployment", w.workload)] = true
+	}
+	if len(names) != 3 {
+		t.Errorf("truncated names collide: %v", names)
+	}
+}
+
+func TestApplyInstrumentationOptions(t *testing.T) {
+	shared := func() map[string]interface{} {
+		return map[string]interface{}{
+			"exporter":    map[string]interface{}{"endpoint": "http://km-agent:4318"},
+			"propagators": []interface{}{"tracecontext", "baggage"},
+			"java":        map[string]interface{}{"env": []interface{}{map[string]interface{}{"name": "OTEL_LOGS_EXPORTER", "value": "otlp"}}},
+		}
+	}
+	tests := []struct {
+		name    string
+		options InstrumentationOptions
+		path    []string
+		want    interface{}
+	}{
+		{
+			name:    "sampler",
+			options: InstrumentationOptions{Sampler: &SamplerOptions{Type: "parentbased_traceidratio", Argument: "0.25"}},
+			path:    []string{"sampler"},
+			want:    map[string]interface{}{"type": "parentbased_traceidratio", "argument": "0.25"},
+		},
+		{
+			name:    "sampler without argument",
+			options: InstrumentationOptions{Sampler: &SamplerOptions{Type: "always_on"}},
+			path:    []string{"sampler"},
+			want:    map[string]interface{}{"type": "always_on"},
+		},
+		{
+			name:    "propagators replace the shared ones",
+			options: InstrumentationOptions{Propagators: []string{"b3"}},
+			path:    []string{"propagators"},
+			want:    []interface{}{"b3"},
+		},
+		{
+			name:    "resource attributes",
+			options: InstrumentationOptions{ResourceAttributes: map[string]string{"team": "checkout"}},
+			path:    []string{"resource", "resourceAttributes"},
+			want:    map[string]interface{}{"team": "checkout"},
+		},
+		{
+			name:    "env is added to the language section",
+			options: InstrumentationOptions{Env: map[string]string{"B": "2", "A": "1"}},
+			path:    []string{"java", "env"},
+			want: []interface{}{
+				map[string]interface{}{"name": "OTEL_LOGS_EXPORTER", "value": "otlp"},
+				map[string]interface{}{"name": "A", "value": "1"},
+				map[string]interface{}{"name": "B", "value": "2"},
+			},
+		},
+		{
+			name:    "shared settings are kept",
+			options: InstrumentationOptions{Sampler: &SamplerOptions{Type: "always_on"}},
+			path:    []string{"exporter"},
+			want:    map[string]interface{}{"endpoint": "http://km-agent:4318"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := shared()
+			if err := applyInstrumentationOptions(spec, "java", &tt.options); err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := unstructured.NestedFieldNoCopy(spec, tt.path...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", strings.Join(tt.path, "."), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureInstrumentation(t *testing.T) {
+	shared := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "opentelemetry.io/v1alpha1",
+		"kind":       "Instrumentation",
+		"metadata":   map[string]interface{}{"name": "km-agent-instrumentation-crd", "namespace": testNamespace},
+		"spec":       map[string]interface{}{"exporter": map[string]interface{}{"endpoint": "http://km-agent:4318"}},
+	}}
+	foreign := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "opentelemetry.io/v1alpha1",
+		"kind":       "Instrumentation",
+		"metadata":   map[string]interface{}{"name": "km-deployment-api", "namespace": "default"},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{instrumentationGVR: "InstrumentationList"}, shared, foreign)
+	u := &K8sConfigUpdater{cfg: &config.K8sAgentConfig{DynamicClient: dynamicClient}, logger: zaptest.NewLogger(t).Sugar()}
+	instrumentations := dynamicClient.Resource(instrumentationGVR).Namespace("default")
+	ctx := context.Background()
+
+	app := APMConfig{Namespace: "default", Deployment: "shop", Kind: "Deployment", Enabled: true, Language: "Java",
+		Instrumentation: &InstrumentationOptions{Sampler: &SamplerOptions{Type: "parentbased_traceidratio", Argument: "0.25"}}}
+	steps := []struct {
+		name    string
+		arg     string
+		ref     string
+		changed bool
+	}{
+		{name: "created", arg: "0.25", ref: "default/km-deployment-shop", changed: true},
+		{name: "unchanged", arg: "0.25", ref: "default/km-deployment-shop"},
+		{name: "updated", arg: "0.5", ref: "default/km-deployment-shop", changed: true},
+	}
+	for _, step := range steps {
+		app.Instrumentation.Sampler.Argument = step.arg
+		ref, changed, err := u.ensureInstrumentation(ctx, app)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if ref != step.ref || changed != step.changed {
+			t.Errorf("%s: ensureInstrumentation() = %q, %v, want %q, %v", step.name, ref, changed, step.ref, step.changed)
+		}
+	}
+	generated, err := instrumentations.Get(ctx, "km-deployment-shop", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arg, _, _ := unstructured.NestedString(generated.Object, "spec", "sampler", "argument"); arg != "0.5" {
+		t.Errorf("sampler argument = %q, want 0.5", arg)
+	}
+	if endpoint, _, _ := unstructured.NestedString(generated.Object, "spec", "exporter", "endpoint"); endpoint != "http://km-agent:4318" {
+		t.Errorf("shared exporter not copied: %v", generated.Object["spec"])
+	}
+
+	// without options the workload uses the shared resource, the generated one is kept until the
+	// workload no longer references it
+	app.Instrumentation = nil
+	if ref, _, err := u.ensureInstrumentation(ctx, app); err != nil || ref != "" {
+		t.Errorf("ensureInstrumentation() without options = %q, %v", ref, err)
+	}
+	if _, err := instrumentations.Get(ctx, "km-deployment-shop", metav1.GetOptions{}); err != nil {
+		t.Errorf("generated Instrumentation deleted before the workload was patched: %v", err)
+	}
+	u.deleteInstrumentation(ctx, app)
+	if _, err := instrumentations.Get(ctx, "km-deployment-shop", metav1.GetOptions{}); err == nil {
+		t.Error("generated Instrumentation not deleted")
+	}
+
+	// resources not created by the updater are never deleted
+	u.deleteInstrumentation(ctx, APMConfig{Namespace: "default", Deployment: "api", Kind: "Deployment"})
+	if _, err := instrumentations.Get(ctx, "km-deployment-api", metav1.GetOptions{}); err != nil {
+		t.Errorf("unmanaged Instrumentation deleted: %v", err)
+	}
+
+	ruby := APMConfig{Namespace: "default", Deployment: "shop", Kind: "Deployment", Language: "Ruby", Instrumentation: &InstrumentationOptions{}}
+	if _, _, err := u.ensureInstrumentation(ctx, ruby); err == nil {
+		t.Error("ensureInstrumentation() accepted options for a language instrumented with eBPF")
+	}
+}
+
+func TestGeneratedInstrumentationDeletedAfterPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "re-pointed at the shared resource", enabled: true},
+		{name: "instrumentation removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "opentelemetry.io/v1alpha1",
+				"kind":       "Instrumentation",
+				"metadata": map[string]interface{}{
+					"name": "km-deployment-shop", "namespace": "default",
+					"labels": map[string]interface{}{managedByLabel: managedByValue},
+				},
+			}}
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{instrumentationGVR: "InstrumentationList"}, generated)
+			client := fake.NewClientset(&appsv1.Deployment{
+				ObjectMeta: objectMeta("default", "shop"),
+				Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{javaInjectKey: "default/km-deployment-shop"},
+				}}},
+			})
+			instrumentations := dynamicClient.Resource(instrumentationGVR).Namespace("default")
+			patched := false
+			client.PrependReactor("patch", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if _, err := instrumentations.Get(context.Background(), "km-deployment-shop", metav1.GetOptions{}); err != nil {
+					t.Errorf("Instrumentation deleted before the workload was patched: %v", err)
+				}
+				patched = true
+				return false, nil, nil
+			})
+			u := &K8sConfigUpdater{
+				cfg:    &config.K8sAgentConfig{K8sClient: client, DynamicClient: dynamicClient},
+				logger: zaptest.NewLogger(t).Sugar(),
+			}
+			resp := &K8sConfigUpdateResponse{K8s: K8sApmConfig{APMEnabled: true, APMSettings: []APMConfig{
+				{Namespace: "default", Deployment: "shop", Kind: "Deployment", Enabled: tt.enabled, Language: "Java"},
+			}}}
+			ctx := context.Background()
+			if err := u.performAPMUpdation(ctx, resp, newTestScope(t, client, agentconfig.Settings{})); err != nil {
+				t.Fatal(err)
+			}
+			if !patched {
+				t.Fatal("workload not patched")
+			}
+			if _, err := instrumentations.Get(ctx, "km-deployment-shop", metav1.GetOptions{}); err == nil {
+				t.Error("generated Instrumentation not deleted after the patch")
+			}
+		})
+	}
+}
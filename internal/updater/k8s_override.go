@@ -0,0 +1,53 @@
+package updater
+
+import (
+	"strings"
+
+	"github.com/kloudmate/km-agent/internal/instrumentation"
+)
+
+// applyOverride applies the workload's instrumentation annotation to app, if it has a valid one.
+func (a *K8sConfigUpdater) applyOverride(scope *scopeCheck, app *APMConfig) {
+	w, err := scope.workload(app.Kind, app.Namespace, app.Deployment)
+	if err != nil {
+		return
+	}
+	value, found := w.annotations[instrumentation.OverrideAnnotation]
+	if !found {
+		return
+	}
+	override, err := instrumentation.ParseOverride(value)
+	if err != nil {
+		a.logger.Warnf("[APM]: ignoring annotation on %s/%s: %v", app.Namespace, app.Deployment, err)
+		return
+	}
+	app.Override = override.Value
+	app.Enabled = override.Enabled
+	if override.Language != "" {
+		app.Language = override.Language
+	}
+}
+
+// applyOverrides applies workload annotations to the server's APM settings. Detected workloads
+// opted in by annotation are instrumented even when the server does not list them.
+func (a *K8sConfigUpdater) applyOverrides(scope *scopeCheck, settings, detected []APMConfig) []APMConfig {
+	out := make([]APMConfig, 0, len(settings))
+	listed := map[string]bool{}
+	for _, app := range settings {
+		a.applyOverride(scope, &app)
+		listed[apmKey(app)] = true
+		out = append(out, app)
+	}
+	for _, app := range detected {
+		if app.Override == "" || !app.Enabled || listed[apmKey(app)] {
+			continue
+		}
+		listed[apmKey(app)] = true
+		out = append(out, app)
+	}
+	return out
+}
+
+func apmKey(app APMConfig) string {
+	return strings.ToUpper(app.Kind) + "/" + app.Namespace + "/" + app.Deployment
+}
@@ -0,0 +1,66 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/kloudmate/km-agent/internal/agentconfig"
+	"github.com/kloudmate/km-agent/internal/instrumentation"
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	annotated := func(name, value string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: name, Annotations: map[string]string{instrumentation.OverrideAnnotation: value},
+		}}
+	}
+	client := fake.NewClientset(
+		annotated("api", "python"),
+		annotated("worker", "enabled"),
+		annotated("broken", "sometimes"),
+		&appsv1.Deployment{
+			ObjectMeta: objectMeta("default", "shop"),
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{instrumentation.OverrideAnnotation: "disabled"},
+			}}},
+		},
+	)
+	scope := newTestScope(t, client, agentconfig.Settings{})
+	u := &K8sConfigUpdater{logger: zaptest.NewLogger(t).Sugar()}
+	java := func(name string, enabled bool) APMConfig {
+		return APMConfig{Namespace: "default", Deployment: name, Kind: "Deployment", Enabled: enabled, Language: "Java"}
+	}
+
+	settings := []APMConfig{java("shop", true), java("api", true), java("broken", true), java("gone", true)}
+	detected := []APMConfig{
+		{Namespace: "default", Deployment: "worker", Kind: "Deployment", Enabled: true, Language: "Go", Override: instrumentation.OverrideEnabled},
+		{Namespace: "default", Deployment: "cache", Kind: "Deployment", Language: "Go"},
+	}
+	want := []APMConfig{
+		{Namespace: "default", Deployment: "shop", Kind: "Deployment", Language: "Java", Override: instrumentation.OverrideDisabled},
+		{Namespace: "default", Deployment: "api", Kind: "Deployment", Enabled: true, Language: "Python", Override: "python"},
+		java("broken", true),
+		java("gone", true),
+		detected[0],
+	}
+	got := u.applyOverrides(scope, settings, detected)
+	if len(got) != len(want) {
+		t.Fatalf("applyOverrides() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("applyOverrides()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// annotations come from the informer cache, not one request per workload
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" {
+			t.Errorf("workload read from the API: %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/telemetry"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rolloutConfig limits how fast APM instrumentation restarts workloads.
+type rolloutConfig struct {
+	// concurrency is the number of workloads restarted at once, 0 patches every workload immediately
+	concurrency int
+	// timeout is how long a restarted workload may take to become healthy before it is reverted
+	timeout time.Duration
+	// pause holds back queued rollouts after a revert
+	pause time.Duration
+	poll  time.Duration
+}
+
+// rolloutTask instruments one workload, kind is deployment, daemonset, statefulset, replicaset or pod.
+type rolloutTask struct {
+	kind        string
+	app         APMConfig
+	patch       []byte
+	annotations map[string]string
+	// applied runs once the patch is applied, unless a newer task for the workload is waiting
+	applied func(context.Context)
+}
+
+func (t rolloutTask) key() string {
+	return t.kind + "/" + t.app.Namespace + "/" + t.app.Deployment
+}
+
+// rolloutFailureAnnotation persists a RolloutFailure as JSON on the reverted workload, so it is
+// not instrumented again after the updater restarts.
+const rolloutFailureAnnotation = "kloudmate.io/instrumentation-reverted"
+
+// RolloutFailure is a workload whose instrumentation was reverted.
+type RolloutFailure struct {
+	Namespace string    `json:"namespace"`
+	Workload  string    `json:"deployment"`
+	Kind      string    `json:"kind"`
+	Language  string    `json:"language"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+}
+
+// RolloutStatus is reported to the control plane as apm_rollout.
+type RolloutStatus struct {
+	Pending     int              `json:"pending"`
+	Active      []string         `json:"active,omitempty"`
+	Failed      []RolloutFailure `json:"failed,omitempty"`
+	PausedUntil *time.Time       `json:"paused_until,omitempty"`
+}
+
+// rolloutScheduler applies instrumentation patches a few workloads at a time, waiting for each
+// rollout to become healthy and reverting the ones that crash-loop. Reverted workloads are not
+// instrumented again until the server disables them.
+type rolloutScheduler struct {
+	client kubernetes.Interface
+	logger *zap.SugaredLogger
+	cfg    rolloutConfig
+
+	mu     sync.Mutex
+	queue  []rolloutTask
+	active map[string]bool
+	// followUp holds the latest task for an active workload, queued once its rollout is healthy
+	followUp    map[string]rolloutTask
+	failed      map[string]RolloutFailure
+	pausedUntil time.Time
+	wake        chan struct{}
+}
+
+func newRolloutScheduler(client kubernetes.Interface, logger *zap.SugaredLogger, cfg rolloutConfig) *rolloutScheduler {
+	if cfg.poll == 0 {
+		cfg.poll = 5 * time.Second
+	}
+	return &rolloutScheduler{
+		client:   client,
+		logger:   logger,
+		cfg:      cfg,
+		active:   map[string]bool{},
+		followUp: map[string]rolloutTask{},
+		failed:   map[string]RolloutFailure{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// enqueue schedules a task. A task for a workload that is queued already replaces the queued one
+// and one for a workload rolling out is applied after it. It returns why a task was refused,
+// empty when it was accepted.
+func (s *rolloutScheduler) enqueue(t rolloutTask) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := t.key()
+	if f, found := s.failed[key]; found {
+		return "reverted earlier: " + f.Reason
+	}
+	if s.active[key] {
+		s.logger.Infof("[APM]: %s is rolling out, applying the latest instrumentation once it is healthy", key)
+		s.followUp[key] = t
+		return ""
+	}
+	for i, queued := range s.queue {
+		if queued.key() == key {
+			s.queue[i] = t
+			return ""
+		}
+	}
+	s.push(t)
+	return ""
+}
+
+// push queues a task, s.mu must be held.
+func (s *rolloutScheduler) push(t rolloutTask) {
+	s.queue = append(s.queue, t)
+	telemetry.APMRolloutsPending.Set(float64(len(s.queue)))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forget drops queued tasks and the failure of a workload, e.g. once the server disables it. A
+// failure persisted on the workload is removed too.
+func (s *rolloutScheduler) forget(ctx context.Context, kind string, app APMConfig) {
+	if s == nil {
+		return
+	}
+	key := rolloutTask{kind: kind, app: app}.key()
+	s.mu.Lock()
+	_, failed := s.failed[key]
+	delete(s.failed, key)
+	delete(s.followUp, key)
+	queue := s.queue[:0]
+	for _, t := range s.queue {
+		if t.key() != key {
+			queue = append(queue, t)
+		}
+	}
+	s.queue = queue
+	telemetry.APMRolloutsPending.Set(float64(len(s.queue)))
+	s.mu.Unlock()
+
+	if !failed {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{
+		"annotations": map[string]interface{}{rolloutFailureAnnotation: nil},
+	}})
+	if err == nil {
+		err = patchWorkload(ctx, s.client, kind, app, patch)
+	}
+	if err != nil {
+		s.logger.Errorf("[APM]: error clearing the revert of %s: %v", key, err)
+	}
+}
+
+// restore loads the failures persisted on workloads by earlier runs of the updater.
+func (s *rolloutScheduler) restore(ctx context.Context) error {
+	restored := map[string]RolloutFailure{}
+	add := func(kind string, meta v1.ObjectMeta) {
+		value, found := meta.Annotations[rolloutFailureAnnotation]
+		if !found {
+			return
+		}
+		var f RolloutFailure
+		if err := json.Unmarshal([]byte(value), &f); err != nil {
+			f = RolloutFailure{Reason: "reverted earlier"}
+		}
+		f.Kind, f.Namespace, f.Workload = kind, meta.Namespace, meta.Name
+		restored[kind+"/"+meta.Namespace+"/"+meta.Name] = f
+	}
+	apps := s.client.AppsV1()
+	deployments, err := apps.Deployments("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, d := range deployments.Items {
+		add("deployment", d.ObjectMeta)
+	}
+	daemonSets, err := apps.DaemonSets("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, ds := range daemonSets.Items {
+		add("daemonset", ds.ObjectMeta)
+	}
+	statefulSets, err := apps.StatefulSets("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, ss := range statefulSets.Items {
+		add("statefulset", ss.ObjectMeta)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, f := range restored {
+		s.failed[key] = f
+	}
+	return nil
+}
+
+func (s *rolloutScheduler) status() *RolloutStatus {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &RolloutStatus{Pending: len(s.queue)}
+	for key := range s.active {
+		st.Active = append(st.Active, key)
+	}
+	for _, f := range s.failed {
+		st.Failed = append(st.Failed, f)
+	}
+	if time.Now().Before(s.pausedUntil) {
+		until := s.pausedUntil
+		st.PausedUntil = &until
+	}
+	return st
+}
+
+// run processes the queue until ctx is done. Nothing is rolled out before the failures of earlier
+// runs are restored.
+func (s *rolloutScheduler) run(ctx context.Context) {
+	for {
+		err := s.restore(ctx)
+		if err == nil {
+			break
+		}
+		s.logger.Warnf("[APM]: failed to load reverted rollouts, retrying: %v", err)
+		select {
+		case <-time.After(s.cfg.poll):
+		case <-ctx.Done():
+			return
+		}
+	}
+	slots := make(chan struct{}, s.cfg.concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		task, ok := s.next(ctx)
+		if !ok {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.rollout(ctx, task)
+		}()
+	}
+}
+
+// next blocks until a task may start and marks it active.
+func (s *rolloutScheduler) next(ctx context.Context) (rolloutTask, bool) {
+	for {
+		s.mu.Lock()
+		wait := time.Duration(-1)
+		if pause := time.Until(s.pausedUntil); pause > 0 {
+			wait = pause
+		} else if len(s.queue) > 0 {
+			task := s.queue[0]
+			s.queue = s.queue[1:]
+			s.active[task.key()] = true
+			telemetry.APMRolloutsPending.Set(float64(len(s.queue)))
+			s.mu.Unlock()
+			return task, true
+		}
+		s.mu.Unlock()
+
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-s.wake:
+		case <-timer:
+		case <-ctx.Done():
+			return rolloutTask{}, false
+		}
+	}
+}
+
+func (s *rolloutScheduler) rollout(ctx context.Context, t rolloutTask) {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		key := t.key()
+		delete(s.active, key)
+		if next, found := s.followUp[key]; found {
+			delete(s.followUp, key)
+			if _, failed := s.failed[key]; !failed {
+				s.push(next)
+			}
+		}
+	}()
+	app := t.app
+	if err := patchWorkload(ctx, s.client, t.kind, app, t.patch); err != nil {
+		s.logger.Errorf("[APM]: error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
+		return
+	}
+	s.mu.Lock()
+	_, superseded := s.followUp[t.key()]
+	s.mu.Unlock()
+	if t.applied != nil && !superseded {
+		t.applied(ctx)
+	}
+	// replicasets and pods do not roll out, patched templates only affect new pods
+	if t.kind == "replicaset" || t.kind == "pod" {
+		return
+	}
+	s.logger.Infof("[APM]: waiting for %s %s/%s to roll out with %s instrumentation", t.kind, app.Namespace, app.Deployment, app.Language)
+	err := s.waitHealthy(ctx, t)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		telemetry.ObserveAPMRollout("healthy")
+		s.logger.Infof("[APM]: %s %s/%s is healthy with %s instrumentation", t.kind, app.Namespace, app.Deployment, app.Language)
+		return
+	}
+
+	telemetry.ObserveAPMRollout("reverted")
+	s.logger.Errorf("[APM]: reverting instrumentation of %s %s/%s and pausing rollouts for %s: %v", t.kind, app.Namespace, app.Deployment, s.cfg.pause, err)
+	failure := RolloutFailure{
+		Namespace: app.Namespace,
+		Workload:  app.Deployment,
+		Kind:      t.kind,
+		Language:  app.Language,
+		Reason:    err.Error(),
+		At:        time.Now(),
+	}
+	s.mu.Lock()
+	s.failed[t.key()] = failure
+	s.pausedUntil = time.Now().Add(s.cfg.pause)
+	s.mu.Unlock()
+
+	persisted, _ := json.Marshal(failure)
+	revert, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{rolloutFailureAnnotation: string(persisted)}},
+		"spec": map[string]interface{}{"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": buildRemoveAnnotationsPatch(t.annotations)},
+		}},
+	})
+	if err == nil {
+		err = patchWorkload(ctx, s.client, t.kind, app, revert)
+	}
+	if err != nil {
+		s.logger.Errorf("[APM]: error reverting auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
+	}
+}
+
+// waitHealthy polls a workload until its rollout completed, failing when instrumented pods
+// crash-loop or the rollout takes longer than the timeout.
+func (s *rolloutScheduler) waitHealthy(ctx context.Context, t rolloutTask) error {
+	deadline := time.Now().Add(s.cfg.timeout)
+	ticker := time.NewTicker(s.cfg.poll)
+	defer ticker.Stop()
+	for {
+		done, selector, err := rolloutState(ctx, s.client, t.kind, t.app.Namespace, t.app.Deployment)
+		if err != nil {
+			return err
+		}
+		if reason, err := s.crashLooping(ctx, t, selector); err != nil {
+			s.logger.Warnf("[APM]: failed to check pods of %s/%s: %v", t.app.Namespace, t.app.Deployment, err)
+		} else if reason != "" {
+			return fmt.Errorf("instrumented pods are failing: %s", reason)
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("rollout did not become healthy within %s", s.cfg.timeout)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// crashLooping returns why an instrumented pod of the workload is failing, empty when none is.
+func (s *rolloutScheduler) crashLooping(ctx context.Context, t rolloutTask, selector *v1.LabelSelector) (string, error) {
+	if selector == nil {
+		return "", nil
+	}
+	sel, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	pods, err := s.client.CoreV1().Pods(t.app.Namespace).List(ctx, v1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if !isAnnotationSame(t.annotations, pod.Annotations) {
+			continue
+		}
+		if reason := podFailure(pod); reason != "" {
+			return fmt.Sprintf("pod %s %s", pod.Name, reason), nil
+		}
+	}
+	return "", nil
+}
+
+// failingReasons are container waiting reasons that will not resolve without a change.
+var failingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"RunContainerError":          true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+}
+
+func podFailure(pod corev1.Pod) string {
+	statuses := append(append([]corev1.ContainerStatus(nil), pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if w := cs.State.Waiting; w != nil && failingReasons[w.Reason] {
+			return fmt.Sprintf("container %s is in %s", cs.Name, w.Reason)
+		}
+		if cs.RestartCount >= 3 {
+			return fmt.Sprintf("container %s restarted %d times", cs.Name, cs.RestartCount)
+		}
+	}
+	return ""
+}
+
+// rolloutState reports whether a workload finished rolling out, like kubectl rollout status,
+// and returns its pod selector.
+func rolloutState(ctx context.Context, client kubernetes.Interface, kind, namespace, name string) (bool, *v1.LabelSelector, error) {
+	apps := client.AppsV1()
+	switch kind {
+	case "deployment":
+		d, err := apps.Deployments(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, nil, err
+		}
+		want := replicas(d.Spec.Replicas)
+		st := d.Status
+		return st.ObservedGeneration >= d.Generation && st.UpdatedReplicas == want && st.Replicas == want && st.AvailableReplicas == want, d.Spec.Selector, nil
+	case "daemonset":
+		ds, err := apps.DaemonSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, nil, err
+		}
+		st := ds.Status
+		return st.ObservedGeneration >= ds.Generation && st.UpdatedNumberScheduled == st.DesiredNumberScheduled && st.NumberAvailable == st.DesiredNumberScheduled, ds.Spec.Selector, nil
+	case "statefulset":
+		ss, err := apps.StatefulSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, nil, err
+		}
+		want := replicas(ss.Spec.Replicas)
+		st := ss.Status
+		return st.ObservedGeneration >= ss.Generation && st.UpdatedReplicas == want && st.ReadyReplicas == want && st.CurrentRevision == st.UpdateRevision, ss.Spec.Selector, nil
+	default:
+		return false, nil, fmt.Errorf("%s does not roll out", kind)
+	}
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+// patchWorkload applies a strategic merge patch to a workload of the given kind.
+func patchWorkload(ctx context.Context, client kubernetes.Interface, kind string, app APMConfig, patch []byte) error {
+	var err error
+	apps := client.AppsV1()
+	switch kind {
+	case "deployment":
+		return handleDeploymentPatching(ctx, client, app, patch)
+	case "daemonset":
+		_, err = apps.DaemonSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	case "statefulset":
+		_, err = apps.StatefulSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	case "replicaset":
+		_, err = apps.ReplicaSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	case "pod":
+		_, err = client.CoreV1().Pods(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	default:
+		return fmt.Errorf("unsupported workload kind %s", kind)
+	}
+	telemetry.ObserveAPMPatch(kind, app.Language, err)
+	return err
+}
+
+// parseRolloutConfig reads the rollout limits from KM_APM_ROLLOUT_* env values.
+func parseRolloutConfig(concurrency, timeout, pause string) (rolloutConfig, error) {
+	cfg := rolloutConfig{concurrency: 1, timeout: 10 * time.Minute, pause: 30 * time.Minute}
+	if concurrency = strings.TrimSpace(concurrency); concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid KM_APM_ROLLOUT_CONCURRENCY %q", concurrency)
+		}
+		cfg.concurrency = n
+	}
+	for _, d := range []struct {
+		env, value string
+		dst        *time.Duration
+	}{
+		{"KM_APM_ROLLOUT_TIMEOUT", timeout, &cfg.timeout},
+		{"KM_APM_ROLLOUT_PAUSE", pause, &cfg.pause},
+	} {
+		if strings.TrimSpace(d.value) == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(d.value))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", d.env, d.value, err)
+		}
+		*d.dst = parsed
+	}
+	return cfg, nil
+}
@@ -0,0 +1,258 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// waitUntil polls cond until it holds and fails the test after timeout.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// javaTask instruments the Deployment name in default with Java through the Instrumentation ref.
+func javaTask(t *testing.T, name, ref string) rolloutTask {
+	t.Helper()
+	annotations := map[string]string{javaInjectKey: ref}
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rolloutTask{
+		kind:        "deployment",
+		app:         APMConfig{Namespace: "default", Deployment: name, Kind: "Deployment", Enabled: true, Language: "Java"},
+		patch:       patch,
+		annotations: annotations,
+	}
+}
+
+// startScheduler runs a scheduler restarting one workload at a time until the test ends.
+func startScheduler(t *testing.T, client *fake.Clientset) *rolloutScheduler {
+	t.Helper()
+	s := newRolloutScheduler(client, zaptest.NewLogger(t).Sugar(), rolloutConfig{
+		concurrency: 1, timeout: time.Minute, pause: time.Hour, poll: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+func TestRolloutSchedulerCanary(t *testing.T) {
+	ctx := context.Background()
+	var objects []runtime.Object
+	for _, name := range []string{"api", "web"} {
+		objects = append(objects, &appsv1.Deployment{
+			ObjectMeta: objectMeta("default", name),
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}},
+		})
+	}
+	client := fake.NewClientset(objects...)
+	s := startScheduler(t, client)
+	instrumented := func(name string) bool {
+		return injected(t, client, name) != ""
+	}
+
+	for _, name := range []string{"api", "web"} {
+		if reason := s.enqueue(javaTask(t, name, javaInjectValue)); reason != "" {
+			t.Fatalf("enqueue(%s) refused: %s", name, reason)
+		}
+	}
+	waitUntil(t, "api to be instrumented", func() bool { return instrumented("api") })
+	time.Sleep(50 * time.Millisecond)
+	if instrumented("web") {
+		t.Fatal("web restarted before the api rollout became healthy")
+	}
+
+	// the api rollout completes, which lets web start
+	api, err := client.AppsV1().Deployments("default").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if _, err := client.AppsV1().Deployments("default").UpdateStatus(ctx, api, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "web to be instrumented", func() bool { return instrumented("web") })
+
+	// an instrumented web pod crash-loops, so web is reverted and rollouts pause
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: "web-1", Labels: map[string]string{"app": "web"},
+			Annotations: map[string]string{javaInjectKey: javaInjectValue},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: "web", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	}
+	if _, err := client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "web to be reverted", func() bool { return !instrumented("web") })
+	if !instrumented("api") {
+		t.Error("healthy api rollout was reverted")
+	}
+
+	status := s.status()
+	if len(status.Failed) != 1 || status.Failed[0].Workload != "web" || status.PausedUntil == nil {
+		t.Fatalf("unexpected rollout status %+v", status)
+	}
+	// the reverted workload is refused until the server disables it, also after a restart
+	if reason := s.enqueue(javaTask(t, "web", javaInjectValue)); reason == "" {
+		t.Error("reverted workload queued again")
+	}
+	if !reverted(t, client, "web") {
+		t.Fatal("revert not persisted on the workload")
+	}
+	restarted := startScheduler(t, client)
+	waitUntil(t, "the revert to be restored", func() bool { return len(restarted.status().Failed) == 1 })
+	if reason := restarted.enqueue(javaTask(t, "web", javaInjectValue)); reason == "" {
+		t.Error("reverted workload queued again after a restart")
+	}
+	restarted.forget(ctx, "deployment", javaTask(t, "web", javaInjectValue).app)
+	if reverted(t, client, "web") {
+		t.Error("persisted revert not cleared when the workload was disabled")
+	}
+	if reason := restarted.enqueue(javaTask(t, "web", javaInjectValue)); reason != "" {
+		t.Errorf("enqueue after forget refused: %s", reason)
+	}
+}
+
+func TestRolloutSchedulerCoalesces(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(&appsv1.Deployment{
+		ObjectMeta: objectMeta("default", "api"),
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}},
+	})
+
+	// a queued task is replaced by a newer one for the same workload
+	idle := newRolloutScheduler(client, zaptest.NewLogger(t).Sugar(), rolloutConfig{concurrency: 1})
+	idle.enqueue(javaTask(t, "api", "default/km-deployment-api"))
+	idle.enqueue(javaTask(t, "api", javaInjectValue))
+	if len(idle.queue) != 1 || idle.queue[0].annotations[javaInjectKey] != javaInjectValue {
+		t.Errorf("queue = %+v, want only the latest task", idle.queue)
+	}
+
+	// a task for a workload rolling out is applied once the rollout is healthy
+	s := startScheduler(t, client)
+	s.enqueue(javaTask(t, "api", javaInjectValue))
+	waitUntil(t, "api to be instrumented", func() bool { return injected(t, client, "api") == javaInjectValue })
+	latest := javaTask(t, "api", "default/km-deployment-api")
+	applied := make(chan struct{})
+	latest.applied = func(context.Context) { close(applied) }
+	s.enqueue(latest)
+	time.Sleep(50 * time.Millisecond)
+	if got := injected(t, client, "api"); got != javaInjectValue {
+		t.Fatalf("api patched to %q during its rollout", got)
+	}
+	api, err := client.AppsV1().Deployments("default").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if _, err := client.AppsV1().Deployments("default").UpdateStatus(ctx, api, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the latest task to be applied", func() bool { return injected(t, client, "api") == "default/km-deployment-api" })
+	select {
+	case <-applied:
+	case <-time.After(5 * time.Second):
+		t.Error("applied callback of the latest task not run")
+	}
+}
+
+// injected returns the Java inject annotation on the pod template of a Deployment in default.
+func injected(t *testing.T, client *fake.Clientset, name string) string {
+	t.Helper()
+	dep, err := client.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dep.Spec.Template.Annotations[javaInjectKey]
+}
+
+// reverted reports whether a revert is persisted on a Deployment in default.
+func reverted(t *testing.T, client *fake.Clientset, name string) bool {
+	t.Helper()
+	dep, err := client.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found := dep.Annotations[rolloutFailureAnnotation]
+	return found
+}
+
+func TestPodFailure(t *testing.T) {
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+	}
+	tests := []struct {
+		name    string
+		status  corev1.PodStatus
+		failing bool
+	}{
+		{name: "running", status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app"}}}},
+		{name: "starting", status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: waiting("ContainerCreating")}}}},
+		{name: "crash loop", status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: waiting("CrashLoopBackOff")}}}, failing: true},
+		{name: "init container", status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{Name: "opentelemetry-auto-instrumentation", State: waiting("ImagePullBackOff")}}}, failing: true},
+		{name: "restarts", status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 3}}}, failing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podFailure(corev1.Pod{Status: tt.status}); (got != "") != tt.failing {
+				t.Errorf("podFailure() = %q, want failing = %v", got, tt.failing)
+			}
+		})
+	}
+}
+
+func TestParseRolloutConfig(t *testing.T) {
+	tests := []struct {
+		name                        string
+		concurrency, timeout, pause string
+		want                        rolloutConfig
+		wantErr                     bool
+	}{
+		{name: "defaults", want: rolloutConfig{concurrency: 1, timeout: 10 * time.Minute, pause: 30 * time.Minute}},
+		{name: "immediate", concurrency: "0", want: rolloutConfig{timeout: 10 * time.Minute, pause: 30 * time.Minute}},
+		{name: "custom", concurrency: " 3 ", timeout: "5m", pause: "1h", want: rolloutConfig{concurrency: 3, timeout: 5 * time.Minute, pause: time.Hour}},
+		{name: "negative concurrency", concurrency: "-1", wantErr: true},
+		{name: "invalid timeout", timeout: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRolloutConfig(tt.concurrency, tt.timeout, tt.pause)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRolloutConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseRolloutConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kloudmate/km-agent/internal/agentconfig"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// scopeCheck decides which namespaces and workloads the updater may report and instrument during
+// one config check. Namespaces are allowed when they are listed or match the namespace selector,
+// or when neither is configured, and excluded namespaces never are. Workloads are read from the
+// informer cache once per check.
+type scopeCheck struct {
+	workloadCache *workloadCache
+	order         []string
+	listed        map[string]bool
+	excluded      map[string]bool
+	nsSel         labels.Selector
+	workloads     labels.Selector
+
+	matched map[string]bool
+	cache   map[string]*workloadMeta
+}
+
+func newScopeCheck(ctx context.Context, client kubernetes.Interface, workloads *workloadCache, s agentconfig.Settings) (*scopeCheck, error) {
+	c := &scopeCheck{
+		workloadCache: workloads,
+		order:         s.MonitoredNamespaces,
+		listed:        toSet(s.MonitoredNamespaces),
+		excluded:      toSet(s.ExcludedNamespaces),
+		cache:         map[string]*workloadMeta{},
+	}
+	var err error
+	if s.NamespaceSelector != nil {
+		if c.nsSel, err = v1.LabelSelectorAsSelector(s.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+	}
+	if s.WorkloadSelector != nil {
+		if c.workloads, err = v1.LabelSelectorAsSelector(s.WorkloadSelector); err != nil {
+			return nil, fmt.Errorf("invalid workload selector: %w", err)
+		}
+	}
+	if c.nsSel != nil {
+		list, err := client.CoreV1().Namespaces().List(ctx, v1.ListOptions{LabelSelector: c.nsSel.String()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces matching %s: %w", c.nsSel, err)
+		}
+		c.matched = make(map[string]bool, len(list.Items))
+		for _, ns := range list.Items {
+			c.matched[ns.Name] = true
+		}
+	}
+	return c, nil
+}
+
+// namespaces returns the namespaces to report as monitored, listed ones first.
+func (c *scopeCheck) namespaces() []string {
+	var out []string
+	for _, ns := range c.order {
+		if !c.excluded[ns] {
+			out = append(out, ns)
+		}
+	}
+	var matched []string
+	for ns := range c.matched {
+		if !c.listed[ns] && !c.excluded[ns] {
+			matched = append(matched, ns)
+		}
+	}
+	sort.Strings(matched)
+	return append(out, matched...)
+}
+
+func (c *scopeCheck) allowsNamespace(ns string) bool {
+	if c.excluded[ns] {
+		return false
+	}
+	if len(c.listed) == 0 && c.nsSel == nil {
+		return true
+	}
+	return c.listed[ns] || c.matched[ns]
+}
+
+// allows reports whether a workload is in scope. Workloads that cannot be read are not.
+func (c *scopeCheck) allows(kind, namespace, name string) (bool, error) {
+	if !c.allowsNamespace(namespace) {
+		return false, nil
+	}
+	if c.workloads == nil {
+		return true, nil
+	}
+	w, err := c.workload(kind, namespace, name)
+	if err != nil {
+		return false, err
+	}
+	return c.workloads.Matches(labels.Set(w.labels)), nil
+}
+
+// workloadMeta holds the labels of a workload and its annotations, pod template annotations
+// overridden by the workload's own.
+type workloadMeta struct {
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// workload returns the labels and annotations of a workload, memoized for the check.
+func (c *scopeCheck) workload(kind, namespace, name string) (*workloadMeta, error) {
+	key := strings.ToUpper(kind) + "/" + namespace + "/" + name
+	if w, ok := c.cache[key]; ok {
+		return w, nil
+	}
+	meta, template, err := c.readWorkload(kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	w := &workloadMeta{labels: meta.Labels, annotations: map[string]string{}}
+	for k, v := range template {
+		w.annotations[k] = v
+	}
+	for k, v := range meta.Annotations {
+		w.annotations[k] = v
+	}
+	c.cache[key] = w
+	return w, nil
+}
+
+func (c *scopeCheck) readWorkload(kind, namespace, name string) (v1.ObjectMeta, map[string]string, error) {
+	w := c.workloadCache
+	switch strings.ToUpper(kind) {
+	case "DAEMONSET":
+		ds, err := w.daemonSets.DaemonSets(namespace).Get(name)
+		if err != nil {
+			return v1.ObjectMeta{}, nil, err
+		}
+		return ds.ObjectMeta, ds.Spec.Template.Annotations, nil
+	case "REPLICASET":
+		rs, err := w.replicaSets.ReplicaSets(namespace).Get(name)
+		if err == nil {
+			return rs.ObjectMeta, rs.Spec.Template.Annotations, nil
+		}
+		if !errors.IsNotFound(err) {
+			return v1.ObjectMeta{}, nil, err
+		}
+		// detections name the parent deployment of a replicaset
+		fallthrough
+	case "DEPLOYMENT":
+		dep, err := w.deployments.Deployments(namespace).Get(name)
+		if err != nil {
+			return v1.ObjectMeta{}, nil, err
+		}
+		return dep.ObjectMeta, dep.Spec.Template.Annotations, nil
+	case "STATEFULSET":
+		ss, err := w.statefulSets.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return v1.ObjectMeta{}, nil, err
+		}
+		return ss.ObjectMeta, ss.Spec.Template.Annotations, nil
+	case "POD":
+		pod, err := w.pods.Pods(namespace).Get(name)
+		if err != nil {
+			return v1.ObjectMeta{}, nil, err
+		}
+		return pod.ObjectMeta, nil, nil
+	default:
+		return v1.ObjectMeta{}, nil, fmt.Errorf("unsupported workload kind %s", kind)
+	}
+}
+
+// parseSelector parses a label selector such as monitoring=enabled,tier notin (test), nil when empty.
+func parseSelector(env, value string) (*v1.LabelSelector, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	sel, err := v1.ParseToLabelSelector(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", env, value, err)
+	}
+	return sel, nil
+}
+
+// splitList splits a comma-separated env value, dropping blanks.
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
@@ -0,0 +1,165 @@
+package updater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kloudmate/km-agent/internal/agentconfig"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newScopeClient returns a cluster with namespaces default, tenant-a, kube-system and payments,
+// each running a Deployment app, labelled team=checkout except in default.
+func newScopeClient() *fake.Clientset {
+	labeled := map[string]string{"monitoring": "enabled"}
+	team := map[string]string{"team": "checkout"}
+	return fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Labels: labeled}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: labeled}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+		&appsv1.Deployment{ObjectMeta: objectMeta("default", "app")},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "app", Labels: team}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "app", Labels: team}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "app", Labels: team}},
+	)
+}
+
+// newTestScope starts a workload cache for client and returns a scope check reading from it.
+func newTestScope(t *testing.T, client *fake.Clientset, settings agentconfig.Settings) *scopeCheck {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	workloads := startWorkloadCache(ctx, client)
+	if err := workloads.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	scope, err := newScopeCheck(ctx, client, workloads, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scope
+}
+
+func TestScopeCheck(t *testing.T) {
+	selector := func(s string) *metav1.LabelSelector {
+		sel, err := metav1.ParseToLabelSelector(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sel
+	}
+	tests := []struct {
+		name       string
+		settings   agentconfig.Settings
+		namespaces []string
+		allowed    []string
+	}{
+		{
+			name:    "everything without a scope",
+			allowed: []string{"default", "tenant-a", "kube-system", "payments"},
+		},
+		{
+			name:       "listed namespaces",
+			settings:   agentconfig.Settings{MonitoredNamespaces: []string{"payments", "default"}},
+			namespaces: []string{"payments", "default"},
+			allowed:    []string{"default", "payments"},
+		},
+		{
+			name:       "listed and selected namespaces",
+			settings:   agentconfig.Settings{MonitoredNamespaces: []string{"default"}, NamespaceSelector: selector("monitoring=enabled")},
+			namespaces: []string{"default", "kube-system", "tenant-a"},
+			allowed:    []string{"default", "tenant-a", "kube-system"},
+		},
+		{
+			name: "exclusions win",
+			settings: agentconfig.Settings{
+				MonitoredNamespaces: []string{"default", "kube-system"},
+				NamespaceSelector:   selector("monitoring=enabled"),
+				ExcludedNamespaces:  []string{"kube-system"},
+			},
+			namespaces: []string{"default", "tenant-a"},
+			allowed:    []string{"default", "tenant-a"},
+		},
+		{
+			name:       "exclusions without a namespace scope",
+			settings:   agentconfig.Settings{ExcludedNamespaces: []string{"kube-system"}},
+			namespaces: nil,
+			allowed:    []string{"default", "tenant-a", "payments"},
+		},
+		{
+			name: "resource exclusions add to the env",
+			settings: (&agentconfig.Spec{ExcludedNamespaces: []string{"payments"}}).Apply(agentconfig.Settings{
+				ExcludedNamespaces: []string{"kube-system"},
+			}),
+			allowed: []string{"default", "tenant-a"},
+		},
+		{
+			name: "workload selector",
+			settings: agentconfig.Settings{
+				MonitoredNamespaces: []string{"default", "payments"},
+				WorkloadSelector:    selector("team=checkout"),
+			},
+			namespaces: []string{"default", "payments"},
+			allowed:    []string{"payments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := newTestScope(t, newScopeClient(), tt.settings)
+			if got := scope.namespaces(); !reflect.DeepEqual(got, tt.namespaces) {
+				t.Errorf("namespaces() = %v, want %v", got, tt.namespaces)
+			}
+			var allowed []string
+			for _, ns := range []string{"default", "tenant-a", "kube-system", "payments"} {
+				if ok, err := scope.allows("Deployment", ns, "app"); err != nil {
+					t.Errorf("allows(%s) error = %v", ns, err)
+				} else if ok {
+					allowed = append(allowed, ns)
+				}
+			}
+			if !reflect.DeepEqual(allowed, tt.allowed) {
+				t.Errorf("allowed workloads in %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestScopeCheckWorkload(t *testing.T) {
+	client := fake.NewClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shop", Annotations: map[string]string{"a": "workload"}},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"a": "template", "b": "template"},
+		}}},
+	})
+	scope := newTestScope(t, client, agentconfig.Settings{})
+	// detections name the parent deployment of a replicaset
+	for _, kind := range []string{"Deployment", "ReplicaSet"} {
+		w, err := scope.workload(kind, "default", "shop")
+		if err != nil {
+			t.Fatalf("workload(%s) error = %v", kind, err)
+		}
+		if want := map[string]string{"a": "workload", "b": "template"}; !reflect.DeepEqual(w.annotations, want) {
+			t.Errorf("workload(%s) annotations = %v, want %v", kind, w.annotations, want)
+		}
+	}
+	if _, err := scope.workload("CronJob", "default", "shop"); err == nil {
+		t.Error("workload() accepted an unsupported kind")
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for value, ok := range map[string]bool{"": true, "monitoring=enabled,tier notin (test)": true, "a in (b": false} {
+		sel, err := parseSelector("KM_K8S_WORKLOAD_SELECTOR", value)
+		if (err == nil) != ok {
+			t.Errorf("parseSelector(%q) error = %v, want ok = %v", value, err, ok)
+		}
+		if value == "" && sel != nil {
+			t.Errorf("parseSelector(%q) = %v, want nil", value, sel)
+		}
+	}
+}
@@ -14,10 +14,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kloudmate/km-agent/internal/agentconfig"
 	"github.com/kloudmate/km-agent/internal/config"
 	"github.com/kloudmate/km-agent/internal/instrumentation"
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/policy"
+	"github.com/kloudmate/km-agent/internal/telemetry"
 	"github.com/kloudmate/km-agent/internal/version"
 	"github.com/kloudmate/km-agent/rpc"
+	"github.com/kloudmate/polylang-detector/detector"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
@@ -33,9 +38,30 @@ type K8sConfigUpdater struct {
 	logger      *zap.SugaredLogger
 	client      *http.Client
 	monitoredNs []string
-	logsEnabled bool
-	apmEnabled  bool
-	configPath  string
+	// namespace and workload scope enforced locally, whatever the server requests
+	namespaceSelector *v1.LabelSelector
+	excludedNs        []string
+	workloadSelector  *v1.LabelSelector
+	logsEnabled       bool
+	apmEnabled        bool
+	configPath        string
+	probe             *telemetry.Probe
+	verifier          *responseVerifier
+	policy            *policy.Policy
+	agentConfig       *agentconfig.Watcher
+	// detections returns the workloads found by the language detector
+	detections func() []detector.ContainerInfo
+	// workloads caches the cluster's workloads, started by the first config check
+	workloads *workloadCache
+	// rollouts rate limits instrumentation restarts, nil patches every workload immediately
+	rollouts *rolloutScheduler
+	// last collector configs received from the server and the overrides applied to them, so
+	// changed KloudMateAgentConfig overrides can be applied without waiting for the server
+	serverConfigs    *K8sOtelConfigs
+	appliedOverrides string
+	// configRejection is the policy violation of the last collector configs, cleared once
+	// configs are applied again
+	configRejection error
 }
 
 type K8sUpdateCheckerParams struct {
@@ -43,6 +69,8 @@ type K8sUpdateCheckerParams struct {
 	CollectorVersion string
 	CollectorStatus  string
 	APMData          []APMConfig
+	// Namespaces are the monitored namespaces, listed and matched by the namespace selector
+	Namespaces []string
 }
 
 type APMConfig struct {
@@ -51,6 +79,14 @@ type APMConfig struct {
 	Kind       string `json:"kind"`
 	Enabled    bool   `json:"enabled"`
 	Language   string `json:"language"`
+	// Override is the workload's kloudmate.io/instrumentation annotation that decided Enabled
+	// and Language, e.g. disabled or java
+	Override string `json:"override,omitempty"`
+	// Instrumentation tunes the injected agent through an Instrumentation resource generated for
+	// the workload, nil uses the shared one
+	Instrumentation *InstrumentationOptions `json:"instrumentation,omitempty"`
+	// Method is how the agent instruments the detected language: operator, ebpf or unsupported
+	Method instrumentation.Method `json:"method,omitempty"`
 }
 
 type K8sOtelConfigs struct {
@@ -68,6 +104,8 @@ type K8sConfigUpdateResponse struct {
 	RestartRequired bool           `json:"restart_required"`
 	K8sAPIConfigs   K8sOtelConfigs `json:"config"`
 	K8s             K8sApmConfig   `json:"k8s"`
+	// LogLevel changes the updater log level, e.g. debug, without a restart
+	LogLevel string `json:"log_level,omitempty"`
 }
 
 type patchData struct {
@@ -75,15 +113,16 @@ type patchData struct {
 }
 
 // NewK8sConfigUpdater creates a new config updater
-func NewKubeConfigUpdaterClient(cfg *config.K8sAgentConfig, logger *zap.SugaredLogger) *K8sConfigUpdater {
-	monitoredEnv := string(os.Getenv("KM_K8S_MONITORED_NAMESPACES"))
-	var monitoredNs []string
-	if monitoredEnv != "" {
-		monitoredNs = strings.Split(monitoredEnv, ",")
-		// Trim whitespace from each namespace
-		for i := range monitoredNs {
-			monitoredNs[i] = strings.TrimSpace(monitoredNs[i])
-		}
+func NewKubeConfigUpdaterClient(cfg *config.K8sAgentConfig, logger *zap.SugaredLogger) (*K8sConfigUpdater, error) {
+	monitoredNs := splitList(os.Getenv("KM_K8S_MONITORED_NAMESPACES"))
+	excludedNs := splitList(os.Getenv("KM_K8S_EXCLUDED_NAMESPACES"))
+	namespaceSelector, err := parseSelector("KM_K8S_NAMESPACE_SELECTOR", os.Getenv("KM_K8S_NAMESPACE_SELECTOR"))
+	if err != nil {
+		return nil, err
+	}
+	workloadSelector, err := parseSelector("KM_K8S_WORKLOAD_SELECTOR", os.Getenv("KM_K8S_WORKLOAD_SELECTOR"))
+	if err != nil {
+		return nil, err
 	}
 	logsval, present := os.LookupEnv("KM_LOGS_ENABLED")
 	if !present {
@@ -105,27 +144,61 @@ func NewKubeConfigUpdaterClient(cfg *config.K8sAgentConfig, logger *zap.SugaredL
 		fmt.Printf("Error parsing boolean value for KM_APM_ENABLED: %v\n", err)
 	}
 
+	rolloutCfg, err := parseRolloutConfig(os.Getenv("KM_APM_ROLLOUT_CONCURRENCY"), os.Getenv("KM_APM_ROLLOUT_TIMEOUT"), os.Getenv("KM_APM_ROLLOUT_PAUSE"))
+	if err != nil {
+		return nil, err
+	}
+	var rollouts *rolloutScheduler
+	if rolloutCfg.concurrency > 0 {
+		rollouts = newRolloutScheduler(cfg.K8sClient, logger, rolloutCfg)
+	}
+
+	transport, err := cfg.Network.NewTransport()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure control plane transport: %w", err)
+	}
+	transport.DialContext = (&net.Dialer{Timeout: 5 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 5 * time.Second
+	transport.ResponseHeaderTimeout = 5 * time.Second
+
+	verifier, err := loadVerifier(cfg.ConfigPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	p, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
 	logger.Infof("Monitored Namespaces : [%s]\n", strings.Join(monitoredNs, ", "))
+	if namespaceSelector != nil || len(excludedNs) > 0 || workloadSelector != nil {
+		logger.Infof("Namespace selector : %q, excluded namespaces : [%s], workload selector : %q",
+			os.Getenv("KM_K8S_NAMESPACE_SELECTOR"), strings.Join(excludedNs, ", "), os.Getenv("KM_K8S_WORKLOAD_SELECTOR"))
+	}
 	return &K8sConfigUpdater{
-		cfg:         cfg,
-		logger:      logger,
-		monitoredNs: monitoredNs,
-		logsEnabled: logsBoolVal,
-		apmEnabled:  apmBoolVal,
+		cfg:               cfg,
+		logger:            logger,
+		monitoredNs:       monitoredNs,
+		namespaceSelector: namespaceSelector,
+		excludedNs:        excludedNs,
+		workloadSelector:  workloadSelector,
+		rollouts:          rollouts,
+		logsEnabled:       logsBoolVal,
+		apmEnabled:        apmBoolVal,
+		verifier:          verifier,
+		policy:            p,
+		detections:        rpc.GetDetectionResults,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
-				TLSHandshakeTimeout:   5 * time.Second,
-				ResponseHeaderTimeout: 5 * time.Second,
-			},
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
-	}
+	}, nil
 }
 
 // CheckForUpdates checks for configuration updates from the remote API
 func (u *K8sConfigUpdater) CheckForUpdatesK8s(ctx context.Context, p K8sUpdateCheckerParams) (updateResp K8sConfigUpdateResponse, err error) {
 
+	settings := u.settings()
 	// Create the request
 	data := map[string]interface{}{
 		"architecture":      runtime.GOARCH,
@@ -134,10 +207,18 @@ func (u *K8sConfigUpdater) CheckForUpdatesK8s(ctx context.Context, p K8sUpdateCh
 		"k8s_deployments":   p.APMData,
 		"collector_version": version.GetCollectorVersion(),
 		"agent_version":     p.Version,
-		"logs_enabled":      u.logsEnabled,
-		"apm_enabled":       u.apmEnabled,
-		"namespaces":        u.monitoredNs,
+		"logs_enabled":      settings.LogsEnabled,
+		"apm_enabled":       settings.APMEnabled,
+		"namespaces":        p.Namespaces,
 		"collector_status":  p.CollectorStatus,
+		"log_level":         kmlogger.Level().String(),
+	}
+	if rollout := u.rollouts.status(); rollout != nil {
+		data["apm_rollout"] = rollout
+	}
+	challenge := u.verifier.challenge(u.cfg.ClusterName)
+	if challenge != nil {
+		data["nonce"] = challenge.nonce
 	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -154,8 +235,8 @@ func (u *K8sConfigUpdater) CheckForUpdatesK8s(ctx context.Context, p K8sUpdateCh
 
 	req.Header.Set("Content-Type", "application/json")
 	// Add API key if configured
-	if u.cfg.APIKey != "" {
-		req.Header.Set("Authorization", u.cfg.APIKey)
+	if apiKey := u.cfg.CurrentAPIKey(); apiKey != "" {
+		req.Header.Set("Authorization", apiKey)
 	}
 
 	resp, respErr := u.client.Do(req)
@@ -171,8 +252,13 @@ func (u *K8sConfigUpdater) CheckForUpdatesK8s(ctx context.Context, p K8sUpdateCh
 		return K8sConfigUpdateResponse{}, fmt.Errorf("config update API returned non-OK status: %d, body: %s", resp.StatusCode, body)
 	}
 
+	body, err := readVerifiedBody(resp, u.verifier, challenge)
+	if err != nil {
+		return K8sConfigUpdateResponse{}, err
+	}
+
 	// Parse response
-	if err := json.NewDecoder(resp.Body).Decode(&updateResp); err != nil {
+	if err := json.Unmarshal(body, &updateResp); err != nil {
 		return K8sConfigUpdateResponse{}, fmt.Errorf("failed to decode config update response: %w", err)
 	}
 
@@ -190,20 +276,26 @@ func (a *K8sConfigUpdater) StartConfigUpdateChecker(ctx context.Context) {
 		a.logger.Info("Config update URL parse error, falling back to default value")
 		parsedTime = time.Duration(time.Second * 30)
 	}
+	if a.probe != nil {
+		// allow a few missed iterations before the loop is reported as wedged
+		a.probe.SetStaleAfter(3*parsedTime + time.Minute)
+	}
 	ticker := time.NewTicker(parsedTime)
 	defer ticker.Stop()
+	if a.rollouts != nil {
+		go a.rollouts.run(ctx)
+	}
 
 	// trigger the very first config check
-	if err := a.performConfigCheck(ctx); err != nil {
-		a.logger.Errorf("Periodic config check failed: %v", err)
-	}
+	a.runConfigCheck(ctx)
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := a.performConfigCheck(ctx); err != nil {
-				a.logger.Errorf("Periodic config check failed: %v", err)
-			}
+			a.runConfigCheck(ctx)
+		case <-a.agentConfig.Changed():
+			a.logger.Infof("%s changed, checking for updates", agentconfig.Kind)
+			a.runConfigCheck(ctx)
 		case <-a.cfg.StopCh:
 			a.logger.Info("Config update checker stopping due to shutdown.")
 			return
@@ -214,23 +306,60 @@ func (a *K8sConfigUpdater) StartConfigUpdateChecker(ctx context.Context) {
 	}
 }
 
+// runConfigCheck performs a config check and records its outcome for self-metrics and health probes.
+func (a *K8sConfigUpdater) runConfigCheck(ctx context.Context) {
+	err := a.performConfigCheck(ctx)
+	a.reportAgentConfigStatus(ctx, err)
+	telemetry.ObserveConfigCheck(err)
+	if a.probe != nil {
+		a.probe.ObserveIteration(err)
+	}
+	if err != nil {
+		a.logger.Errorf("Periodic config check failed: %v", err)
+	}
+}
+
 // performConfigCheck checks remote server for new config and restart collector if required
 func (a *K8sConfigUpdater) performConfigCheck(agentCtx context.Context) error {
 	ctx, cancel := context.WithTimeout(agentCtx, 15*time.Second)
 	defer cancel()
 
 	a.logger.Infoln("Checking for configuration updates...")
+	if a.workloads == nil {
+		a.workloads = startWorkloadCache(agentCtx, a.cfg.K8sClient)
+	}
+	if err := a.workloads.wait(ctx); err != nil {
+		return err
+	}
+	scope, err := newScopeCheck(ctx, a.cfg.K8sClient, a.workloads, a.settings())
+	if err != nil {
+		return err
+	}
 	apmData := []APMConfig{}
-	results := rpc.GetDetectionResults()
+	results := a.detections()
 	a.logger.Infoln("available apps for instrumentation : %d", len(results))
 	for _, info := range results {
-		apmData = append(apmData, APMConfig{
+		if allowed, err := scope.allows(info.Kind, info.Namespace, info.DeploymentName); !allowed {
+			a.logger.Debugf("not reporting %s/%s outside the monitored scope (%v)", info.Namespace, info.DeploymentName, err)
+			continue
+		}
+		app := APMConfig{
 			Namespace:  info.Namespace,
 			Deployment: info.DeploymentName,
 			Kind:       info.Kind,
 			Language:   info.Language,
 			Enabled:    info.Enabled,
-		})
+		}
+		a.applyOverride(scope, &app)
+		lang, known := instrumentation.LookupLanguage(app.Language)
+		if known {
+			app.Language = lang.Name
+		}
+		app.Method = lang.Method
+		if lang.Method == instrumentation.MethodUnsupported {
+			a.logger.Debugf("[APM]: %s/%s uses %q which cannot be instrumented", app.Namespace, app.Deployment, app.Language)
+		}
+		apmData = append(apmData, app)
 	}
 	bites, _ := json.Marshal(apmData)
 	a.logger.Info(string(bites))
@@ -239,6 +368,7 @@ func (a *K8sConfigUpdater) performConfigCheck(agentCtx context.Context) error {
 		CollectorVersion: version.GetCollectorVersion(),
 		CollectorStatus:  "Running",
 		APMData:          apmData,
+		Namespaces:       scope.namespaces(),
 	}
 
 	a.logger.Debugf("Checking for updates with params: %+v", params)
@@ -247,30 +377,66 @@ func (a *K8sConfigUpdater) performConfigCheck(agentCtx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("updater.CheckForUpdates failed: %w", err)
 	}
+	if changed, err := kmlogger.ApplyRemoteLevel(updateResp.LogLevel); err != nil {
+		a.logger.Warnw("ignoring log level from config check", "error", err)
+	} else if changed {
+		a.logger.Infow("log level changed by control plane", "level", updateResp.LogLevel)
+	}
+	serverConfigs := a.serverConfigs
 	if updateResp.K8sAPIConfigs.DaemonSetConfig != nil && updateResp.K8sAPIConfigs.DeploymentConfig != nil && updateResp.RestartRequired {
-
-		if err := a.UpdateConfigMap(updateResp.K8sAPIConfigs.DaemonSetConfig, updateResp.K8sAPIConfigs.DeploymentConfig); err != nil {
-			return fmt.Errorf("failed to update configMap: %w", err)
+		serverConfigs = &updateResp.K8sAPIConfigs
+	}
+	spec := a.agentConfigSpec()
+	overrides := overridesKey(spec)
+	var configErr error
+	if serverConfigs != nil && (serverConfigs != a.serverConfigs || overrides != a.appliedOverrides) {
+		daemonSetConfig, deploymentConfig := serverConfigs.DaemonSetConfig, serverConfigs.DeploymentConfig
+		if spec != nil {
+			daemonSetConfig = agentconfig.Merge(daemonSetConfig, spec.CollectorOverrides.DaemonSet)
+			deploymentConfig = agentconfig.Merge(deploymentConfig, spec.CollectorOverrides.Deployment)
 		}
-		a.logger.Infoln("triggering rollout restart.")
+		err := a.UpdateConfigMap(daemonSetConfig, deploymentConfig)
+		telemetry.ObserveConfigMapUpdate(err)
+		switch {
+		case policy.IsViolation(err):
+			// the ConfigMaps keep the last config that passed, APM settings are still applied
+			a.configRejection = err
+			a.logger.Errorf("keeping the current collector configs: %v", err)
+		case err != nil:
+			configErr = fmt.Errorf("failed to update configMap: %w", err)
+		default:
+			a.serverConfigs, a.appliedOverrides, a.configRejection = serverConfigs, overrides, nil
+			a.logger.Infoln("triggering rollout restart.")
 
-		if err = a.triggerDaemonSetRollout(agentCtx); err != nil {
-			a.logger.Errorln(err)
-		}
-		if err = a.triggerDeploymentRollout(agentCtx); err != nil {
-			a.logger.Errorln(err)
+			err = a.triggerDaemonSetRollout(agentCtx)
+			telemetry.ObserveRollout("daemonset", err)
+			if err != nil {
+				a.logger.Errorln(err)
+			}
+			err = a.triggerDeploymentRollout(agentCtx)
+			telemetry.ObserveRollout("deployment", err)
+			if err != nil {
+				a.logger.Errorln(err)
+			}
 		}
-
 	} else {
 		a.logger.Infoln("No configuration change detected for the agent")
 	}
-	if err := a.performAPMUpdation(ctx, &updateResp); err != nil {
+	updateResp.K8s.APMSettings = a.applyOverrides(scope, updateResp.K8s.APMSettings, apmData)
+	if err := a.performAPMUpdation(ctx, &updateResp, scope); err != nil {
 		a.logger.Errorln(err)
 	}
-	return nil
+	return configErr
 }
 
 func (a *K8sConfigUpdater) UpdateConfigMap(daemonSetConfig map[string]interface{}, deploymentConfig map[string]interface{}) error {
+	if err := a.policy.Evaluate(daemonSetConfig); err != nil {
+		return fmt.Errorf("policy check for DaemonSet otel-config: %w", err)
+	}
+	if err := a.policy.Evaluate(deploymentConfig); err != nil {
+		return fmt.Errorf("policy check for Deployment otel-config: %w", err)
+	}
+
 	daemonSetYamlBytes, err := yaml.Marshal(daemonSetConfig)
 	if err != nil {
 		return fmt.Errorf("marshal error for DaemonSet otel-config: %w", err)
@@ -403,9 +569,10 @@ func (drt *K8sConfigUpdater) triggerDeploymentRollout(ctx context.Context) error
 	return nil
 }
 
-func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8sConfigUpdateResponse) error {
+func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8sConfigUpdateResponse, scope *scopeCheck) error {
 
-	if !response.K8s.APMEnabled {
+	spec := a.agentConfigSpec()
+	if !response.K8s.APMEnabled || spec.APMDisabled() {
 		a.logger.Infof("Apm is not enabled for  %s", a.cfg.ClusterName)
 		return nil
 	}
@@ -414,8 +581,20 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 		if !app.Enabled {
 			continue
 		}
+		if !spec.AllowsInstrumentation(app.Namespace, app.Language) {
+			a.logger.Infof("[APM]: %s/%s using %s not instrumented, denied by %s", app.Namespace, app.Deployment, app.Language, agentconfig.Kind)
+			continue
+		}
+		if !a.inScope(scope, app) || !a.usesOperator(app) {
+			continue
+		}
 		kind := strings.ToUpper(app.Kind)
-		annotations, langAnnotation := instrumentation.KmCrdAnnotation(app.Language, app.Enabled)
+		ref, changed, err := a.ensureInstrumentation(ctx, app)
+		if err != nil {
+			// the workload is still instrumented, with the shared options
+			a.logger.Warnf("[APM]: %v", err)
+		}
+		annotations, langAnnotation := instrumentation.KmCrdAnnotationRef(app.Language, app.Enabled, ref)
 		annotationBytes, err := json.Marshal(annotations)
 		if err != nil {
 			return fmt.Errorf("error marshaling patch %s/%s: %v", app.Namespace, app.Deployment, err)
@@ -427,12 +606,11 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			if err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
-			if isApplied := isAnnotationSame(langAnnotation, ds.Spec.Template.Annotations); isApplied {
+			if isApplied := !changed && isAnnotationSame(langAnnotation, ds.Spec.Template.Annotations); isApplied {
 				a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 				continue
 			}
-			_, err = a.cfg.K8sClient.AppsV1().DaemonSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, annotationBytes, v1.PatchOptions{})
-			if err != nil {
+			if err := a.instrument(ctx, "daemonset", app, annotationBytes, langAnnotation); err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
 		case "REPLICASET":
@@ -445,24 +623,24 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 					if err != nil {
 						return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 					}
-					if isApplied := isAnnotationSame(langAnnotation, dep.Spec.Template.Annotations); isApplied {
+					if isApplied := !changed && isAnnotationSame(langAnnotation, dep.Spec.Template.Annotations); isApplied {
 						a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 						continue
 					} else {
-						if err := handleDeploymentPatching(ctx, a.cfg.K8sClient, app, annotationBytes); err != nil {
+						if err := a.instrument(ctx, "deployment", app, annotationBytes, langAnnotation); err != nil {
 							return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 						}
+						continue
 					}
 				}
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				// err is nil means replicaset exist and patch can be applied on it
-				if isApplied := isAnnotationSame(langAnnotation, rs.Spec.Template.Annotations); isApplied {
+				if isApplied := !changed && isAnnotationSame(langAnnotation, rs.Spec.Template.Annotations); isApplied {
 					a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 					continue
 				}
-				_, err = a.cfg.K8sClient.AppsV1().ReplicaSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, annotationBytes, v1.PatchOptions{})
-				if err != nil {
+				if err := a.instrument(ctx, "replicaset", app, annotationBytes, langAnnotation); err != nil {
 					return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Deployment, app.Deployment, err)
 				}
 			}
@@ -472,11 +650,11 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			if err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
-			if isApplied := isAnnotationSame(langAnnotation, ds.Spec.Template.Annotations); isApplied {
+			if isApplied := !changed && isAnnotationSame(langAnnotation, ds.Spec.Template.Annotations); isApplied {
 				a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 				continue
 			}
-			if err := handleDeploymentPatching(ctx, a.cfg.K8sClient, app, annotationBytes); err != nil {
+			if err := a.instrument(ctx, "deployment", app, annotationBytes, langAnnotation); err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
 		case "STATEFULSET":
@@ -484,12 +662,11 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			if err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
-			if isApplied := isAnnotationSame(langAnnotation, ss.Spec.Template.Annotations); isApplied {
+			if isApplied := !changed && isAnnotationSame(langAnnotation, ss.Spec.Template.Annotations); isApplied {
 				a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 				continue
 			}
-			_, err = a.cfg.K8sClient.AppsV1().StatefulSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, annotationBytes, v1.PatchOptions{})
-			if err != nil {
+			if err := a.instrument(ctx, "statefulset", app, annotationBytes, langAnnotation); err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
 
@@ -498,12 +675,11 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			if err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
-			if isApplied := isAnnotationSame(langAnnotation, pod.Annotations); isApplied {
+			if isApplied := !changed && isAnnotationSame(langAnnotation, pod.Annotations); isApplied {
 				a.logger.Infof("[APM]: annotation for : %s using %s of kind : %s already applied", app.Deployment, app.Language, app.Kind)
 				continue
 			}
-			_, err = a.cfg.K8sClient.CoreV1().Pods(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, annotationBytes, v1.PatchOptions{})
-			if err != nil {
+			if err := a.instrument(ctx, "pod", app, annotationBytes, langAnnotation); err != nil {
 				return fmt.Errorf("error applying auto instrumentation on %s/%s: %v", app.Namespace, app.Deployment, err)
 			}
 		default:
@@ -516,7 +692,15 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 		if app.Enabled {
 			continue
 		}
+		if !a.inScope(scope, app) || instrumentation.LanguageFor(app.Language).Method != instrumentation.MethodOperator {
+			continue
+		}
 		kind := strings.ToUpper(app.Kind)
+		// a disabled workload may be instrumented again later, even after a revert
+		a.rollouts.forget(ctx, strings.ToLower(kind), app)
+		if kind == "REPLICASET" {
+			a.rollouts.forget(ctx, "deployment", app)
+		}
 		_, langAnnotation := instrumentation.KmCrdAnnotation(app.Language, app.Enabled)
 
 		// Build a patch to remove the annotations
@@ -545,13 +729,16 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 			if !hasAnyAnnotation(langAnnotation, ds.Spec.Template.Annotations) {
 				a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+				a.deleteInstrumentation(ctx, app)
 				continue
 			}
 			_, err = a.cfg.K8sClient.AppsV1().DaemonSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, removePatchBytes, v1.PatchOptions{})
+			telemetry.ObserveAPMPatch("daemonset", app.Language, err)
 			if err != nil {
 				a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				a.logger.Infof("[APM]: successfully removed instrumentation from DaemonSet %s/%s", app.Namespace, app.Deployment)
+				a.deleteInstrumentation(ctx, app)
 			}
 
 		case "REPLICASET":
@@ -566,12 +753,14 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 					}
 					if !hasAnyAnnotation(langAnnotation, dep.Spec.Template.Annotations) {
 						a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+						a.deleteInstrumentation(ctx, app)
 						continue
 					}
 					if err := handleDeploymentRemoval(ctx, a.cfg.K8sClient, app, removePatchBytes); err != nil {
 						a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 					} else {
 						a.logger.Infof("[APM]: successfully removed instrumentation from Deployment %s/%s", app.Namespace, app.Deployment)
+						a.deleteInstrumentation(ctx, app)
 					}
 				} else {
 					a.logger.Warnf("[APM]: error getting ReplicaSet %s/%s for removal: %v", app.Namespace, app.Deployment, err)
@@ -580,13 +769,16 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 			if !hasAnyAnnotation(langAnnotation, rs.Spec.Template.Annotations) {
 				a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+				a.deleteInstrumentation(ctx, app)
 				continue
 			}
 			_, err = a.cfg.K8sClient.AppsV1().ReplicaSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, removePatchBytes, v1.PatchOptions{})
+			telemetry.ObserveAPMPatch("replicaset", app.Language, err)
 			if err != nil {
 				a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				a.logger.Infof("[APM]: successfully removed instrumentation from ReplicaSet %s/%s", app.Namespace, app.Deployment)
+				a.deleteInstrumentation(ctx, app)
 			}
 
 		case "DEPLOYMENT":
@@ -597,12 +789,14 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 			if !hasAnyAnnotation(langAnnotation, dep.Spec.Template.Annotations) {
 				a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+				a.deleteInstrumentation(ctx, app)
 				continue
 			}
 			if err := handleDeploymentRemoval(ctx, a.cfg.K8sClient, app, removePatchBytes); err != nil {
 				a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				a.logger.Infof("[APM]: successfully removed instrumentation from Deployment %s/%s", app.Namespace, app.Deployment)
+				a.deleteInstrumentation(ctx, app)
 			}
 
 		case "STATEFULSET":
@@ -613,13 +807,16 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 			if !hasAnyAnnotation(langAnnotation, ss.Spec.Template.Annotations) {
 				a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+				a.deleteInstrumentation(ctx, app)
 				continue
 			}
 			_, err = a.cfg.K8sClient.AppsV1().StatefulSets(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, removePatchBytes, v1.PatchOptions{})
+			telemetry.ObserveAPMPatch("statefulset", app.Language, err)
 			if err != nil {
 				a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				a.logger.Infof("[APM]: successfully removed instrumentation from StatefulSet %s/%s", app.Namespace, app.Deployment)
+				a.deleteInstrumentation(ctx, app)
 			}
 
 		case "POD":
@@ -630,6 +827,7 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 			if !hasAnyAnnotation(langAnnotation, pod.Annotations) {
 				a.logger.Infof("[APM]: annotation for %s using %s of kind %s already removed or not present", app.Deployment, app.Language, app.Kind)
+				a.deleteInstrumentation(ctx, app)
 				continue
 			}
 
@@ -646,10 +844,12 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 			}
 
 			_, err = a.cfg.K8sClient.CoreV1().Pods(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, podRemovePatchBytes, v1.PatchOptions{})
+			telemetry.ObserveAPMPatch("pod", app.Language, err)
 			if err != nil {
 				a.logger.Errorf("[APM]: error removing auto instrumentation from %s/%s: %v", app.Namespace, app.Deployment, err)
 			} else {
 				a.logger.Infof("[APM]: successfully removed instrumentation from Pod %s/%s", app.Namespace, app.Deployment)
+				a.deleteInstrumentation(ctx, app)
 			}
 
 		default:
@@ -660,6 +860,54 @@ func (a *K8sConfigUpdater) performAPMUpdation(ctx context.Context, response *K8s
 	return nil
 }
 
+// instrument applies an instrumentation patch, through the rollout scheduler when one is configured.
+// The Instrumentation generated for a workload without options is deleted once the patch pointing
+// the workload at the shared one is applied.
+func (a *K8sConfigUpdater) instrument(ctx context.Context, kind string, app APMConfig, patch []byte, annotations map[string]string) error {
+	var applied func(context.Context)
+	if app.Instrumentation == nil {
+		applied = func(ctx context.Context) { a.deleteInstrumentation(ctx, app) }
+	}
+	if a.rollouts == nil {
+		if err := patchWorkload(ctx, a.cfg.K8sClient, kind, app, patch); err != nil {
+			return err
+		}
+		if applied != nil {
+			applied(ctx)
+		}
+		return nil
+	}
+	if reason := a.rollouts.enqueue(rolloutTask{kind: kind, app: app, patch: patch, annotations: annotations, applied: applied}); reason != "" {
+		a.logger.Warnf("[APM]: not instrumenting %s/%s: %s", app.Namespace, app.Deployment, reason)
+	}
+	return nil
+}
+
+// inScope reports whether the updater may patch the workload of app, logging refusals.
+func (a *K8sConfigUpdater) inScope(scope *scopeCheck, app APMConfig) bool {
+	allowed, err := scope.allows(app.Kind, app.Namespace, app.Deployment)
+	if err != nil {
+		a.logger.Warnf("[APM]: not patching %s/%s, scope check failed: %v", app.Namespace, app.Deployment, err)
+	} else if !allowed {
+		a.logger.Warnf("[APM]: refusing to patch %s/%s outside the monitored scope", app.Namespace, app.Deployment)
+	}
+	return allowed
+}
+
+// usesOperator reports whether app is instrumented by patching its inject annotation. Languages
+// covered by the eBPF receiver and unsupported ones are left alone.
+func (a *K8sConfigUpdater) usesOperator(app APMConfig) bool {
+	switch instrumentation.LanguageFor(app.Language).Method {
+	case instrumentation.MethodOperator:
+		return true
+	case instrumentation.MethodEBPF:
+		a.logger.Infof("[APM]: %s/%s using %s is instrumented by the eBPF receiver, not patching", app.Namespace, app.Deployment, app.Language)
+	default:
+		a.logger.Warnf("[APM]: %s/%s using %q cannot be instrumented, not patching", app.Namespace, app.Deployment, app.Language)
+	}
+	return false
+}
+
 func isAnnotationSame(annotations map[string]string, resourceMap map[string]string) bool {
 	const restartedAtKey = "kubectl.kubernetes.io/restartedAt"
 	for key, value := range annotations {
@@ -676,16 +924,18 @@ func isAnnotationSame(annotations map[string]string, resourceMap map[string]stri
 	return true
 }
 
-func handleDeploymentPatching(ctx context.Context, client *kubernetes.Clientset, app APMConfig, annotations []byte) error {
+func handleDeploymentPatching(ctx context.Context, client kubernetes.Interface, app APMConfig, annotations []byte) error {
 	_, err := client.AppsV1().Deployments(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, annotations, v1.PatchOptions{})
+	telemetry.ObserveAPMPatch("deployment", app.Language, err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func handleDeploymentRemoval(ctx context.Context, client *kubernetes.Clientset, app APMConfig, removePatch []byte) error {
+func handleDeploymentRemoval(ctx context.Context, client kubernetes.Interface, app APMConfig, removePatch []byte) error {
 	_, err := client.AppsV1().Deployments(app.Namespace).Patch(ctx, app.Deployment, types.StrategicMergePatchType, removePatch, v1.PatchOptions{})
+	telemetry.ObserveAPMPatch("deployment", app.Language, err)
 	if err != nil {
 		return err
 	}
@@ -725,3 +975,68 @@ func (c *K8sConfigUpdater) otelConfigPath() string {
 func (c *K8sConfigUpdater) SetConfigPath() {
 	c.configPath = c.otelConfigPath()
 }
+
+// SetAgentConfig makes the updater merge the watched KloudMateAgentConfig with its settings.
+func (c *K8sConfigUpdater) SetAgentConfig(w *agentconfig.Watcher) {
+	c.agentConfig = w
+}
+
+// agentConfigSpec returns the spec of the watched KloudMateAgentConfig, nil without one.
+func (c *K8sConfigUpdater) agentConfigSpec() *agentconfig.Spec {
+	if cfg := c.agentConfig.Current(); cfg != nil {
+		return &cfg.Spec
+	}
+	return nil
+}
+
+// settings returns the env settings with the KloudMateAgentConfig applied.
+func (c *K8sConfigUpdater) settings() agentconfig.Settings {
+	return c.agentConfigSpec().Apply(agentconfig.Settings{
+		MonitoredNamespaces: c.monitoredNs,
+		NamespaceSelector:   c.namespaceSelector,
+		ExcludedNamespaces:  c.excludedNs,
+		WorkloadSelector:    c.workloadSelector,
+		LogsEnabled:         c.logsEnabled,
+		APMEnabled:          c.apmEnabled,
+	})
+}
+
+// reportAgentConfigStatus records the outcome of a config check on the KloudMateAgentConfig.
+func (c *K8sConfigUpdater) reportAgentConfigStatus(ctx context.Context, checkErr error) {
+	cfg := c.agentConfig.Current()
+	if cfg == nil {
+		return
+	}
+	settings := c.settings()
+	status := agentconfig.Status{
+		ObservedGeneration:  cfg.Generation,
+		Phase:               agentconfig.PhaseApplied,
+		MonitoredNamespaces: settings.MonitoredNamespaces,
+		LogsEnabled:         settings.LogsEnabled,
+		APMEnabled:          settings.APMEnabled,
+	}
+	if checkErr != nil {
+		status.Phase, status.Message = agentconfig.PhaseFailed, checkErr.Error()
+	} else if c.configRejection != nil {
+		status.Phase, status.Message = agentconfig.PhaseFailed, c.configRejection.Error()
+	} else if c.serverConfigs == nil && overridesKey(&cfg.Spec) != "" {
+		status.Message = "collector overrides are applied with the next config from the server"
+	}
+	if err := c.agentConfig.UpdateStatus(ctx, status); err != nil {
+		c.logger.Warnf("failed to report %s status: %v", agentconfig.Kind, err)
+	}
+}
+
+// overridesKey identifies the collector overrides of spec, empty when there are none.
+func overridesKey(spec *agentconfig.Spec) string {
+	if spec == nil || (len(spec.CollectorOverrides.DaemonSet) == 0 && len(spec.CollectorOverrides.Deployment) == 0) {
+		return ""
+	}
+	key, _ := json.Marshal(spec.CollectorOverrides)
+	return string(key)
+}
+
+// SetProbe attaches a health probe that is fed by every config check iteration.
+func (c *K8sConfigUpdater) SetProbe(p *telemetry.Probe) {
+	c.probe = p
+}
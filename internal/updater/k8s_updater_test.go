@@ -0,0 +1,309 @@
+package updater
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/instrumentation"
+	"github.com/kloudmate/km-agent/internal/kmtest"
+	"github.com/kloudmate/km-agent/internal/policy"
+	"github.com/kloudmate/polylang-detector/detector"
+	"go.uber.org/zap/zaptest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace   = "km-agent"
+	testAPIKey      = "test-api-key"
+	restartedAtKey  = "kubectl.kubernetes.io/restartedAt"
+	javaInjectKey   = "instrumentation.opentelemetry.io/inject-java"
+	javaInjectValue = "km-agent/km-agent-instrumentation-crd"
+)
+
+func objectMeta(namespace, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: namespace, Name: name}
+}
+
+// newTestUpdater returns an updater for a fake cluster running the agent DaemonSet and Deployment
+// and an application Deployment "shop" in the default namespace.
+func newTestUpdater(t *testing.T, cp *kmtest.ControlPlane, shopAnnotations map[string]string) (*K8sConfigUpdater, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewClientset(
+		&corev1.ConfigMap{ObjectMeta: objectMeta(testNamespace, "km-agent-configmap-daemonset"), Data: map[string]string{"agent-daemonset.yaml": "{}"}},
+		&corev1.ConfigMap{ObjectMeta: objectMeta(testNamespace, "km-agent-configmap-deployment"), Data: map[string]string{"agent-deployment.yaml": "{}", "entrypoint.sh": "keep"}},
+		&appsv1.DaemonSet{ObjectMeta: objectMeta(testNamespace, "km-agent")},
+		&appsv1.Deployment{ObjectMeta: objectMeta(testNamespace, "km-agent-cluster")},
+		&appsv1.Deployment{
+			ObjectMeta: objectMeta("default", "shop"),
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: shopAnnotations},
+			}},
+		},
+	)
+	t.Setenv("KM_APM_ENABLED", "true")
+	t.Setenv("KM_LOGS_ENABLED", "true")
+	t.Setenv("KM_K8S_MONITORED_NAMESPACES", "default")
+	// patch immediately, the rollout scheduler has its own test
+	t.Setenv("KM_APM_ROLLOUT_CONCURRENCY", "0")
+	if err := config.LoadSecrets(testAPIKey, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewKubeConfigUpdaterClient(&config.K8sAgentConfig{
+		K8sClient:               client,
+		Version:                 "test",
+		ConfigUpdateURL:         cp.URL(),
+		APIKey:                  testAPIKey,
+		KubeNamespace:           testNamespace,
+		ClusterName:             "test-cluster",
+		ConfigmapDaemonsetName:  "km-agent-configmap-daemonset",
+		ConfigmapDeploymentName: "km-agent-configmap-deployment",
+		DaemonSetName:           "km-agent",
+		DeploymentName:          "km-agent-cluster",
+	}, zaptest.NewLogger(t).Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.detections = func() []detector.ContainerInfo { return nil }
+	return u, client
+}
+
+func TestK8sConfigUpdaterAppliesConfigAndInstrumentation(t *testing.T) {
+	cp := kmtest.NewControlPlane(t)
+	cp.RequireAPIKey(testAPIKey)
+	u, client := newTestUpdater(t, cp, nil)
+	cp.Respond(K8sConfigUpdateResponse{
+		RestartRequired: true,
+		K8sAPIConfigs: K8sOtelConfigs{
+			DaemonSetConfig:  map[string]interface{}{"receivers": map[string]interface{}{"kubeletstats": map[string]interface{}{}}},
+			DeploymentConfig: map[string]interface{}{"receivers": map[string]interface{}{"k8s_cluster": map[string]interface{}{}}},
+		},
+		K8s: K8sApmConfig{APMEnabled: true, APMSettings: []APMConfig{
+			{Namespace: "default", Deployment: "shop", Kind: "Deployment", Enabled: true, Language: "Java"},
+		}},
+	})
+
+	ctx := context.Background()
+	if err := u.performConfigCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	req := cp.WaitForRequest(time.Second, nil)
+	if got := req.Status("platform"); got != "k8s" {
+		t.Errorf("platform = %q, want k8s", got)
+	}
+	if got := req.Status("hostname"); got != "test-cluster" {
+		t.Errorf("hostname = %q, want the cluster name", got)
+	}
+	if req.Body["apm_enabled"] != true || req.Body["logs_enabled"] != true {
+		t.Errorf("feature flags not reported: %s", req.Raw)
+	}
+
+	ds, err := client.CoreV1().ConfigMaps(testNamespace).Get(ctx, "km-agent-configmap-daemonset", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ds.Data["agent-daemonset.yaml"], "kubeletstats") {
+		t.Errorf("DaemonSet configmap not updated: %v", ds.Data)
+	}
+	dep, err := client.CoreV1().ConfigMaps(testNamespace).Get(ctx, "km-agent-configmap-deployment", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dep.Data["agent-deployment.yaml"], "k8s_cluster") || dep.Data["entrypoint.sh"] != "keep" {
+		t.Errorf("Deployment configmap not merged: %v", dep.Data)
+	}
+
+	daemonSet, err := client.AppsV1().DaemonSets(testNamespace).Get(ctx, "km-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daemonSet.Spec.Template.Annotations[restartedAtKey] == "" {
+		t.Error("DaemonSet rollout not triggered")
+	}
+	deployment, err := client.AppsV1().Deployments(testNamespace).Get(ctx, "km-agent-cluster", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[restartedAtKey] == "" {
+		t.Error("Deployment rollout not triggered")
+	}
+
+	shop, err := client.AppsV1().Deployments("default").Get(ctx, "shop", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shop.Spec.Template.Annotations[javaInjectKey]; got != javaInjectValue {
+		t.Errorf("shop %s annotation = %q, want %q", javaInjectKey, got, javaInjectValue)
+	}
+}
+
+func TestK8sConfigUpdaterRemovesInstrumentation(t *testing.T) {
+	cp := kmtest.NewControlPlane(t)
+	u, client := newTestUpdater(t, cp, map[string]string{javaInjectKey: javaInjectValue, "team": "checkout"})
+	cp.Respond(K8sConfigUpdateResponse{
+		K8s: K8sApmConfig{APMEnabled: true, APMSettings: []APMConfig{
+			{Namespace: "default", Deployment: "shop", Kind: "Deployment", Enabled: false, Language: "Java"},
+		}},
+	})
+
+	ctx := context.Background()
+	if err := u.performConfigCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	shop, err := client.AppsV1().Deployments("default").Get(ctx, "shop", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := shop.Spec.Template.Annotations[javaInjectKey]; found {
+		t.Errorf("instrumentation annotation not removed: %v", shop.Spec.Template.Annotations)
+	}
+	if shop.Spec.Template.Annotations["team"] != "checkout" {
+		t.Errorf("unrelated annotations were removed: %v", shop.Spec.Template.Annotations)
+	}
+
+	// without a config change the agent workloads are left alone
+	ds, err := client.AppsV1().DaemonSets(testNamespace).Get(ctx, "km-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := ds.Spec.Template.Annotations[restartedAtKey]; found {
+		t.Error("DaemonSet restarted without a config change")
+	}
+}
+
+func TestK8sConfigUpdaterRejectedAPIKey(t *testing.T) {
+	cp := kmtest.NewControlPlane(t)
+	cp.RequireAPIKey("another-key")
+	u, client := newTestUpdater(t, cp, nil)
+	cp.Respond(K8sConfigUpdateResponse{
+		RestartRequired: true,
+		K8sAPIConfigs:   K8sOtelConfigs{DaemonSetConfig: map[string]interface{}{}, DeploymentConfig: map[string]interface{}{}},
+	})
+
+	err := u.performConfigCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the check to fail with 401, got %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" || action.GetVerb() == "patch" {
+			t.Errorf("cluster modified after a failed check: %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestK8sConfigUpdaterPolicyRejection(t *testing.T) {
+	cp := kmtest.NewControlPlane(t)
+	u, client := newTestUpdater(t, cp, nil)
+	u.policy = &policy.Policy{Components: policy.Rules{Deny: []string{"exec"}}}
+	cp.Respond(K8sConfigUpdateResponse{
+		RestartRequired: true,
+		K8sAPIConfigs: K8sOtelConfigs{
+			DaemonSetConfig:  map[string]interface{}{"receivers": map[string]interface{}{"exec": map[string]interface{}{}}},
+			DeploymentConfig: map[string]interface{}{"receivers": map[string]interface{}{"k8s_cluster": map[string]interface{}{}}},
+		},
+		K8s: K8sApmConfig{APMEnabled: true, APMSettings: []APMConfig{
+			{Namespace: "default", Deployment: "shop", Kind: "Deployment", Enabled: true, Language: "Java"},
+		}},
+	})
+
+	ctx := context.Background()
+	if err := u.performConfigCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !policy.IsViolation(u.configRejection) {
+		t.Errorf("rejection not recorded: %v", u.configRejection)
+	}
+	ds, err := client.CoreV1().ConfigMaps(testNamespace).Get(ctx, "km-agent-configmap-daemonset", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Data["agent-daemonset.yaml"] != "{}" {
+		t.Errorf("rejected config written: %v", ds.Data)
+	}
+	daemonSet, err := client.AppsV1().DaemonSets(testNamespace).Get(ctx, "km-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := daemonSet.Spec.Template.Annotations[restartedAtKey]; found {
+		t.Error("DaemonSet restarted for a rejected config")
+	}
+	// APM settings are applied whatever happens to the collector configs
+	shop, err := client.AppsV1().Deployments("default").Get(ctx, "shop", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shop.Spec.Template.Annotations[javaInjectKey]; got != javaInjectValue {
+		t.Errorf("shop %s annotation = %q, want %q", javaInjectKey, got, javaInjectValue)
+	}
+
+	// the next config that passes clears the rejection
+	cp.Respond(K8sConfigUpdateResponse{
+		RestartRequired: true,
+		K8sAPIConfigs: K8sOtelConfigs{
+			DaemonSetConfig:  map[string]interface{}{"receivers": map[string]interface{}{"kubeletstats": map[string]interface{}{}}},
+			DeploymentConfig: map[string]interface{}{"receivers": map[string]interface{}{"k8s_cluster": map[string]interface{}{}}},
+		},
+	})
+	if err := u.performConfigCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if u.configRejection != nil {
+		t.Errorf("rejection kept after a config was applied: %v", u.configRejection)
+	}
+}
+
+func TestK8sConfigUpdaterReportsDetections(t *testing.T) {
+	cp := kmtest.NewControlPlane(t)
+	u, client := newTestUpdater(t, cp, nil)
+	ctx := context.Background()
+	worker := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "worker", Annotations: map[string]string{instrumentation.OverrideAnnotation: "python"},
+	}}
+	if _, err := client.AppsV1().Deployments("default").Create(ctx, worker, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	u.detections = func() []detector.ContainerInfo {
+		return []detector.ContainerInfo{
+			{Namespace: "default", DeploymentName: "shop", Kind: "Deployment", Language: "golang"},
+			{Namespace: "default", DeploymentName: "worker", Kind: "Deployment", Language: "Go"},
+			{Namespace: "kube-system", DeploymentName: "dns", Kind: "Deployment", Language: "Go"},
+		}
+	}
+	cp.Respond(K8sConfigUpdateResponse{K8s: K8sApmConfig{APMEnabled: true}})
+	if err := u.performConfigCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// kube-system is not monitored, worker is opted in by its annotation and instrumented unlisted
+	req := cp.WaitForRequest(time.Second, nil)
+	reported, _ := req.Body["k8s_deployments"].([]any)
+	want := map[string][3]any{
+		"shop":   {"Go", "operator", ""},
+		"worker": {"Python", "operator", "python"},
+	}
+	if len(reported) != len(want) {
+		t.Fatalf("k8s_deployments = %v, want %d detections", req.Body["k8s_deployments"], len(want))
+	}
+	for _, r := range reported {
+		app := r.(map[string]any)
+		w := want[app["deployment"].(string)]
+		override, _ := app["override"].(string)
+		if app["language"] != w[0] || app["method"] != w[1] || override != w[2] {
+			t.Errorf("%v reported as %v/%v/%q, want %v", app["deployment"], app["language"], app["method"], override, w)
+		}
+	}
+	dep, err := client.AppsV1().Deployments("default").Get(ctx, "worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := dep.Spec.Template.Annotations["instrumentation.opentelemetry.io/inject-python"]; !found {
+		t.Errorf("worker not instrumented: %v", dep.Spec.Template.Annotations)
+	}
+}
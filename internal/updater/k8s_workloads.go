@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// workloadCache keeps the workloads of the cluster in informers, so the labels and annotations
+// checked for every detection on every config check are read without API calls.
+type workloadCache struct {
+	deployments  appslisters.DeploymentLister
+	daemonSets   appslisters.DaemonSetLister
+	statefulSets appslisters.StatefulSetLister
+	replicaSets  appslisters.ReplicaSetLister
+	pods         corelisters.PodLister
+	synced       []cache.InformerSynced
+}
+
+// startWorkloadCache starts the informers, they run until ctx is done.
+func startWorkloadCache(ctx context.Context, client kubernetes.Interface) *workloadCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 10*time.Minute, informers.WithTransform(trimWorkload))
+	apps := factory.Apps().V1()
+	c := &workloadCache{
+		deployments:  apps.Deployments().Lister(),
+		daemonSets:   apps.DaemonSets().Lister(),
+		statefulSets: apps.StatefulSets().Lister(),
+		replicaSets:  apps.ReplicaSets().Lister(),
+		pods:         factory.Core().V1().Pods().Lister(),
+	}
+	c.synced = []cache.InformerSynced{
+		apps.Deployments().Informer().HasSynced,
+		apps.DaemonSets().Informer().HasSynced,
+		apps.StatefulSets().Informer().HasSynced,
+		apps.ReplicaSets().Informer().HasSynced,
+		factory.Core().V1().Pods().Informer().HasSynced,
+	}
+	factory.Start(ctx.Done())
+	return c
+}
+
+// wait blocks until the informers hold the workloads of the cluster or ctx is done.
+func (c *workloadCache) wait(ctx context.Context) error {
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("failed to sync workload informers: %w", ctx.Err())
+	}
+	return nil
+}
+
+// trimWorkload drops what the updater never reads from cached objects, pods only keep their
+// metadata since the cache holds every pod of the cluster.
+func trimWorkload(obj interface{}) (interface{}, error) {
+	if m, ok := obj.(v1.Object); ok {
+		m.SetManagedFields(nil)
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		return &corev1.Pod{ObjectMeta: pod.ObjectMeta}, nil
+	}
+	return obj, nil
+}
@@ -3,6 +3,8 @@ package updater
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,12 +12,18 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 
 	"github.com/kloudmate/km-agent/internal/config"
+	"github.com/kloudmate/km-agent/internal/inventory"
+	kmlogger "github.com/kloudmate/km-agent/internal/logger"
+	"github.com/kloudmate/km-agent/internal/pin"
+	"github.com/kloudmate/km-agent/internal/signature"
+	"github.com/kloudmate/km-agent/internal/upgrade"
 	"github.com/kloudmate/km-agent/internal/version"
 )
 
@@ -25,6 +33,7 @@ type ConfigUpdater struct {
 	logger     *zap.SugaredLogger
 	client     *http.Client
 	configPath string
+	verifier   *responseVerifier
 }
 
 type UpdateCheckerParams struct {
@@ -32,16 +41,31 @@ type UpdateCheckerParams struct {
 	AgentStatus        string
 	CollectorStatus    string
 	CollectorLastError string
+	Buffer             *BufferStatus
+	Upgrade            *upgrade.Status
+	Pin                *pin.State
+	Inventory          *inventory.Inventory
+}
+
+// BufferStatus reports the state of the persistent exporter buffer.
+type BufferStatus struct {
+	Dir        string `json:"dir"`
+	UsageBytes int64  `json:"usage_bytes"`
+	LimitBytes int64  `json:"limit_bytes"`
 }
 
 // ConfigUpdateResponse represents the response from the config update API
 type ConfigUpdateResponse struct {
 	RestartRequired bool                   `json:"restart_required"`
 	Config          map[string]interface{} `json:"config"`
+	// AgentUpgrade asks the agent to replace its binary with the given version
+	AgentUpgrade *upgrade.Instruction `json:"agent_upgrade,omitempty"`
+	// LogLevel changes the agent and collector log level, e.g. debug, without a restart
+	LogLevel string `json:"log_level,omitempty"`
 }
 
 // NewConfigUpdater creates a new config updater
-func NewConfigUpdater(cfg *config.Config, logger *zap.SugaredLogger) *ConfigUpdater {
+func NewConfigUpdater(cfg *config.Config, logger *zap.SugaredLogger) (*ConfigUpdater, error) {
 	// Determine config path
 	configPath := cfg.OtelConfigPath
 	if configPath == "" {
@@ -52,16 +76,27 @@ func NewConfigUpdater(cfg *config.Config, logger *zap.SugaredLogger) *ConfigUpda
 		}
 	}
 
+	transport, err := cfg.Network.NewTransport()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure control plane transport: %w", err)
+	}
+
+	verifier, err := loadVerifier(cfg.ConfigPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ConfigUpdater{
 		cfg:        cfg,
 		logger:     logger,
-		client:     &http.Client{Timeout: 30 * time.Second},
+		client:     &http.Client{Timeout: 30 * time.Second, Transport: transport},
 		configPath: configPath,
-	}
+		verifier:   verifier,
+	}, nil
 }
 
 // CheckForUpdates checks for configuration updates from the remote API
-func (u *ConfigUpdater) CheckForUpdates(ctx context.Context, p UpdateCheckerParams) (bool, map[string]interface{}, error) {
+func (u *ConfigUpdater) CheckForUpdates(ctx context.Context, p UpdateCheckerParams) (*ConfigUpdateResponse, error) {
 
 	platform := runtime.GOOS
 	if u.cfg.DockerMode {
@@ -78,6 +113,24 @@ func (u *ConfigUpdater) CheckForUpdates(ctx context.Context, p UpdateCheckerPara
 		"agent_status":       p.AgentStatus,
 		"collector_status":   p.CollectorStatus,
 		"last_error_message": p.CollectorLastError,
+		"config_pinned":      p.Pin != nil,
+		"log_level":          kmlogger.Level().String(),
+	}
+	if p.Buffer != nil {
+		data["buffer"] = p.Buffer
+	}
+	if p.Inventory != nil {
+		data["inventory"] = p.Inventory
+	}
+	if p.Pin != nil {
+		data["config_pin"] = p.Pin
+	}
+	if p.Upgrade != nil {
+		data["agent_upgrade"] = p.Upgrade
+	}
+	challenge := u.verifier.challenge(u.cfg.Hostname())
+	if challenge != nil {
+		data["nonce"] = challenge.nonce
 	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -89,35 +142,138 @@ func (u *ConfigUpdater) CheckForUpdates(ctx context.Context, p UpdateCheckerPara
 
 	req, err := http.NewRequestWithContext(reqCtx, "POST", u.cfg.ConfigUpdateURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return false, nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	// Add API key if configured
-	if u.cfg.APIKey != "" {
-		req.Header.Set("Authorization", u.cfg.APIKey)
+	if apiKey := u.cfg.CurrentAPIKey(); apiKey != "" {
+		req.Header.Set("Authorization", apiKey)
 	}
 
 	resp, respErr := u.client.Do(req)
 
 	if respErr != nil {
-		return false, nil, fmt.Errorf("failed to fetch config updates after retries: %w", respErr)
+		return nil, fmt.Errorf("failed to fetch config updates after retries: %w", respErr)
 	}
 	defer resp.Body.Close()
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return false, nil, fmt.Errorf("config update API returned non-OK status: %d, body: %s", resp.StatusCode, body)
+		return nil, fmt.Errorf("config update API returned non-OK status: %d, body: %s", resp.StatusCode, body)
+	}
+
+	body, err := readVerifiedBody(resp, u.verifier, challenge)
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse response
 	var updateResp ConfigUpdateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&updateResp); err != nil {
-		return false, nil, fmt.Errorf("failed to decode config update response: %w", err)
+	if err := json.Unmarshal(body, &updateResp); err != nil {
+		return nil, fmt.Errorf("failed to decode config update response: %w", err)
 	}
 
-	return updateResp.RestartRequired, updateResp.Config, nil
+	return &updateResp, nil
+}
+
+const (
+	// maxResponseAge is how old a signed response may be before it is treated as a replay
+	maxResponseAge = 5 * time.Minute
+	// maxClockSkew tolerates control plane clocks running ahead of the agent
+	maxClockSkew = time.Minute
+)
+
+// responseVerifier checks signed config check responses. Signed bodies carry issued_at and echo
+// the hostname and nonce of the request they answer, responses that are stale, older than the
+// last accepted one or meant for another request are refused so they cannot be replayed.
+type responseVerifier struct {
+	verifier *signature.Verifier
+	mu       sync.Mutex
+	last     time.Time
+}
+
+// responseChallenge is what a signed response has to echo to answer one config check request.
+type responseChallenge struct {
+	hostname string
+	nonce    string
+}
+
+// loadVerifier returns nil when no public key is pinned, which disables signature checks.
+func loadVerifier(publicKeyFile string) (*responseVerifier, error) {
+	if publicKeyFile == "" {
+		return nil, nil
+	}
+	v, err := signature.LoadVerifier(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config signing key: %w", err)
+	}
+	return &responseVerifier{verifier: v}, nil
+}
+
+// challenge returns a new nonce for a request from hostname, nil when responses are not verified.
+func (v *responseVerifier) challenge(hostname string) *responseChallenge {
+	if v == nil {
+		return nil
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return &responseChallenge{hostname: hostname, nonce: hex.EncodeToString(nonce)}
+}
+
+// readVerifiedBody reads the response body and, when a verifier is set, rejects it unless the
+// detached signature header matches the exact bytes received and the body answers c.
+func readVerifiedBody(resp *http.Response, v *responseVerifier, c *responseChallenge) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config update response: %w", err)
+	}
+	if v == nil {
+		return body, nil
+	}
+	if err := v.verifier.Verify(body, resp.Header.Get(signature.Header)); err != nil {
+		return nil, fmt.Errorf("refusing config update response: %w", err)
+	}
+	if err := v.checkClaims(body, c, time.Now()); err != nil {
+		return nil, fmt.Errorf("refusing config update response: %w", err)
+	}
+	return body, nil
+}
+
+// checkClaims accepts a signed body answering c, issued within maxResponseAge of now and not
+// before the last accepted one.
+func (v *responseVerifier) checkClaims(body []byte, c *responseChallenge, now time.Time) error {
+	var signed struct {
+		IssuedAt time.Time `json:"issued_at"`
+		Hostname string    `json:"hostname"`
+		Nonce    string    `json:"nonce"`
+	}
+	if err := json.Unmarshal(body, &signed); err != nil {
+		return fmt.Errorf("failed to decode signed claims: %w", err)
+	}
+	if signed.Nonce != c.nonce {
+		return fmt.Errorf("signed response does not answer this request, nonce %q", signed.Nonce)
+	}
+	if signed.Hostname != c.hostname {
+		return fmt.Errorf("signed response is for host %q, not %q", signed.Hostname, c.hostname)
+	}
+	if signed.IssuedAt.IsZero() {
+		return fmt.Errorf("signed response has no issued_at")
+	}
+	if age := now.Sub(signed.IssuedAt); age > maxResponseAge || age < -maxClockSkew {
+		return fmt.Errorf("signed response issued at %s is outside the accepted window", signed.IssuedAt.Format(time.RFC3339))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if signed.IssuedAt.Before(v.last) {
+		return fmt.Errorf("signed response issued at %s is older than the last accepted one", signed.IssuedAt.Format(time.RFC3339))
+	}
+	v.last = signed.IssuedAt
+	return nil
 }
 
 // ApplyConfig applies a new configuration by writing it to the config file
@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseVerifierRejectsReplays(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	v := &responseVerifier{}
+	first := v.challenge("web-1")
+	claims := func(c *responseChallenge, issued time.Time) []byte {
+		body, err := json.Marshal(map[string]interface{}{"restart_required": true, "hostname": c.hostname, "nonce": c.nonce, "issued_at": issued})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+	steps := []struct {
+		name      string
+		challenge *responseChallenge
+		body      []byte
+		wantErr   string
+	}{
+		{name: "fresh", challenge: first, body: claims(first, now.Add(-time.Minute))},
+		{name: "same response again", challenge: v.challenge("web-1"), body: claims(first, now.Add(-time.Minute)), wantErr: "does not answer this request"},
+		{name: "no nonce", challenge: v.challenge("web-1"), body: claims(&responseChallenge{hostname: "web-1"}, now), wantErr: "does not answer this request"},
+		{name: "missing issued_at", challenge: first, body: []byte(`{"hostname":"web-1","nonce":"` + first.nonce + `"}`), wantErr: "no issued_at"},
+		{name: "stale", challenge: first, body: claims(first, now.Add(-time.Hour)), wantErr: "outside the accepted window"},
+		{name: "from the future", challenge: first, body: claims(first, now.Add(time.Hour)), wantErr: "outside the accepted window"},
+		{name: "older than the last accepted", challenge: first, body: claims(first, now.Add(-2*time.Minute)), wantErr: "older than the last accepted"},
+	}
+	for _, step := range steps {
+		err := v.checkClaims(step.body, step.challenge, now)
+		if step.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", step.name, err)
+		}
+		if step.wantErr != "" && (err == nil || !strings.Contains(err.Error(), step.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", step.name, err, step.wantErr)
+		}
+	}
+
+	// a response signed for another host is refused even with the right nonce
+	other := v.challenge("web-2")
+	if err := v.checkClaims(claims(&responseChallenge{hostname: "web-1", nonce: other.nonce}, now), other, now); err == nil || !strings.Contains(err.Error(), "is for host") {
+		t.Errorf("response for another host: error = %v", err)
+	}
+	next := v.challenge("web-1")
+	if err := v.checkClaims(claims(next, now), next, now); err != nil {
+		t.Errorf("newer response refused: %v", err)
+	}
+	if first.nonce == next.nonce {
+		t.Error("challenges reuse their nonce")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// RestartService restarts the agent through the service manager so the new binary is executed.
+func RestartService(s service.Service) error {
+	if service.Interactive() {
+		return fmt.Errorf("agent is not running under the service manager")
+	}
+	if runtime.GOOS == "darwin" {
+		// launchd restarts by unloading the job, which kills the caller before the load.
+		// Exit instead and let KeepAlive start the new binary.
+		go func() {
+			time.Sleep(time.Second)
+			os.Exit(1)
+		}()
+		return nil
+	}
+	return s.Restart()
+}
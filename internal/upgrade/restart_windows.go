@@ -0,0 +1,27 @@
+//go:build windows
+
+package upgrade
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"github.com/kardianos/service"
+)
+
+// RestartService restarts the agent through the service control manager. Stopping the
+// service from inside the service would end the process before it is started again,
+// so the restart is handed to a detached helper.
+func RestartService(s service.Service) error {
+	if service.Interactive() {
+		return fmt.Errorf("agent is not running under the service manager")
+	}
+	name := s.String()
+	cmd := exec.Command("cmd.exe", "/C", fmt.Sprintf("net stop %s & net start %s", name, name))
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x00000008 | syscall.CREATE_NEW_PROCESS_GROUP} // DETACHED_PROCESS
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start restart helper: %w", err)
+	}
+	return cmd.Process.Release()
+}
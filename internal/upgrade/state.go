@@ -0,0 +1,144 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFile = "upgrade.json"
+
+const (
+	StatePending    = "pending"
+	StateSucceeded  = "succeeded"
+	StateRolledBack = "rolled_back"
+	StateFailed     = "failed"
+)
+
+// Status is the outcome of the last upgrade, persisted across restarts and reported to the control plane.
+type Status struct {
+	State         string    `json:"state"`
+	FromVersion   string    `json:"from_version"`
+	TargetVersion string    `json:"target_version"`
+	Error         string    `json:"error,omitempty"`
+	Attempts      int       `json:"attempts"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// Failures counts consecutive failed attempts to install TargetVersion
+	Failures int `json:"failures,omitempty"`
+}
+
+// Status returns the last recorded upgrade outcome, nil when the agent was never upgraded.
+func (u *Upgrader) Status() *Status {
+	st, err := u.loadState()
+	if err != nil {
+		u.logger.Warnw("failed to read upgrade state", "error", err)
+	}
+	return st
+}
+
+// Resume is called on startup and reports whether the running binary is an unconfirmed
+// upgrade. A binary that keeps crashing before it is confirmed is rolled back.
+func (u *Upgrader) Resume() (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st, err := u.loadState()
+	if err != nil || st == nil || st.State != StatePending {
+		return false, err
+	}
+	if st.TargetVersion != u.version {
+		// the new binary never came up, the service manager is still running the old one
+		st.State = StateFailed
+		st.Error = fmt.Sprintf("agent restarted with version %s instead of %s", u.version, st.TargetVersion)
+		return false, u.saveState(st)
+	}
+
+	st.Attempts++
+	if st.Attempts > u.maxAttempts {
+		return false, u.rollback(st, fmt.Errorf("agent restarted %d times without becoming healthy", st.Attempts-1))
+	}
+	return true, u.saveState(st)
+}
+
+// Confirm marks the pending upgrade as successful and removes the previous binary.
+func (u *Upgrader) Confirm() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st, err := u.loadState()
+	if err != nil || st == nil || st.State != StatePending {
+		return err
+	}
+	st.State = StateSucceeded
+	if err := u.saveState(st); err != nil {
+		return err
+	}
+	if err := os.Remove(u.exePath + previousSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		u.logger.Warnw("failed to remove previous agent binary", "error", err)
+	}
+	u.logger.Infow("agent upgrade confirmed", "version", st.TargetVersion)
+	return nil
+}
+
+// Rollback restores the previous binary after a failed health check and restarts the agent.
+func (u *Upgrader) Rollback(reason error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st, err := u.loadState()
+	if err != nil || st == nil || st.State != StatePending {
+		return err
+	}
+	return u.rollback(st, reason)
+}
+
+func (u *Upgrader) rollback(st *Status, reason error) error {
+	u.logger.Errorw("rolling back agent upgrade", "from", st.TargetVersion, "to", st.FromVersion, "reason", reason)
+	if _, err := os.Stat(u.exePath + previousSuffix); err != nil {
+		return fmt.Errorf("cannot roll back, previous binary missing: %w", err)
+	}
+	u.restorePrevious()
+	st.State = StateRolledBack
+	st.Error = reason.Error()
+	if err := u.saveState(st); err != nil {
+		return err
+	}
+	if u.restart == nil {
+		return nil
+	}
+	return u.restart()
+}
+
+func (u *Upgrader) loadState() (*Status, error) {
+	data, err := os.ReadFile(filepath.Join(u.stateDir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st Status
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse upgrade state: %w", err)
+	}
+	return &st, nil
+}
+
+func (u *Upgrader) saveState(st *Status) error {
+	if err := os.MkdirAll(u.stateDir, 0750); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	st.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(u.stateDir, stateFile)
+	if err := os.WriteFile(path+".new", data, 0640); err != nil {
+		return fmt.Errorf("failed to write upgrade state: %w", err)
+	}
+	return os.Rename(path+".new", path)
+}
@@ -0,0 +1,289 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kloudmate/km-agent/internal/signature"
+	"go.uber.org/zap"
+)
+
+const (
+	stagedSuffix   = ".staged"
+	previousSuffix = ".previous"
+	// maxBinarySize guards against filling the disk with a bad download
+	maxBinarySize = 512 << 20
+	// failedBackoff is how long a failed version is not retried, doubled per failure up to maxFailedBackoff
+	failedBackoff    = time.Hour
+	maxFailedBackoff = 24 * time.Hour
+)
+
+// ErrInProgress is returned when an upgrade is already being applied.
+var ErrInProgress = errors.New("agent upgrade already in progress")
+
+// Instruction is the upgrade target sent by the control plane in the config check response.
+type Instruction struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+	// Signature is the base64 Ed25519ph signature of the binary by the pinned upgrade key
+	Signature string `json:"signature"`
+}
+
+// Upgrader downloads, verifies and swaps the agent binary, and rolls it back when the
+// new version does not become healthy after the restart.
+type Upgrader struct {
+	version     string
+	client      *http.Client
+	logger      *zap.SugaredLogger
+	exePath     string
+	stateDir    string
+	restart     func() error
+	verifier    *signature.Verifier
+	maxAttempts int
+	mu          sync.Mutex
+}
+
+type Option func(u *Upgrader)
+
+// WithExecutable overrides the binary that is replaced, defaults to os.Executable.
+func WithExecutable(path string) Option {
+	return func(u *Upgrader) {
+		u.exePath = path
+	}
+}
+
+// WithStateDir sets the directory holding the upgrade marker.
+func WithStateDir(dir string) Option {
+	return func(u *Upgrader) {
+		u.stateDir = dir
+	}
+}
+
+// WithRestartFunc sets how the agent restarts itself after the binary is swapped.
+func WithRestartFunc(fn func() error) Option {
+	return func(u *Upgrader) {
+		u.restart = fn
+	}
+}
+
+// New creates an upgrader for the running agent version. Binaries are only installed when
+// signed by verifier, which must hold an Ed25519 key.
+func New(version string, client *http.Client, verifier *signature.Verifier, logger *zap.SugaredLogger, opts ...Option) (*Upgrader, error) {
+	if verifier == nil || !verifier.Ed25519() {
+		return nil, fmt.Errorf("agent upgrades require an Ed25519 public key")
+	}
+	u := &Upgrader{
+		version:     version,
+		client:      client,
+		verifier:    verifier,
+		logger:      logger,
+		maxAttempts: 3,
+	}
+	for _, o := range opts {
+		o(u)
+	}
+	if u.exePath == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate agent executable: %w", err)
+		}
+		u.exePath = exe
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			u.exePath = resolved
+		}
+	}
+	if u.stateDir == "" {
+		u.stateDir = filepath.Dir(u.exePath)
+	}
+	return u, nil
+}
+
+// Apply stages and verifies the binary for the instruction, swaps it in place of the running
+// one and restarts the agent. The previous binary is kept until the new one reports healthy.
+func (u *Upgrader) Apply(ctx context.Context, ins Instruction) error {
+	if !u.mu.TryLock() {
+		return ErrInProgress
+	}
+	defer u.mu.Unlock()
+
+	if err := u.validate(ins); err != nil {
+		return err
+	}
+
+	u.logger.Infow("upgrading agent", "from", u.version, "to", ins.Version, "url", ins.URL)
+
+	staged := u.exePath + stagedSuffix
+	defer os.Remove(staged)
+
+	if err := u.download(ctx, ins, staged); err != nil {
+		return u.fail(ins, err)
+	}
+	if err := u.preflight(ctx, ins, staged); err != nil {
+		return u.fail(ins, err)
+	}
+	if err := u.swap(staged); err != nil {
+		return u.fail(ins, err)
+	}
+
+	st := &Status{State: StatePending, FromVersion: u.version, TargetVersion: ins.Version}
+	if err := u.saveState(st); err != nil {
+		u.restorePrevious()
+		return u.fail(ins, err)
+	}
+
+	if u.restart == nil {
+		u.logger.Warnw("no restart function configured, new agent binary is used on the next start", "version", ins.Version)
+		return nil
+	}
+	u.logger.Infow("agent binary replaced, restarting", "version", ins.Version)
+	if err := u.restart(); err != nil {
+		u.restorePrevious()
+		return u.fail(ins, fmt.Errorf("failed to restart agent: %w", err))
+	}
+	return nil
+}
+
+// validate rejects instructions that are incomplete, already applied, were rolled back before or
+// failed recently.
+func (u *Upgrader) validate(ins Instruction) error {
+	if ins.Version == "" || ins.URL == "" {
+		return fmt.Errorf("upgrade instruction requires version and url")
+	}
+	if _, err := hex.DecodeString(ins.SHA256); err != nil || len(ins.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("upgrade instruction has an invalid sha256 checksum")
+	}
+	if ins.Signature == "" {
+		return fmt.Errorf("upgrade instruction is not signed")
+	}
+	if ins.Version == u.version {
+		return fmt.Errorf("agent is already running version %s", ins.Version)
+	}
+	st, _ := u.loadState()
+	if st == nil || st.TargetVersion != ins.Version {
+		return nil
+	}
+	switch st.State {
+	case StateRolledBack:
+		return fmt.Errorf("version %s was rolled back: %s", ins.Version, st.Error)
+	case StateFailed:
+		if retry := st.UpdatedAt.Add(retryBackoff(st.Failures)); time.Now().Before(retry) {
+			return fmt.Errorf("version %s failed %d times, not retrying before %s: %s", ins.Version, st.Failures, retry.Format(time.RFC3339), st.Error)
+		}
+	}
+	return nil
+}
+
+// retryBackoff is how long to wait before retrying a version that failed failures times.
+func retryBackoff(failures int) time.Duration {
+	backoff := failedBackoff
+	for i := 1; i < failures && backoff < maxFailedBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxFailedBackoff)
+}
+
+// download fetches the binary to the staging path and verifies its checksum and signature.
+func (u *Upgrader) download(ctx context.Context, ins Instruction, staged string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ins.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %w", err)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download agent binary: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent binary download returned non-OK status: %d", resp.StatusCode)
+	}
+
+	f, err := os.OpenFile(staged, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	h := sha256.New()
+	prehash := sha512.New()
+	n, err := io.Copy(io.MultiWriter(f, h, prehash), io.LimitReader(resp.Body, maxBinarySize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write staging file: %w", err)
+	}
+	if n > maxBinarySize {
+		return fmt.Errorf("agent binary exceeds %d bytes", maxBinarySize)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, ins.SHA256) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", ins.SHA256, sum)
+	}
+	// the binary is executed by preflight, so it must be signed by the pinned key first
+	if err := u.verifier.VerifyPrehashed(prehash.Sum(nil), ins.Signature); err != nil {
+		return fmt.Errorf("agent binary signature: %w", err)
+	}
+	return nil
+}
+
+// preflight runs the staged binary to make sure it executes on this host and reports the expected version.
+func (u *Upgrader) preflight(ctx context.Context, ins Instruction, staged string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, staged, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("staged binary failed to run: %w: %s", err, out)
+	}
+	if !strings.Contains(string(out), ins.Version) {
+		return fmt.Errorf("staged binary reports %q, expected version %s", strings.TrimSpace(string(out)), ins.Version)
+	}
+	return nil
+}
+
+// swap moves the running binary aside and renames the staged one into its place. Renaming
+// a running executable is allowed on both unix and windows, deleting it is not.
+func (u *Upgrader) swap(staged string) error {
+	previous := u.exePath + previousSuffix
+	_ = os.Remove(previous)
+	if err := os.Rename(u.exePath, previous); err != nil {
+		return fmt.Errorf("failed to move current binary aside: %w", err)
+	}
+	if err := os.Rename(staged, u.exePath); err != nil {
+		u.restorePrevious()
+		return fmt.Errorf("failed to install new binary: %w", err)
+	}
+	return nil
+}
+
+// restorePrevious puts the previous binary back in place.
+func (u *Upgrader) restorePrevious() {
+	previous := u.exePath + previousSuffix
+	if _, err := os.Stat(previous); err != nil {
+		return
+	}
+	if err := os.Rename(previous, u.exePath); err != nil {
+		u.logger.Errorw("failed to restore previous agent binary", "path", previous, "error", err)
+	}
+}
+
+func (u *Upgrader) fail(ins Instruction, err error) error {
+	st := &Status{State: StateFailed, FromVersion: u.version, TargetVersion: ins.Version, Error: err.Error(), Failures: 1}
+	if prev, _ := u.loadState(); prev != nil && prev.State == StateFailed && prev.TargetVersion == ins.Version {
+		st.Failures = prev.Failures + 1
+	}
+	if saveErr := u.saveState(st); saveErr != nil {
+		u.logger.Warnw("failed to record upgrade state", "error", saveErr)
+	}
+	return fmt.Errorf("agent upgrade to %s failed: %w", ins.Version, err)
+}
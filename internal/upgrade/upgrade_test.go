@@ -0,0 +1,161 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kloudmate/km-agent/internal/signature"
+	"go.uber.org/zap/zaptest"
+)
+
+// newKey returns a signing key and a verifier pinning its public half.
+func newKey(t *testing.T) (ed25519.PrivateKey, *signature.Verifier) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := signature.NewVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, v
+}
+
+// sign returns the Ed25519ph signature of binary.
+func sign(t *testing.T, key ed25519.PrivateKey, binary []byte) string {
+	t.Helper()
+	digest := sha512.Sum512(binary)
+	sig, err := key.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// serveBinary serves binary from a local HTTP stand-in for the download server and counts requests.
+func serveBinary(t *testing.T, binary []byte) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(binary)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/kmagent", &hits
+}
+
+func TestApply(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho kmagent 2.0.0\n")
+	sum := sha256.Sum256(binary)
+	key, verifier := newKey(t)
+	otherKey, _ := newKey(t)
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   string
+	}{
+		{name: "signed", signature: sign(t, key, binary)},
+		{name: "unsigned", wantErr: "not signed"},
+		{name: "signed by another key", signature: sign(t, otherKey, binary), wantErr: "signature"},
+		{name: "signature of another binary", signature: sign(t, key, []byte("evil")), wantErr: "signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			exe := filepath.Join(dir, "kmagent")
+			if err := os.WriteFile(exe, []byte("#!/bin/sh\necho kmagent 1.0.0\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			url, _ := serveBinary(t, binary)
+			restarted := false
+			u, err := New("1.0.0", http.DefaultClient, verifier, zaptest.NewLogger(t).Sugar(),
+				WithExecutable(exe), WithStateDir(dir), WithRestartFunc(func() error { restarted = true; return nil }))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = u.Apply(context.Background(), Instruction{Version: "2.0.0", URL: url, SHA256: hex.EncodeToString(sum[:]), Signature: tt.signature})
+			installed, _ := os.ReadFile(exe)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply() error = %v, want %q", err, tt.wantErr)
+				}
+				if string(installed) == string(binary) || restarted {
+					t.Error("rejected binary was installed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(installed) != string(binary) || !restarted {
+				t.Errorf("binary installed = %v, restarted = %v", string(installed) == string(binary), restarted)
+			}
+			if st := u.Status(); st == nil || st.State != StatePending || st.TargetVersion != "2.0.0" {
+				t.Errorf("status = %+v, want pending 2.0.0", st)
+			}
+		})
+	}
+}
+
+func TestApplyBacksOffFailedVersion(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho kmagent 1.5.0\n")
+	sum := sha256.Sum256(binary)
+	key, verifier := newKey(t)
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "kmagent")
+	if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	url, hits := serveBinary(t, binary)
+	u, err := New("1.0.0", http.DefaultClient, verifier, zaptest.NewLogger(t).Sugar(), WithExecutable(exe), WithStateDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the binary reports the wrong version, so preflight fails
+	ins := Instruction{Version: "2.0.0", URL: url, SHA256: hex.EncodeToString(sum[:]), Signature: sign(t, key, binary)}
+	if err := u.Apply(context.Background(), ins); err == nil {
+		t.Fatal("Apply() succeeded with a binary reporting the wrong version")
+	}
+	if st := u.Status(); st == nil || st.State != StateFailed || st.Failures != 1 {
+		t.Fatalf("status = %+v, want one failure", st)
+	}
+	if err := u.Apply(context.Background(), ins); err == nil || !strings.Contains(err.Error(), "not retrying") {
+		t.Fatalf("Apply() error = %v, want a backoff", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("binary downloaded %d times, want 1", got)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for failures, want := range map[int]string{1: "1h0m0s", 2: "2h0m0s", 4: "8h0m0s", 10: "24h0m0s"} {
+		if got := retryBackoff(failures).String(); got != want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
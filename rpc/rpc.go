@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/kloudmate/km-agent/internal/telemetry"
 	"github.com/kloudmate/polylang-detector/detector"
 	// dependency to polylang-detector for rpc calls
 )
@@ -23,6 +24,8 @@ func (h *RPCHandler) PushDetectionResults(results []detector.ContainerInfo, repl
 		DetectionCache[key] = info
 		fmt.Printf("Stored result for container '%s'.\n", info.ContainerName)
 	}
+	telemetry.DetectionsReceived.Add(float64(len(results)))
+	telemetry.DetectionCacheSize.Set(float64(len(DetectionCache)))
 	*reply = fmt.Sprintf("Successfully processed %d results and stored in cache.", len(results))
 	return nil
 }
@@ -55,6 +58,7 @@ func AutoCleanDetectionResults() {
 			}
 		}
 		DetectionCache = newCache
+		telemetry.DetectionCacheSize.Set(float64(len(newCache)))
 		cacheMutex.Unlock()
 		log.Printf("Cache cleanup: %d entries remaining", len(newCache))
 	}
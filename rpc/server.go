@@ -6,6 +6,7 @@ import (
 	"net/rpc"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/kloudmate/polylang-detector/detector"
 )
@@ -13,8 +14,14 @@ import (
 var (
 	DetectionCache = make(map[string]detector.ContainerInfo)
 	cacheMutex     sync.Mutex
+	listening      atomic.Bool
 )
 
+// IsListening reports whether the RPC server has bound its listener.
+func IsListening() bool {
+	return listening.Load()
+}
+
 // StartRpcServer starts the RPC server.
 func StartRpcServer() {
 	// Register the RPC handler
@@ -27,6 +34,8 @@ func StartRpcServer() {
 		log.Fatalf("Error starting RPC server: %v", err)
 	}
 	defer listener.Close()
+	listening.Store(true)
+	defer listening.Store(false)
 
 	go AutoCleanDetectionResults()
 	// Accept connections and serve them concurrently